@@ -0,0 +1,487 @@
+package algo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/oms"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+)
+
+var (
+	ErrInvalid  = errors.New("invalid algo order")
+	ErrNotFound = errors.New("algo order not found")
+	ErrState    = errors.New("algo order can't do that in its current state")
+)
+
+const (
+	MaxSlices   = 1000
+	MinInterval = time.Second
+	tick        = 250 * time.Millisecond
+)
+
+// child order ids live in their own space so they never collide with the ids
+// clients pick: bit 62 set, then the algo id, then the slice number. (not bit
+// 63, sqlite only stores signed 64 bit integers)
+const (
+	childIDBit   = uint64(1) << 62
+	childIDShift = 20 // room for MaxSlices
+)
+
+func childOrderID(algoID uint64, slice int) uint64 {
+	return childIDBit | algoID<<childIDShift | uint64(slice)
+}
+
+// Params describes a parent order. TWAP sends Slices equal slices over
+// Duration, VWAP sends one slice per entry of Profile sized by its weight.
+type Params struct {
+	User_id    uint64
+	Strategy   string
+	Symbol     uint32
+	Side       uint8
+	Order_type uint8  // market or limit, children are IOC
+	Price      uint64 // limit price in ticks, 0 for market
+	Total_qty  uint64
+	Duration   time.Duration
+	Slices     int
+	Profile    []uint64
+	Start_at   time.Time // zero starts right away
+}
+
+type state struct {
+	order    *db.AlgoOrder
+	children map[orders.Key]*db.AlgoChild
+}
+
+var (
+	mu       sync.Mutex
+	algos    = make(map[uint64]*state)
+	children = make(map[orders.Key]uint64)
+)
+
+// Load picks up running and paused algos after a restart
+func Load() error {
+	live, err := db.LoadLiveAlgoOrders()
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, a := range live {
+		s := &state{order: a, children: make(map[orders.Key]*db.AlgoChild)}
+		kids, err := db.ListAlgoChildren(a.ID)
+		if err != nil {
+			return err
+		}
+		for i := range kids {
+			if err := reconcile(&kids[i]); err != nil {
+				return err
+			}
+			s.track(&kids[i])
+		}
+		algos[a.ID] = s
+	}
+	log.Printf("algo: loaded %d live algo orders", len(live))
+	return nil
+}
+
+// reconcile catches a child up with its order after a restart. a child
+// stored but never sent has no order and is rejected, one whose order was
+// closed while the gateway was down takes the order's status.
+func reconcile(c *db.AlgoChild) error {
+	if isDone(c) {
+		return nil
+	}
+	record, err := db.GetOrder(c.User_id, c.Order_id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.Status = structs.StatusRejected
+	case err != nil:
+		return err
+	case record.Status == c.Status:
+		return nil
+	default:
+		c.Status = record.Status
+	}
+	return db.UpdateAlgoChild(c)
+}
+
+func (s *state) track(c *db.AlgoChild) {
+	key := orders.Key{User_id: c.User_id, Order_id: c.Order_id}
+	s.children[key] = c
+	children[key] = c.Algo_id
+}
+
+func (p Params) validate() ([]uint64, error) {
+	if p.Symbol == 0 {
+		return nil, fmt.Errorf("%w: symbol must be specified", ErrInvalid)
+	}
+	if p.Side > 1 {
+		return nil, fmt.Errorf("%w: side must be 0 (buy) or 1 (sell)", ErrInvalid)
+	}
+	switch p.Order_type {
+	case structs.OrderTypeMarket:
+		if p.Price != 0 {
+			return nil, fmt.Errorf("%w: price is only allowed for limit algos", ErrInvalid)
+		}
+	case structs.OrderTypeLimit:
+		if p.Price == 0 {
+			return nil, fmt.Errorf("%w: price must be > 0 for limit algos", ErrInvalid)
+		}
+	default:
+		return nil, fmt.Errorf("%w: order_type must be 0 (market) or 1 (limit)", ErrInvalid)
+	}
+	if p.Total_qty == 0 {
+		return nil, fmt.Errorf("%w: shares_qty must be > 0", ErrInvalid)
+	}
+
+	var weights []uint64
+	switch p.Strategy {
+	case db.AlgoTWAP:
+		if len(p.Profile) != 0 {
+			return nil, fmt.Errorf("%w: volume_profile is only used by vwap", ErrInvalid)
+		}
+		if p.Slices < 1 || p.Slices > MaxSlices {
+			return nil, fmt.Errorf("%w: slices must be between 1 and %d", ErrInvalid, MaxSlices)
+		}
+		weights = make([]uint64, p.Slices)
+		for i := range weights {
+			weights[i] = 1
+		}
+	case db.AlgoVWAP:
+		if p.Slices != 0 {
+			return nil, fmt.Errorf("%w: vwap takes one slice per volume_profile entry, leave slices out", ErrInvalid)
+		}
+		if len(p.Profile) < 1 || len(p.Profile) > MaxSlices {
+			return nil, fmt.Errorf("%w: volume_profile must have between 1 and %d entries", ErrInvalid, MaxSlices)
+		}
+		var sum uint64
+		for _, w := range p.Profile {
+			var carry uint64
+			sum, carry = bits.Add64(sum, w, 0)
+			if carry != 0 {
+				return nil, fmt.Errorf("%w: volume_profile too large", ErrInvalid)
+			}
+		}
+		if sum == 0 {
+			return nil, fmt.Errorf("%w: volume_profile must not be all zero", ErrInvalid)
+		}
+		weights = p.Profile
+	default:
+		return nil, fmt.Errorf("%w: strategy must be twap or vwap", ErrInvalid)
+	}
+
+	if p.Duration/time.Duration(len(weights)) < MinInterval {
+		return nil, fmt.Errorf("%w: duration must leave at least %s between slices", ErrInvalid, MinInterval)
+	}
+	return weights, nil
+}
+
+// Start stores a parent order and schedules its slices
+func Start(p Params) (*db.AlgoOrder, error) {
+	weights, err := p.validate()
+	if err != nil {
+		return nil, err
+	}
+	start := p.Start_at
+	if start.IsZero() || start.Before(time.Now()) {
+		start = time.Now()
+	}
+
+	a := &db.AlgoOrder{
+		User_id:     p.User_id,
+		Strategy:    p.Strategy,
+		Symbol:      p.Symbol,
+		Side:        p.Side,
+		Order_type:  p.Order_type,
+		Price:       p.Price,
+		Total_qty:   p.Total_qty,
+		Weights:     weights,
+		Interval_ns: int64(p.Duration / time.Duration(len(weights))),
+		Next_at:     start.UnixNano(),
+		Status:      db.AlgoRunning,
+	}
+	if err := db.CreateAlgoOrder(a); err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	algos[a.ID] = &state{order: a, children: make(map[orders.Key]*db.AlgoChild)}
+	mu.Unlock()
+	return a, nil
+}
+
+// Pause stops sending slices, children already out are left alone
+func Pause(userID, algoID uint64) (*db.AlgoOrder, error) {
+	return transition(userID, algoID, func(s *state) error {
+		if s.order.Status != db.AlgoRunning {
+			return ErrState
+		}
+		s.order.Status = db.AlgoPaused
+		return nil
+	})
+}
+
+// Resume picks up where a paused algo left off, the remaining slices keep
+// their spacing starting now
+func Resume(userID, algoID uint64) (*db.AlgoOrder, error) {
+	return transition(userID, algoID, func(s *state) error {
+		if s.order.Status != db.AlgoPaused {
+			return ErrState
+		}
+		s.order.Status = db.AlgoRunning
+		s.order.Next_at = time.Now().UnixNano()
+		return nil
+	})
+}
+
+// Cancel stops the algo for good and pulls its working children
+func Cancel(userID, algoID uint64) (*db.AlgoOrder, error) {
+	return transition(userID, algoID, func(s *state) error {
+		if s.order.Status != db.AlgoRunning && s.order.Status != db.AlgoPaused {
+			return ErrState
+		}
+		s.order.Status = db.AlgoCancelled
+		for _, c := range s.children {
+			if isDone(c) {
+				continue
+			}
+			var cancelOrder structs.OrderToBeCancelled
+			cancelOrder.Order_id = c.Order_id
+			cancelOrder.User_id = c.User_id
+			cancelOrder.Symbol = s.order.Symbol
+			if err := oms.Cancel(cancelOrder, "algo cancelled"); err != nil {
+				log.Printf("algo: failed to cancel child %d of algo %d: %v", c.Order_id, s.order.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+func transition(userID, algoID uint64, fn func(s *state) error) (*db.AlgoOrder, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, ok := algos[algoID]
+	if !ok || s.order.User_id != userID {
+		// finished algos are no longer in memory, tell them apart from unknown ids
+		if _, err := db.GetAlgoOrder(userID, algoID); err == nil {
+			return nil, ErrState
+		}
+		return nil, ErrNotFound
+	}
+	if err := fn(s); err != nil {
+		return nil, err
+	}
+	s.save()
+	s.finish()
+	order := *s.order
+	return &order, nil
+}
+
+func (s *state) save() {
+	if err := db.UpdateAlgoOrder(s.order); err != nil {
+		log.Printf("algo: failed to store algo %d: %v", s.order.ID, err)
+	}
+}
+
+// finish drops an algo from memory once it is stopped and nothing is working
+func (s *state) finish() {
+	if s.order.Status == db.AlgoRunning || s.order.Status == db.AlgoPaused {
+		return
+	}
+	for _, c := range s.children {
+		if !isDone(c) {
+			return
+		}
+	}
+	delete(algos, s.order.ID)
+	for key := range s.children {
+		delete(children, key)
+	}
+}
+
+func isDone(c *db.AlgoChild) bool {
+	return c.Status == structs.StatusFilled || c.Status == structs.StatusRejected || c.Status == structs.StatusCancelled
+}
+
+// committed is what already executed or may still execute: children the
+// engine reported filled count in full so a fill still on its way can't make
+// the next slice oversized
+func (s *state) committed() uint64 {
+	var qty uint64
+	for _, c := range s.children {
+		switch c.Status {
+		case structs.StatusFilled:
+			qty += uint64(c.Qty)
+		case structs.StatusRejected, structs.StatusCancelled:
+			qty += uint64(c.Filled_qty)
+		default:
+			qty += uint64(c.Qty)
+		}
+	}
+	return qty
+}
+
+// target is the cumulative quantity the schedule wants done after slice n
+func (s *state) target(n int) uint64 {
+	a := s.order
+	if n >= len(a.Weights)-1 {
+		return a.Total_qty
+	}
+	var cum, sum uint64
+	for i, w := range a.Weights {
+		if i <= n {
+			cum += w
+		}
+		sum += w
+	}
+	hi, lo := bits.Mul64(a.Total_qty, cum)
+	q, _ := bits.Div64(hi, lo, sum) // cum <= sum so the quotient fits
+	return q
+}
+
+// StartScheduler sends due slices in the background
+func StartScheduler() {
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			runDue(now.UnixNano())
+		}
+	}()
+}
+
+func runDue(now int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, s := range algos {
+		if s.order.Status == db.AlgoRunning && s.order.Next_at <= now {
+			s.sendSlice(now)
+		}
+	}
+}
+
+// sendSlice sends the next child, sized to bring the algo back on schedule
+func (s *state) sendSlice(now int64) {
+	a := s.order
+	n := a.Next_slice
+	a.Next_slice++
+	a.Next_at = now + a.Interval_ns
+	defer func() {
+		if a.Next_slice >= len(a.Weights) {
+			a.Status = db.AlgoCompleted
+		}
+		s.save()
+		s.finish()
+	}()
+
+	want := s.target(n)
+	done := s.committed()
+	var qty uint64
+	if want > done {
+		qty = want - done
+	}
+	// round to the lot size, the last slice takes whatever is left
+	if lot := symbols.Lookup(a.Symbol).LotSize; lot > 1 && n < len(a.Weights)-1 {
+		qty -= qty % lot
+	}
+	if qty > uint64(^uint32(0)) {
+		qty = uint64(^uint32(0))
+	}
+	if qty == 0 {
+		return
+	}
+
+	c := &db.AlgoChild{
+		Algo_id:  a.ID,
+		User_id:  a.User_id,
+		Order_id: childOrderID(a.ID, n),
+		Slice:    n,
+		Qty:      uint32(qty),
+	}
+	order := structs.Order{
+		Order_id:      c.Order_id,
+		Price:         a.Price,
+		Timestamp:     uint64(now),
+		User_id:       a.User_id,
+		Shares_qty:    c.Qty,
+		Symbol:        a.Symbol,
+		Side:          a.Side,
+		Order_type:    a.Order_type,
+		Status:        structs.StatusPending,
+		Time_in_force: structs.TifIOC,
+	}
+
+	if err := db.InsertAlgoChild(c); err != nil {
+		a.Last_error = err.Error()
+		return
+	}
+	// tracked before sending, the engine can answer before Submit returns
+	s.track(c)
+	if err := oms.SubmitNow(order); err != nil {
+		log.Printf("algo: slice %d of algo %d not sent: %v", n, a.ID, err)
+		a.Last_error = err.Error()
+		c.Status = structs.StatusRejected
+		if err := db.UpdateAlgoChild(c); err != nil {
+			log.Printf("algo: failed to store child %d: %v", c.Order_id, err)
+		}
+	} else {
+		a.Sent_qty += qty
+		a.Last_error = ""
+	}
+}
+
+// OnStatus follows the children's engine status, registered with feeds.OnStatus
+func OnStatus(update structs.Order) {
+	key := orders.KeyOf(update)
+	mu.Lock()
+	defer mu.Unlock()
+
+	id, ok := children[key]
+	if !ok {
+		return
+	}
+	s := algos[id]
+	c := s.children[key]
+	if isDone(c) || update.Status == structs.StatusReplaced || update.Status == structs.StatusReplaceRejected {
+		return
+	}
+	c.Status = update.Status
+	if err := db.UpdateAlgoChild(c); err != nil {
+		log.Printf("algo: failed to store child %d: %v", c.Order_id, err)
+	}
+	s.finish()
+}
+
+// OnExecution books fills of children on their parent, registered with
+// oms.OnExecution. fills can trail the status that finished an algo, so they
+// are written to the database even when the algo is no longer in memory.
+func OnExecution(fill structs.Fill) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, key := range []orders.Key{
+		{User_id: fill.Buy_user_id, Order_id: fill.Buy_order_id},
+		{User_id: fill.Sell_user_id, Order_id: fill.Sell_order_id},
+	} {
+		if key.Order_id&childIDBit == 0 {
+			continue
+		}
+		if err := db.RecordAlgoFill(key.User_id, key.Order_id, fill.Shares_qty); err != nil {
+			log.Printf("algo: failed to book fill %d on child %d: %v", fill.Trade_id, key.Order_id, err)
+		}
+		if id, ok := children[key]; ok {
+			s := algos[id]
+			s.children[key].Filled_qty += fill.Shares_qty
+			s.order.Filled_qty += uint64(fill.Shares_qty)
+		}
+	}
+}
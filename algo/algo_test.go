@@ -0,0 +1,34 @@
+package algo
+
+import (
+	"testing"
+
+	"jotacomputing/go-api/db"
+)
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   uint64
+		weights []uint64
+		want    []uint64 // target after each slice
+	}{
+		{"twap", 10, []uint64{1, 1, 1, 1}, []uint64{2, 5, 7, 10}},
+		{"vwap", 100, []uint64{1, 2, 3, 4}, []uint64{10, 30, 60, 100}},
+		{"rounded down, last slice takes the rest", 9, []uint64{0, 5, 0, 5}, []uint64{0, 4, 4, 9}},
+		{"single slice", 7, []uint64{3}, []uint64{7}},
+		{"product above 64 bits", 1 << 40, []uint64{1 << 40, 1 << 40}, []uint64{1 << 39, 1 << 40}},
+	}
+	for _, tt := range tests {
+		s := &state{order: &db.AlgoOrder{Total_qty: tt.total, Weights: tt.weights}}
+		for n, want := range tt.want {
+			if got := s.target(n); got != want {
+				t.Errorf("%s: target(%d) = %d, want %d", tt.name, n, got, want)
+			}
+		}
+		// past the last slice the whole order is due
+		if got := s.target(len(tt.weights)); got != tt.total {
+			t.Errorf("%s: target past the end = %d, want %d", tt.name, got, tt.total)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"jotacomputing/go-api/db"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauth2errors "github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// the registry is the oauth_clients table held in memory. it is the token
+// server's client store and decides which grants and scopes a client may use.
+// secrets are random 32 byte strings, so a plain sha256 is enough to store
+// them.
+
+// grants the token server can serve, a client is limited to a subset
+var supportedGrants = map[string]bool{
+	oauth2.PasswordCredentials.String(): true,
+}
+
+// the client the apps used before the registry existed, created on first start
+const bootstrapClientID = "stock-app"
+
+var (
+	clientsMu sync.RWMutex
+	clients   = make(map[string]db.OAuthClient)
+)
+
+// LoadClients reads the registry, creating the bootstrap client on an empty
+// table. its secret comes from STOCK_APP_CLIENT_SECRET or is generated and
+// logged once.
+func LoadClients() error {
+	list, err := db.ListOAuthClients()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		secret := os.Getenv("STOCK_APP_CLIENT_SECRET")
+		generated := secret == ""
+		if generated {
+			if secret, err = newSecret(); err != nil {
+				return err
+			}
+		}
+		c := db.OAuthClient{
+			ID:            bootstrapClientID,
+			Name:          "stock app",
+			Secret_hash:   hashSecret(secret),
+			Redirect_uris: []string{"http://localhost:1323"},
+			Grants:        []string{oauth2.PasswordCredentials.String()},
+			Scopes:        knownScopes,
+		}
+		if err := db.InsertOAuthClient(&c); err != nil {
+			return err
+		}
+		if generated {
+			log.Printf("auth: created oauth client %s with secret %s, store it now, it isn't shown again", c.ID, secret)
+		}
+		list = append(list, c)
+	}
+
+	next := make(map[string]db.OAuthClient, len(list))
+	for _, c := range list {
+		next[c.ID] = c
+	}
+	clientsMu.Lock()
+	clients = next
+	clientsMu.Unlock()
+	return nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Clients returns every registered client, disabled ones included
+func Clients() []db.OAuthClient {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	list := make([]db.OAuthClient, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, c)
+	}
+	return list
+}
+
+// client returns an enabled client
+func client(id string) (db.OAuthClient, bool) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	c, ok := clients[id]
+	return c, ok && !c.Disabled
+}
+
+// ClientEnabled reports whether tokens of the client are still good
+func ClientEnabled(id string) bool {
+	_, ok := client(id)
+	return ok
+}
+
+// CreateClient validates and registers a client and returns its secret,
+// which is only ever known to the caller. an empty id is generated.
+func CreateClient(c db.OAuthClient) (db.OAuthClient, string, error) {
+	if c.ID == "" {
+		b := make([]byte, 12)
+		if _, err := rand.Read(b); err != nil {
+			return c, "", err
+		}
+		c.ID = hex.EncodeToString(b)
+	}
+	if err := validateClient(c); err != nil {
+		return c, "", err
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return c, "", err
+	}
+	c.Secret_hash = hashSecret(secret)
+	c.Disabled = false
+	if err := db.InsertOAuthClient(&c); err != nil {
+		return c, "", err
+	}
+	clientsMu.Lock()
+	clients[c.ID] = c
+	clientsMu.Unlock()
+	return c, secret, nil
+}
+
+func validateClient(c db.OAuthClient) error {
+	if len(c.ID) > 64 || strings.ContainsAny(c.ID, " \t\r\n:") {
+		return policyErrorf("client_id must be at most 64 characters without spaces or ':'")
+	}
+	if c.Name == "" {
+		return policyErrorf("name must be specified")
+	}
+	if len(c.Grants) == 0 {
+		return policyErrorf("at least one grant must be allowed")
+	}
+	for _, g := range c.Grants {
+		if !supportedGrants[g] {
+			return policyErrorf("grant %q is not supported", g)
+		}
+	}
+	for _, s := range c.Scopes {
+		if !knownScope(s) {
+			return policyErrorf("unknown scope %q, scopes are %s", s, strings.Join(knownScopes, ", "))
+		}
+	}
+	for _, uri := range c.Redirect_uris {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Fragment != "" || strings.ContainsAny(uri, " \t\r\n") {
+			return policyErrorf("redirect uri %q must be an absolute uri without a fragment", uri)
+		}
+	}
+	return nil
+}
+
+// RotateClientSecret gives a client a new secret, the old one stops working
+// at once. tokens already issued stay valid.
+func RotateClientSecret(id string) (string, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	c, ok := clients[id]
+	if !ok {
+		return "", ErrUnknownClient
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	h := hashSecret(secret)
+	if _, err := db.SetOAuthClientSecret(id, h); err != nil {
+		return "", err
+	}
+	c.Secret_hash = h
+	clients[id] = c
+	return secret, nil
+}
+
+// SetClientDisabled disables a client, which also refuses the tokens it was
+// issued, or enables it again
+func SetClientDisabled(id string, disabled bool) error {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	c, ok := clients[id]
+	if !ok {
+		return ErrUnknownClient
+	}
+	if _, err := db.SetOAuthClientDisabled(id, disabled); err != nil {
+		return err
+	}
+	c.Disabled = disabled
+	clients[id] = c
+	return nil
+}
+
+var ErrUnknownClient = errors.New("no such client")
+
+// ClientStore is the registry as the token server's client storage
+type ClientStore struct{}
+
+func (ClientStore) GetByID(ctx context.Context, id string) (oauth2.ClientInfo, error) {
+	c, ok := client(id)
+	if !ok {
+		return nil, oauth2errors.ErrInvalidClient
+	}
+	return clientInfo{c}, nil
+}
+
+type clientInfo struct {
+	c db.OAuthClient
+}
+
+func (ci clientInfo) GetID() string { return ci.c.ID }
+
+// the hash, the token server checks secrets through VerifyPassword
+func (ci clientInfo) GetSecret() string { return ci.c.Secret_hash }
+
+// every redirect uri separated by spaces, see ValidateRedirectURI
+func (ci clientInfo) GetDomain() string { return strings.Join(ci.c.Redirect_uris, " ") }
+
+func (ci clientInfo) IsPublic() bool { return false }
+
+func (ci clientInfo) GetUserID() string { return "" }
+
+func (ci clientInfo) VerifyPassword(secret string) bool {
+	h := hashSecret(secret)
+	return subtle.ConstantTimeCompare([]byte(h), []byte(ci.c.Secret_hash)) == 1
+}
+
+// ValidateRedirectURI is the manager's uri check: the redirect uri has to be
+// one of the client's registered uris exactly
+func ValidateRedirectURI(registered, redirect string) error {
+	for _, uri := range strings.Fields(registered) {
+		if uri == redirect {
+			return nil
+		}
+	}
+	return oauth2errors.ErrInvalidRedirectURI
+}
+
+// ClientAuthorized lets a client use only the grants it was registered with
+func ClientAuthorized(clientID string, grant oauth2.GrantType) (bool, error) {
+	c, ok := client(clientID)
+	if !ok {
+		return false, nil
+	}
+	for _, g := range c.Grants {
+		if g == grant.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// VerifyClient checks the credentials of an enabled client, for endpoints
+// that authenticate clients outside the token server
+func VerifyClient(id, secret string) bool {
+	c, ok := client(id)
+	return ok && clientInfo{c}.VerifyPassword(secret)
+}
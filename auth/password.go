@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode"
+
+	"jotacomputing/go-api/db"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwords are stored as bcrypt hashes. changing a password stamps
+// users.password_changed_at and every token issued before that is refused by
+// TokenRevoked, so a leaked token dies with the old password.
+
+const (
+	minUsernameLen = 3
+	maxUsernameLen = 32
+	minPasswordLen = 10
+	maxPasswordLen = 72 // bcrypt ignores anything past 72 bytes
+	bcryptCost     = 12
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// accounts created before passwords were checked have no hash and can't log
+	// in until an admin sets one
+	ErrNoPassword = errors.New("account has no password set")
+)
+
+// PolicyError is a username or password that doesn't meet the policy, the
+// message is meant for the client
+type PolicyError struct {
+	msg string
+}
+
+func (e *PolicyError) Error() string { return e.msg }
+
+func policyErrorf(format string, args ...interface{}) error {
+	return &PolicyError{msg: fmt.Sprintf(format, args...)}
+}
+
+// ValidateUsername checks the username policy: 3 to 32 letters, digits, '_',
+// '.' or '-'
+func ValidateUsername(username string) error {
+	if len(username) < minUsernameLen || len(username) > maxUsernameLen {
+		return policyErrorf("username must be %d to %d characters", minUsernameLen, maxUsernameLen)
+	}
+	for _, r := range username {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-') {
+			return policyErrorf("username may only contain letters, digits, '_', '.' and '-'")
+		}
+	}
+	return nil
+}
+
+// ValidatePassword checks the password policy: 10 to 72 bytes with at least
+// one letter and one digit, and not the username
+func ValidatePassword(username, password string) error {
+	if len(password) < minPasswordLen || len(password) > maxPasswordLen {
+		return policyErrorf("password must be %d to %d bytes", minPasswordLen, maxPasswordLen)
+	}
+	var letter, digit bool
+	for _, r := range password {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+	if !letter || !digit {
+		return policyErrorf("password must contain a letter and a digit")
+	}
+	if password == username {
+		return policyErrorf("password must not be the username")
+	}
+	return nil
+}
+
+func hash(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	return string(h), err
+}
+
+// compared against when the username doesn't exist so a login takes as long
+// either way
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcryptCost)
+
+// Register validates and creates an account, the error is safe to show the
+// client unless it is a db error
+func Register(username, password string) (*db.User, error) {
+	if err := ValidateUsername(username); err != nil {
+		return nil, err
+	}
+	if err := ValidatePassword(username, password); err != nil {
+		return nil, err
+	}
+	h, err := hash(password)
+	if err != nil {
+		return nil, err
+	}
+	return db.RegisterUser(username, h)
+}
+
+// Authenticate checks a username and password for the password grant and
+// returns the user id
+func Authenticate(username, password string) (uint64, error) {
+	id, h, err := db.UserCredentials(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return 0, ErrInvalidCredentials
+	}
+	if err != nil {
+		return 0, err
+	}
+	if h == "" {
+		return 0, ErrNoPassword
+	}
+	if bcrypt.CompareHashAndPassword([]byte(h), []byte(password)) != nil {
+		return 0, ErrInvalidCredentials
+	}
+	return id, nil
+}
+
+// ChangePassword replaces the password after checking the current one. every
+// token of the account, the caller's included, stops working.
+func ChangePassword(userID uint64, current, next string) error {
+	h, err := db.UserPasswordHash(userID)
+	if err != nil {
+		return err
+	}
+	if h == "" || bcrypt.CompareHashAndPassword([]byte(h), []byte(current)) != nil {
+		return ErrInvalidCredentials
+	}
+	return SetPassword(userID, next)
+}
+
+// SetPassword validates and sets a password without checking the old one,
+// which is how an admin gives a legacy account its first password. returns
+// sql.ErrNoRows if there is no such user.
+func SetPassword(userID uint64, password string) error {
+	user, err := db.FindUserByID(int64(userID))
+	if err != nil {
+		return err
+	}
+	if err := ValidatePassword(user.Username, password); err != nil {
+		return err
+	}
+	h, err := hash(password)
+	if err != nil {
+		return err
+	}
+	ts, found, err := db.SetPasswordHash(userID, h)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return sql.ErrNoRows
+	}
+	mu.Lock()
+	changedAt[userID] = ts
+	mu.Unlock()
+	return nil
+}
+
+var (
+	mu        sync.Mutex
+	changedAt = make(map[uint64]int64) // password_changed_at by user, filled on first use
+)
+
+// TokenRevoked reports whether a token issued at issued predates the user's
+// last password change
+func TokenRevoked(userID uint64, issued time.Time) (bool, error) {
+	mu.Lock()
+	ts, ok := changedAt[userID]
+	mu.Unlock()
+	if !ok {
+		var err error
+		if ts, err = db.PasswordChangedAt(userID); err != nil {
+			return false, err
+		}
+		mu.Lock()
+		if _, set := changedAt[userID]; !set {
+			changedAt[userID] = ts
+		} else {
+			ts = changedAt[userID]
+		}
+		mu.Unlock()
+	}
+	return issued.UnixNano() < ts, nil
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		ok       bool
+	}{
+		{"bob", true},
+		{"jane.doe-99_x", true},
+		{strings.Repeat("a", maxUsernameLen), true},
+		{"ab", false},
+		{strings.Repeat("a", maxUsernameLen+1), false},
+		{"jane doe", false},
+		{"jane@doe", false},
+		{"jöhn", false}, // letters are ascii only
+		{"", false},
+	}
+	for _, tt := range tests {
+		err := ValidateUsername(tt.username)
+		if (err == nil) != tt.ok {
+			t.Errorf("ValidateUsername(%q) = %v, want ok %v", tt.username, err, tt.ok)
+		}
+		var pe *PolicyError
+		if err != nil && !errors.As(err, &pe) {
+			t.Errorf("ValidateUsername(%q) = %T, want a *PolicyError", tt.username, err)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		ok       bool
+	}{
+		{"letters and digits", "bob", "correct4horse", true},
+		{"shortest", "bob", "abcdefghi1", true},
+		{"longest", "bob", strings.Repeat("a", maxPasswordLen-1) + "1", true},
+		{"too short", "bob", "abcdefgh1", false},
+		{"too long", "bob", strings.Repeat("a", maxPasswordLen) + "1", false},
+		{"length in bytes", "bob", strings.Repeat("é", 36) + "1", false},
+		{"no digit", "bob", "correcthorse", false},
+		{"no letter", "bob", "1234567890", false},
+		{"the username", "bob.smith99", "bob.smith99", false},
+	}
+	for _, tt := range tests {
+		err := ValidatePassword(tt.username, tt.password)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: ValidatePassword = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		var pe *PolicyError
+		if err != nil && !errors.As(err, &pe) {
+			t.Errorf("%s: ValidatePassword = %T, want a *PolicyError", tt.name, err)
+		}
+	}
+}
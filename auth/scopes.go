@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"strconv"
+	"strings"
+
+	"jotacomputing/go-api/db"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// scopes limit what a token can do under /api. a token gets the scopes it
+// asked for, or every scope its client is registered with when it asked for
+// none, as long as the user's role allows them: admin is for admins only.
+const (
+	ScopeOrdersWrite = "orders:write"
+	ScopeOrdersRead  = "orders:read"
+	ScopeAccountRead = "account:read"
+	ScopeAdmin       = "admin"
+)
+
+var knownScopes = []string{ScopeOrdersWrite, ScopeOrdersRead, ScopeAccountRead, ScopeAdmin}
+
+func knownScope(s string) bool {
+	for _, k := range knownScopes {
+		if k == s {
+			return true
+		}
+	}
+	return false
+}
+
+// roleAllows reports whether a user of role may hold scope
+func roleAllows(role, scope string) bool {
+	return scope != ScopeAdmin || role == db.RoleAdmin
+}
+
+// HasScope reports whether a token's space separated scope list includes scope
+func HasScope(granted, scope string) bool {
+	for _, s := range strings.Fields(granted) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientScope is the token server's scope check. it refuses scopes the client
+// isn't registered with or the user's role doesn't allow and sets the scope
+// the token is issued with.
+func ClientScope(tgr *oauth2.TokenGenerateRequest) (bool, error) {
+	c, ok := client(tgr.ClientID)
+	if !ok {
+		return false, nil
+	}
+	role := ""
+	if tgr.UserID != "" {
+		userID, err := strconv.ParseInt(tgr.UserID, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		user, err := db.FindUserByID(userID)
+		if err != nil {
+			return false, err
+		}
+		role = user.Role
+	}
+
+	requested := strings.Fields(tgr.Scope)
+	var granted []string
+	if len(requested) == 0 {
+		for _, s := range c.Scopes {
+			if roleAllows(role, s) {
+				granted = append(granted, s)
+			}
+		}
+	}
+	for _, s := range requested {
+		allowed := roleAllows(role, s)
+		registered := false
+		for _, r := range c.Scopes {
+			registered = registered || r == s
+		}
+		if !allowed || !registered {
+			return false, nil
+		}
+		if !HasScope(strings.Join(granted, " "), s) {
+			granted = append(granted, s)
+		}
+	}
+	tgr.Scope = strings.Join(granted, " ")
+	return true, nil
+}
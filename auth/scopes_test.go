@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"testing"
+
+	"jotacomputing/go-api/db"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+func TestClientScope(t *testing.T) {
+	clientsMu.Lock()
+	clients = map[string]db.OAuthClient{
+		"app":   {ID: "app", Scopes: []string{ScopeOrdersRead, ScopeOrdersWrite, ScopeAdmin}},
+		"off":   {ID: "off", Scopes: knownScopes, Disabled: true},
+		"empty": {ID: "empty"},
+	}
+	clientsMu.Unlock()
+
+	// no user id, so the role is not admin
+	tests := []struct {
+		name   string
+		client string
+		scope  string
+		ok     bool
+		want   string // scope the token is issued with
+	}{
+		{"none asked, everything registered but admin", "app", "", true, "orders:read orders:write"},
+		{"one asked", "app", "orders:read", true, "orders:read"},
+		{"duplicates dropped", "app", "orders:read  orders:read", true, "orders:read"},
+		{"not registered with the client", "app", "account:read", false, ""},
+		{"unknown scope", "app", "orders:delete", false, ""},
+		{"admin needs the role", "app", "orders:read admin", false, ""},
+		{"client without scopes", "empty", "", true, ""},
+		{"disabled client", "off", "orders:read", false, ""},
+		{"unknown client", "nope", "", false, ""},
+	}
+	for _, tt := range tests {
+		tgr := &oauth2.TokenGenerateRequest{ClientID: tt.client, Scope: tt.scope}
+		ok, err := ClientScope(tgr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if ok != tt.ok {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && tgr.Scope != tt.want {
+			t.Errorf("%s: scope = %q, want %q", tt.name, tgr.Scope, tt.want)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		granted string
+		scope   string
+		want    bool
+	}{
+		{"orders:read orders:write", "orders:write", true},
+		{" orders:read ", "orders:read", true},
+		{"orders:read", "orders", false},
+		{"", "orders:read", false},
+	}
+	for _, tt := range tests {
+		if got := HasScope(tt.granted, tt.scope); got != tt.want {
+			t.Errorf("HasScope(%q, %q) = %v, want %v", tt.granted, tt.scope, got, tt.want)
+		}
+	}
+	if roleAllows(db.RoleUser, ScopeAdmin) || !roleAllows(db.RoleAdmin, ScopeAdmin) || !roleAllows(db.RoleUser, ScopeOrdersWrite) {
+		t.Errorf("roleAllows: admin scope must be for admins only")
+	}
+}
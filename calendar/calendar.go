@@ -0,0 +1,192 @@
+package calendar
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	_ "time/tzdata" // calendars name their timezone, don't depend on the host zoneinfo
+
+	"jotacomputing/go-api/db"
+)
+
+// Session is the trading state a symbol group is in
+type Session string
+
+const (
+	PreOpen        Session = "pre_open"
+	Open           Session = "open"
+	ClosingAuction Session = "closing_auction"
+	Closed         Session = "closed"
+)
+
+type window struct {
+	session    Session
+	start, end int // minutes after midnight
+}
+
+type group struct {
+	name     string
+	loc      *time.Location
+	weekdays int // bit 0 = Sunday
+	windows  []window
+	holidays map[string]string
+}
+
+var (
+	mu     sync.RWMutex
+	groups = make(map[string]*group)
+)
+
+// Load reads calendar groups, sessions and holidays from the database
+func Load() error {
+	rowsG, rowsS, rowsH, err := db.LoadCalendar()
+	if err != nil {
+		return err
+	}
+
+	next := make(map[string]*group)
+	for _, g := range rowsG {
+		loc, err := time.LoadLocation(g.Timezone)
+		if err != nil {
+			return fmt.Errorf("calendar %s: %w", g.Group, err)
+		}
+		next[g.Group] = &group{name: g.Group, loc: loc, weekdays: g.Weekdays, holidays: make(map[string]string)}
+	}
+	for _, s := range rowsS {
+		g, ok := next[s.Group]
+		if !ok {
+			return fmt.Errorf("calendar: session %s for unknown group %s", s.Session, s.Group)
+		}
+		switch Session(s.Session) {
+		case PreOpen, Open, ClosingAuction:
+		default:
+			return fmt.Errorf("calendar %s: unknown session %q", s.Group, s.Session)
+		}
+		g.windows = append(g.windows, window{Session(s.Session), s.Start_minute, s.End_minute})
+	}
+	for _, h := range rowsH {
+		if g, ok := next[h.Group]; ok {
+			g.holidays[h.Day] = h.Name
+		}
+	}
+	for _, g := range next {
+		sort.Slice(g.windows, func(i, j int) bool { return g.windows[i].start < g.windows[j].start })
+	}
+
+	mu.Lock()
+	groups = next
+	mu.Unlock()
+	return nil
+}
+
+// Status describes where a group is in its trading day
+type Status struct {
+	Group        string    `json:"group"`
+	Session      Session   `json:"session"`
+	Holiday      string    `json:"holiday,omitempty"`
+	Next_session Session   `json:"next_session"`
+	Next_change  time.Time `json:"next_change"`
+}
+
+// sessionAt returns the session a group is in at t, g must not be nil
+func (g *group) sessionAt(t time.Time) (Session, string) {
+	t = t.In(g.loc)
+	if g.weekdays&(1<<uint(t.Weekday())) == 0 {
+		return Closed, ""
+	}
+	if name, ok := g.holidays[t.Format("2006-01-02")]; ok {
+		return Closed, name
+	}
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range g.windows {
+		if minute >= w.start && minute < w.end {
+			return w.session, ""
+		}
+	}
+	return Closed, ""
+}
+
+// nextChange finds the next minute boundary where the session changes, looking
+// at most two weeks ahead (long enough to get past holiday runs)
+func (g *group) nextChange(now time.Time, current Session) (Session, time.Time) {
+	t := now.Truncate(time.Minute).Add(time.Minute)
+	for limit := now.Add(14 * 24 * time.Hour); t.Before(limit); t = t.Add(time.Minute) {
+		if s, _ := g.sessionAt(t); s != current {
+			return s, t
+		}
+	}
+	return current, time.Time{}
+}
+
+// DayEnd is when a DAY order placed at now lapses: the close of the group's
+// current trading day, or of the next one while the group is closed. zero for
+// unknown groups and calendars with no trading in the next two weeks.
+func DayEnd(name string, now time.Time) time.Time {
+	mu.RLock()
+	g := groups[name]
+	mu.RUnlock()
+	if g == nil {
+		return time.Time{}
+	}
+	session, _ := g.sessionAt(now)
+	t := now
+	// a day is at most pre-open, open and the closing auction with gaps
+	for i := 0; i < 8; i++ {
+		next, at := g.nextChange(t, session)
+		if at.IsZero() || (next == Closed && session != Closed) {
+			return at
+		}
+		session, t = next, at
+	}
+	return time.Time{}
+}
+
+// StatusOf returns the status of a group. unknown groups are always closed.
+func StatusOf(name string, now time.Time) Status {
+	mu.RLock()
+	g := groups[name]
+	mu.RUnlock()
+
+	if g == nil {
+		return Status{Group: name, Session: Closed, Next_session: Closed}
+	}
+	session, holiday := g.sessionAt(now)
+	next, at := g.nextChange(now, session)
+	return Status{Group: name, Session: session, Holiday: holiday, Next_session: next, Next_change: at}
+}
+
+// Groups returns the names of every configured group
+func Groups() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SessionOf returns the current session of a group
+func SessionOf(name string, now time.Time) Session {
+	mu.RLock()
+	g := groups[name]
+	mu.RUnlock()
+	if g == nil {
+		return Closed
+	}
+	s, _ := g.sessionAt(now)
+	return s
+}
+
+// Location returns the timezone a group trades in, UTC for unknown groups
+func Location(name string) *time.Location {
+	mu.RLock()
+	g := groups[name]
+	mu.RUnlock()
+	if g == nil {
+		return time.UTC
+	}
+	return g.loc
+}
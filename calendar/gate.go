@@ -0,0 +1,55 @@
+package calendar
+
+import (
+	"time"
+
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+)
+
+// which orders each session takes. pre-open and the closing auction build an
+// auction book, so nothing that has to execute immediately (market orders,
+// IOC/FOK) is allowed there.
+var allowedTypes = map[Session][]uint8{
+	PreOpen:        {structs.OrderTypeLimit},
+	Open:           {structs.OrderTypeMarket, structs.OrderTypeLimit},
+	ClosingAuction: {structs.OrderTypeLimit},
+	Closed:         nil,
+}
+
+// GateName is the name of the session check in the pre-trade chain
+const GateName = "session"
+
+// AllowedOrderTypes lists the engine order types accepted in a session
+func AllowedOrderTypes(s Session) []uint8 {
+	return allowedTypes[s]
+}
+
+// Gate plugs the session check into the pre-trade chain, see risk.Prepend
+type Gate struct{}
+
+func (Gate) Name() string { return GateName }
+
+func (Gate) Check(order structs.Order, _ risk.Limits) *risk.Rejection {
+	group := symbols.Lookup(order.Symbol).Group
+	session := SessionOf(group, time.Now())
+
+	if session == Closed {
+		return &risk.Rejection{Code: risk.ReasonMarketClosed, Message: "market is closed for symbol group " + group}
+	}
+
+	allowed := false
+	for _, t := range allowedTypes[session] {
+		if t == order.Order_type {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return &risk.Rejection{Code: risk.ReasonSessionOrderType, Message: "order type not accepted during " + string(session)}
+	}
+	if session != Open && (order.Time_in_force == structs.TifIOC || order.Time_in_force == structs.TifFOK) {
+		return &risk.Rejection{Code: risk.ReasonSessionOrderType, Message: "IOC/FOK orders are only accepted while the market is open"}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package calendar
+
+import (
+	"log"
+	"time"
+
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+)
+
+// StartWatcher checks every group once a minute and runs the end of day
+// expiry of DAY orders when a group goes from trading to closed
+func StartWatcher() {
+	go func() {
+		last := make(map[string]Session)
+		for _, name := range Groups() {
+			last[name] = SessionOf(name, time.Now())
+		}
+
+		for {
+			now := time.Now()
+			time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+			for _, name := range Groups() {
+				session := SessionOf(name, time.Now())
+				prev, seen := last[name]
+				last[name] = session
+				if !seen || prev == session {
+					continue
+				}
+				log.Printf("calendar: %s %s -> %s", name, prev, session)
+				if session == Closed {
+					n := orders.ExpireDayOrders(inGroup(name))
+					log.Printf("calendar: %s closed, expired %d DAY orders", name, n)
+				}
+			}
+		}
+	}()
+}
+
+func inGroup(name string) func(structs.Order) bool {
+	return func(order structs.Order) bool {
+		return symbols.Lookup(order.Symbol).Group == name
+	}
+}
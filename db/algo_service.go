@@ -0,0 +1,266 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// parent orders worked by the gateway's execution algos. weights holds the
+// share of each slice (all equal for TWAP, the volume profile for VWAP).
+const algoOrdersSchema = `
+    CREATE TABLE IF NOT EXISTS algo_orders (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        strategy TEXT NOT NULL,
+        symbol INTEGER NOT NULL,
+        side INTEGER NOT NULL,
+        order_type INTEGER NOT NULL,
+        price INTEGER NOT NULL DEFAULT 0,
+        total_qty INTEGER NOT NULL,
+        weights TEXT NOT NULL,
+        interval_ns INTEGER NOT NULL,
+        next_slice INTEGER NOT NULL DEFAULT 0,
+        next_at INTEGER NOT NULL,
+        sent_qty INTEGER NOT NULL DEFAULT 0,
+        filled_qty INTEGER NOT NULL DEFAULT 0,
+        status TEXT NOT NULL,
+        last_error TEXT NOT NULL DEFAULT '',
+        created_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS algo_orders_user ON algo_orders (user_id, id);
+`
+
+const algoChildrenSchema = `
+    CREATE TABLE IF NOT EXISTS algo_children (
+        algo_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        order_id INTEGER NOT NULL,
+        slice INTEGER NOT NULL,
+        qty INTEGER NOT NULL,
+        filled_qty INTEGER NOT NULL DEFAULT 0,
+        status INTEGER NOT NULL DEFAULT 0,
+        created_at INTEGER NOT NULL,
+        PRIMARY KEY (user_id, order_id)
+    );
+    CREATE INDEX IF NOT EXISTS algo_children_algo ON algo_children (algo_id);
+`
+
+// algo_orders.strategy
+const (
+	AlgoTWAP = "twap"
+	AlgoVWAP = "vwap"
+)
+
+// algo_orders.status
+const (
+	AlgoRunning   = "running"
+	AlgoPaused    = "paused"
+	AlgoCancelled = "cancelled"
+	AlgoCompleted = "completed"
+)
+
+type AlgoOrder struct {
+	ID          uint64
+	User_id     uint64
+	Strategy    string
+	Symbol      uint32
+	Side        uint8
+	Order_type  uint8
+	Price       uint64
+	Total_qty   uint64
+	Weights     []uint64
+	Interval_ns int64
+	Next_slice  int
+	Next_at     int64 // unix nanos
+	Sent_qty    uint64
+	Filled_qty  uint64
+	Status      string
+	Last_error  string
+	Created_at  int64
+	Updated_at  int64
+}
+
+// AlgoChild is one slice sent to the engine. Status is the last engine status.
+type AlgoChild struct {
+	Algo_id    uint64
+	User_id    uint64
+	Order_id   uint64
+	Slice      int
+	Qty        uint32
+	Filled_qty uint32
+	Status     uint8
+	Created_at int64
+}
+
+func joinWeights(weights []uint64) string {
+	parts := make([]string, len(weights))
+	for i, w := range weights {
+		parts[i] = strconv.FormatUint(w, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitWeights(s string) ([]uint64, error) {
+	parts := strings.Split(s, ",")
+	weights := make([]uint64, len(parts))
+	for i, p := range parts {
+		w, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		weights[i] = w
+	}
+	return weights, nil
+}
+
+// CreateAlgoOrder stores a new parent order and sets a.ID
+func CreateAlgoOrder(a *AlgoOrder) error {
+	ts := now()
+	result, err := db.Exec(`INSERT INTO algo_orders (user_id, strategy, symbol, side, order_type, price, total_qty,
+            weights, interval_ns, next_slice, next_at, status, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.User_id, a.Strategy, a.Symbol, a.Side, a.Order_type, a.Price, a.Total_qty,
+		joinWeights(a.Weights), a.Interval_ns, a.Next_slice, a.Next_at, a.Status, ts, ts,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	a.ID = uint64(id)
+	a.Created_at, a.Updated_at = ts, ts
+	return nil
+}
+
+// UpdateAlgoOrder stores the progress and status of a parent order
+func UpdateAlgoOrder(a *AlgoOrder) error {
+	a.Updated_at = now()
+	_, err := db.Exec(`UPDATE algo_orders SET next_slice = ?, next_at = ?, sent_qty = ?, filled_qty = ?,
+            status = ?, last_error = ?, updated_at = ? WHERE id = ?`,
+		a.Next_slice, a.Next_at, a.Sent_qty, a.Filled_qty, a.Status, a.Last_error, a.Updated_at, a.ID,
+	)
+	return err
+}
+
+const algoOrderColumns = `id, user_id, strategy, symbol, side, order_type, price, total_qty, weights,
+    interval_ns, next_slice, next_at, sent_qty, filled_qty, status, last_error, created_at, updated_at`
+
+func scanAlgoOrder(row interface{ Scan(...interface{}) error }) (*AlgoOrder, error) {
+	a := &AlgoOrder{}
+	var weights string
+	err := row.Scan(&a.ID, &a.User_id, &a.Strategy, &a.Symbol, &a.Side, &a.Order_type, &a.Price, &a.Total_qty,
+		&weights, &a.Interval_ns, &a.Next_slice, &a.Next_at, &a.Sent_qty, &a.Filled_qty, &a.Status,
+		&a.Last_error, &a.Created_at, &a.Updated_at)
+	if err != nil {
+		return nil, err
+	}
+	if a.Weights, err = splitWeights(weights); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func queryAlgoOrders(query string, args ...interface{}) ([]*AlgoOrder, error) {
+	rows, err := db.Query("SELECT "+algoOrderColumns+" FROM algo_orders WHERE "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var algos []*AlgoOrder
+	for rows.Next() {
+		a, err := scanAlgoOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		algos = append(algos, a)
+	}
+	return algos, rows.Err()
+}
+
+// GetAlgoOrder returns one of the user's parent orders, sql.ErrNoRows if
+// there is no such algo
+func GetAlgoOrder(userID, algoID uint64) (*AlgoOrder, error) {
+	return scanAlgoOrder(db.QueryRow(
+		"SELECT "+algoOrderColumns+" FROM algo_orders WHERE user_id = ? AND id = ?", userID, algoID,
+	))
+}
+
+// ListAlgoOrders returns the user's parent orders, newest first
+func ListAlgoOrders(userID uint64) ([]*AlgoOrder, error) {
+	return queryAlgoOrders("user_id = ? ORDER BY id DESC", userID)
+}
+
+// LoadLiveAlgoOrders returns every running or paused algo, used on startup
+func LoadLiveAlgoOrders() ([]*AlgoOrder, error) {
+	return queryAlgoOrders("status IN (?, ?)", AlgoRunning, AlgoPaused)
+}
+
+// InsertAlgoChild records a slice before it is sent
+func InsertAlgoChild(c *AlgoChild) error {
+	c.Created_at = now()
+	_, err := db.Exec(`INSERT INTO algo_children (algo_id, user_id, order_id, slice, qty, filled_qty, status, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.Algo_id, c.User_id, c.Order_id, c.Slice, c.Qty, c.Filled_qty, c.Status, c.Created_at,
+	)
+	return err
+}
+
+// UpdateAlgoChild stores a slice's fills and engine status
+func UpdateAlgoChild(c *AlgoChild) error {
+	_, err := db.Exec("UPDATE algo_children SET filled_qty = ?, status = ? WHERE user_id = ? AND order_id = ?",
+		c.Filled_qty, c.Status, c.User_id, c.Order_id)
+	return err
+}
+
+// ListAlgoChildren returns the slices of an algo in the order they were sent
+func ListAlgoChildren(algoID uint64) ([]AlgoChild, error) {
+	rows, err := db.Query(`SELECT algo_id, user_id, order_id, slice, qty, filled_qty, status, created_at
+        FROM algo_children WHERE algo_id = ? ORDER BY slice`, algoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var children []AlgoChild
+	for rows.Next() {
+		var c AlgoChild
+		err := rows.Scan(&c.Algo_id, &c.User_id, &c.Order_id, &c.Slice, &c.Qty, &c.Filled_qty, &c.Status, &c.Created_at)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, c)
+	}
+	return children, rows.Err()
+}
+
+// RecordAlgoFill adds an execution to a child and its parent
+func RecordAlgoFill(userID, orderID uint64, qty uint32) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var algoID uint64
+	err = tx.QueryRow("SELECT algo_id FROM algo_children WHERE user_id = ? AND order_id = ?", userID, orderID).Scan(&algoID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE algo_children SET filled_qty = filled_qty + ? WHERE user_id = ? AND order_id = ?",
+		qty, userID, orderID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE algo_orders SET filled_qty = filled_qty + ?, updated_at = ? WHERE id = ?",
+		qty, now(), algoID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,116 @@
+package db
+
+// trading calendar per symbol group. session times are minutes after midnight
+// in the group's timezone, weekdays is a bitmask with bit 0 = Sunday.
+const calendarGroupsSchema = `
+    CREATE TABLE IF NOT EXISTS calendar_groups (
+        grp TEXT PRIMARY KEY,
+        timezone TEXT NOT NULL,
+        weekdays INTEGER NOT NULL
+    )
+`
+
+const tradingSessionsSchema = `
+    CREATE TABLE IF NOT EXISTS trading_sessions (
+        grp TEXT NOT NULL,
+        session TEXT NOT NULL,
+        start_minute INTEGER NOT NULL,
+        end_minute INTEGER NOT NULL,
+        PRIMARY KEY (grp, session)
+    )
+`
+
+const holidaysSchema = `
+    CREATE TABLE IF NOT EXISTS holidays (
+        grp TEXT NOT NULL,
+        day TEXT NOT NULL,
+        name TEXT NOT NULL DEFAULT '',
+        PRIMARY KEY (grp, day)
+    )
+`
+
+// monday to friday (0b0111110), pre-open 08:00, open 09:30, closing auction 15:50-16:00
+const calendarSeed = `
+    INSERT OR IGNORE INTO calendar_groups VALUES ('default', 'America/New_York', 62);
+    INSERT OR IGNORE INTO trading_sessions VALUES
+        ('default', 'pre_open',         480,  570),
+        ('default', 'open',             570,  950),
+        ('default', 'closing_auction',  950,  960);
+`
+
+type CalendarGroup struct {
+	Group    string
+	Timezone string
+	Weekdays int
+}
+
+type TradingSession struct {
+	Group        string
+	Session      string
+	Start_minute int
+	End_minute   int
+}
+
+type Holiday struct {
+	Group string `json:"group"`
+	Day   string `json:"day"` // YYYY-MM-DD in the group's timezone
+	Name  string `json:"name"`
+}
+
+// LoadCalendar returns every group with its sessions and holidays
+func LoadCalendar() ([]CalendarGroup, []TradingSession, []Holiday, error) {
+	var groups []CalendarGroup
+	var sessions []TradingSession
+	var holidays []Holiday
+
+	rows, err := db.Query("SELECT grp, timezone, weekdays FROM calendar_groups")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for rows.Next() {
+		var g CalendarGroup
+		if err := rows.Scan(&g.Group, &g.Timezone, &g.Weekdays); err != nil {
+			rows.Close()
+			return nil, nil, nil, err
+		}
+		groups = append(groups, g)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT grp, session, start_minute, end_minute FROM trading_sessions ORDER BY grp, start_minute")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for rows.Next() {
+		var s TradingSession
+		if err := rows.Scan(&s.Group, &s.Session, &s.Start_minute, &s.End_minute); err != nil {
+			rows.Close()
+			return nil, nil, nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT grp, day, name FROM holidays")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h Holiday
+		if err := rows.Scan(&h.Group, &h.Day, &h.Name); err != nil {
+			return nil, nil, nil, err
+		}
+		holidays = append(holidays, h)
+	}
+	return groups, sessions, holidays, rows.Err()
+}
+
+// AddHoliday closes a group for a whole day
+func AddHoliday(h Holiday) error {
+	_, err := db.Exec(
+		"INSERT INTO holidays (grp, day, name) VALUES (?, ?, ?) ON CONFLICT(grp, day) DO UPDATE SET name = excluded.name",
+		h.Group, h.Day, h.Name,
+	)
+	return err
+}
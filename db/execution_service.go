@@ -0,0 +1,199 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/structs"
+)
+
+// one row per side of a fill, so each user sees their own executions.
+// (trade_id, side) is unique which makes replays of the fills ring harmless.
+const executionsSchema = `
+    CREATE TABLE IF NOT EXISTS executions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        trade_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        order_id INTEGER NOT NULL,
+        symbol INTEGER NOT NULL,
+        side INTEGER NOT NULL,
+        price INTEGER NOT NULL,
+        shares_qty INTEGER NOT NULL,
+        maker INTEGER NOT NULL,
+        timestamp INTEGER NOT NULL,
+        created_at INTEGER NOT NULL,
+        UNIQUE (trade_id, side)
+    );
+    CREATE INDEX IF NOT EXISTS executions_order ON executions (user_id, order_id);
+`
+
+type Execution struct {
+	ID         uint64
+	Trade_id   uint64
+	User_id    uint64
+	Order_id   uint64
+	Symbol     uint32
+	Side       uint8
+	Price      uint64
+	Shares_qty uint32
+	Maker      bool
+	Fee        decimal.Amount
+	Timestamp  uint64
+	Created_at int64
+}
+
+// InsertFill stores both sides of a fill and charges their fees in the same
+// transaction, false if it was already stored. fee may be nil.
+func InsertFill(fill structs.Fill, fee FeeFunc) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	ts := now()
+	inserted := false
+	for _, leg := range []struct {
+		user, order uint64
+		side        uint8
+		maker       bool
+	}{
+		{fill.Buy_user_id, fill.Buy_order_id, 0, fill.Aggressor_side != 0},
+		{fill.Sell_user_id, fill.Sell_order_id, 1, fill.Aggressor_side != 1},
+	} {
+		result, err := tx.Exec(`INSERT OR IGNORE INTO executions
+            (trade_id, user_id, order_id, symbol, side, price, shares_qty, maker, timestamp, created_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			fill.Trade_id, leg.user, leg.order, fill.Symbol, leg.side, fill.Price, fill.Shares_qty, leg.maker, fill.Timestamp, ts,
+		)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			inserted = true
+			// orders.ApplyFill does the same to the tracker
+			_, err = tx.Exec("UPDATE orders SET leaves_qty = MAX(leaves_qty - ?, 0) WHERE user_id = ? AND order_id = ?",
+				fill.Shares_qty, leg.user, leg.order)
+			if err != nil {
+				return false, err
+			}
+			if err := chargeFill(tx, fill, leg.user, leg.order, leg.side, leg.maker, fee); err != nil {
+				return false, err
+			}
+		}
+	}
+	return inserted, tx.Commit()
+}
+
+// chargeFill charges the fee of one side of a fill that was just stored
+func chargeFill(tx *sql.Tx, fill structs.Fill, userID, orderID uint64, side uint8, maker bool, fee FeeFunc) error {
+	if fee == nil {
+		return nil
+	}
+	var charged decimal.Amount
+	err := tx.QueryRow(
+		"SELECT COALESCE(SUM(fee_minor), 0) FROM executions WHERE user_id = ? AND order_id = ?", userID, orderID,
+	).Scan(&charged)
+	if err != nil {
+		return err
+	}
+	amount, err := fee(fill, userID, maker, charged)
+	if err != nil || amount == 0 {
+		return err
+	}
+	return chargeFee(tx, userID, orderID, fill.Trade_id, side, amount)
+}
+
+// ExecutionQuery selects a page of one user's executions, newest first.
+// After_id is the cursor: the id of the last row of the previous page.
+type ExecutionQuery struct {
+	User_id  uint64
+	Order_id uint64
+	Symbol   uint32
+	From, To int64 // created_at range, unix nanos, To exclusive
+	After_id uint64
+	Limit    int
+}
+
+// ListExecutions returns one page of executions matching q, Limit 0 returns all
+func ListExecutions(q ExecutionQuery) ([]Execution, error) {
+	where := []string{"user_id = ?"}
+	args := []interface{}{q.User_id}
+
+	if q.Order_id != 0 {
+		where = append(where, "order_id = ?")
+		args = append(args, q.Order_id)
+	}
+	if q.Symbol != 0 {
+		where = append(where, "symbol = ?")
+		args = append(args, q.Symbol)
+	}
+	if q.From != 0 {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.From)
+	}
+	if q.To != 0 {
+		where = append(where, "created_at < ?")
+		args = append(args, q.To)
+	}
+	if q.After_id != 0 {
+		where = append(where, "id < ?")
+		args = append(args, q.After_id)
+	}
+	query := `SELECT id, trade_id, user_id, order_id, symbol, side, price, shares_qty, maker, fee_minor, timestamp, created_at
+        FROM executions WHERE ` + strings.Join(where, " AND ") + " ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var execs []Execution
+	for rows.Next() {
+		var e Execution
+		err := rows.Scan(&e.ID, &e.Trade_id, &e.User_id, &e.Order_id, &e.Symbol, &e.Side,
+			&e.Price, &e.Shares_qty, &e.Maker, &e.Fee, &e.Timestamp, &e.Created_at)
+		if err != nil {
+			return nil, err
+		}
+		execs = append(execs, e)
+	}
+	return execs, rows.Err()
+}
+
+// ListAllExecutions returns every execution of every user, oldest first
+func ListAllExecutions() ([]Execution, error) {
+	rows, err := db.Query(`SELECT id, trade_id, user_id, order_id, symbol, side, price, shares_qty, maker, fee_minor, timestamp, created_at
+        FROM executions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var execs []Execution
+	for rows.Next() {
+		var e Execution
+		err := rows.Scan(&e.ID, &e.Trade_id, &e.User_id, &e.Order_id, &e.Symbol, &e.Side,
+			&e.Price, &e.Shares_qty, &e.Maker, &e.Fee, &e.Timestamp, &e.Created_at)
+		if err != nil {
+			return nil, err
+		}
+		execs = append(execs, e)
+	}
+	return execs, rows.Err()
+}
+
+// ExecutedQty sums what has executed on an order so far
+func ExecutedQty(userID, orderID uint64) (uint64, error) {
+	var qty sql.NullInt64
+	err := db.QueryRow(
+		"SELECT SUM(shares_qty) FROM executions WHERE user_id = ? AND order_id = ?", userID, orderID,
+	).Scan(&qty)
+	return uint64(qty.Int64), err
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/structs"
+)
+
+func TestInsertFillCharges(t *testing.T) {
+	t.Chdir(t.TempDir())
+	InitDB()
+	buyer, err := CreateUser("buyer", 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seller, err := CreateUser("seller", 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 100 minimum per order, 10 a fill after that, makers pay nothing
+	fee := func(fill structs.Fill, userID uint64, maker bool, charged decimal.Amount) (decimal.Amount, error) {
+		if maker {
+			return 0, nil
+		}
+		if charged < 100 {
+			return 100 - charged, nil
+		}
+		return 10, nil
+	}
+	fill := func(trade uint64, fee FeeFunc) (bool, error) {
+		return InsertFill(structs.Fill{Trade_id: trade, Buy_user_id: buyer.ID, Buy_order_id: 1, Sell_user_id: seller.ID, Sell_order_id: 2,
+			Symbol: 1, Price: 100, Shares_qty: 1, Timestamp: trade, Aggressor_side: 0}, fee)
+	}
+	balance := func(step string, userID uint64, want decimal.Amount) {
+		t.Helper()
+		got, _, err := AvailableBalance(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: balance of user %d = %s, want %s", step, userID, got, want)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		trade    uint64
+		inserted bool
+		buyer    decimal.Amount // balance after the fill
+	}{
+		{"first fill pays the minimum", 1, true, 99900},
+		{"replay is not charged again", 1, false, 99900},
+		{"later fills pay the rate", 2, true, 99890},
+	}
+	for _, tt := range tests {
+		inserted, err := fill(tt.trade, fee)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if inserted != tt.inserted {
+			t.Errorf("%s: inserted = %v, want %v", tt.name, inserted, tt.inserted)
+		}
+		balance(tt.name, buyer.ID, tt.buyer)
+		balance(tt.name, seller.ID, 100000)
+	}
+
+	// a fee that can't be priced leaves the fill unstored, so a replay books both
+	failing := func(structs.Fill, uint64, bool, decimal.Amount) (decimal.Amount, error) {
+		return 0, errors.New("no schedule")
+	}
+	if _, err := fill(3, failing); err == nil {
+		t.Fatal("failing fee: want an error")
+	}
+	if inserted, err := fill(3, fee); err != nil || !inserted {
+		t.Errorf("fill after a failed fee: inserted = %v, %v", inserted, err)
+	}
+	balance("after the failed fee", buyer.ID, 99880)
+}
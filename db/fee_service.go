@@ -0,0 +1,83 @@
+package db
+
+import (
+	"database/sql"
+
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/structs"
+)
+
+// commission per account tier, users.tier points at fee_schedules.tier like it
+// does at rate_tiers. rates are basis points of the fill notional, min_fee is
+// the least an order pays over all of its fills.
+const feeSchedulesSchema = `
+    CREATE TABLE IF NOT EXISTS fee_schedules (
+        tier TEXT PRIMARY KEY,
+        maker_bps INTEGER NOT NULL,
+        taker_bps INTEGER NOT NULL,
+        min_fee_minor INTEGER NOT NULL,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    )
+`
+
+// seeded once, edit the rows or use the admin endpoint to retune a tier
+const feeSchedulesSeed = `
+    INSERT OR IGNORE INTO fee_schedules (tier, maker_bps, taker_bps, min_fee_minor) VALUES
+        ('standard',     10, 20, 100),
+        ('pro',           5, 15,  50),
+        ('market_maker',  0,  5,   0)
+`
+
+type FeeSchedule struct {
+	Tier      string         `json:"tier"`
+	Maker_bps uint64         `json:"maker_bps"`
+	Taker_bps uint64         `json:"taker_bps"`
+	Min_fee   decimal.Amount `json:"min_fee"`
+}
+
+// ListFeeSchedules returns every configured tier
+func ListFeeSchedules() ([]FeeSchedule, error) {
+	rows, err := db.Query("SELECT tier, maker_bps, taker_bps, min_fee_minor FROM fee_schedules")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []FeeSchedule
+	for rows.Next() {
+		var f FeeSchedule
+		if err := rows.Scan(&f.Tier, &f.Maker_bps, &f.Taker_bps, &f.Min_fee); err != nil {
+			return nil, err
+		}
+		list = append(list, f)
+	}
+	return list, rows.Err()
+}
+
+// SetFeeSchedule creates or updates the schedule of a tier
+func SetFeeSchedule(f FeeSchedule) error {
+	_, err := db.Exec(`
+        INSERT INTO fee_schedules (tier, maker_bps, taker_bps, min_fee_minor) VALUES (?, ?, ?, ?)
+        ON CONFLICT(tier) DO UPDATE SET maker_bps = excluded.maker_bps, taker_bps = excluded.taker_bps,
+            min_fee_minor = excluded.min_fee_minor, updated_at = CURRENT_TIMESTAMP`,
+		f.Tier, f.Maker_bps, f.Taker_bps, f.Min_fee,
+	)
+	return err
+}
+
+// FeeFunc prices one side of a fill for InsertFill. charged is what the
+// order paid on its earlier fills.
+type FeeFunc func(fill structs.Fill, userID uint64, maker bool, charged decimal.Amount) (decimal.Amount, error)
+
+// chargeFee debits the fee of one side of tradeID from the balance and
+// records it on the execution
+func chargeFee(tx *sql.Tx, userID, orderID, tradeID uint64, side uint8, fee decimal.Amount) error {
+	_, err := tx.Exec("UPDATE executions SET fee_minor = ? WHERE trade_id = ? AND side = ?", fee, tradeID, side)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET balance_minor = balance_minor - ? WHERE id = ?", fee, userID); err != nil {
+		return err
+	}
+	return addLedgerEntry(tx, userID, orderID, LedgerFee, fee)
+}
@@ -0,0 +1,153 @@
+package db
+
+// a row in halts means trading is stopped for that scope, resuming deletes it.
+// target is the user id or symbol, 0 for the global halt.
+const haltsSchema = `
+    CREATE TABLE IF NOT EXISTS halts (
+        scope TEXT NOT NULL,
+        target INTEGER NOT NULL DEFAULT 0,
+        reason TEXT NOT NULL DEFAULT '',
+        created_by INTEGER NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (scope, target)
+    )
+`
+
+const haltAuditSchema = `
+    CREATE TABLE IF NOT EXISTS halt_audit (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        action TEXT NOT NULL,
+        scope TEXT NOT NULL,
+        target INTEGER NOT NULL DEFAULT 0,
+        reason TEXT NOT NULL DEFAULT '',
+        actor INTEGER NOT NULL,
+        orders_cancelled INTEGER NOT NULL DEFAULT 0,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    )
+`
+
+const (
+	HaltGlobal = "global"
+	HaltUser   = "user"
+	HaltSymbol = "symbol"
+)
+
+type Halt struct {
+	Scope      string `json:"scope"`
+	Target     uint64 `json:"target"`
+	Reason     string `json:"reason"`
+	Created_by uint64 `json:"created_by"`
+	Created_at string `json:"created_at"`
+}
+
+type HaltAudit struct {
+	ID               uint64 `json:"id"`
+	Action           string `json:"action"`
+	Scope            string `json:"scope"`
+	Target           uint64 `json:"target"`
+	Reason           string `json:"reason"`
+	Actor            uint64 `json:"actor"`
+	Orders_cancelled int    `json:"orders_cancelled"`
+	Created_at       string `json:"created_at"`
+}
+
+// ListHalts returns every active halt
+func ListHalts() ([]Halt, error) {
+	rows, err := db.Query("SELECT scope, target, reason, created_by, created_at FROM halts ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var halts []Halt
+	for rows.Next() {
+		var h Halt
+		if err := rows.Scan(&h.Scope, &h.Target, &h.Reason, &h.Created_by, &h.Created_at); err != nil {
+			return nil, err
+		}
+		halts = append(halts, h)
+	}
+	return halts, rows.Err()
+}
+
+// SetHalt stores a halt (or updates its reason) and audits it
+func SetHalt(h Halt) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        INSERT INTO halts (scope, target, reason, created_by) VALUES (?, ?, ?, ?)
+        ON CONFLICT(scope, target) DO UPDATE SET reason = excluded.reason`,
+		h.Scope, h.Target, h.Reason, h.Created_by,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO halt_audit (action, scope, target, reason, actor) VALUES ('halt', ?, ?, ?, ?)",
+		h.Scope, h.Target, h.Reason, h.Created_by,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClearHalt removes a halt and audits the resume, false if there was none
+func ClearHalt(scope string, target, actor uint64, reason string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM halts WHERE scope = ? AND target = ?", scope, target)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	_, err = tx.Exec(
+		"INSERT INTO halt_audit (action, scope, target, reason, actor) VALUES ('resume', ?, ?, ?, ?)",
+		scope, target, reason, actor,
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RecordHaltCancels notes on the latest halt audit row how many orders it pulled
+func RecordHaltCancels(scope string, target uint64, cancelled int) error {
+	_, err := db.Exec(`
+        UPDATE halt_audit SET orders_cancelled = ?
+        WHERE id = (SELECT MAX(id) FROM halt_audit WHERE action = 'halt' AND scope = ? AND target = ?)`,
+		cancelled, scope, target,
+	)
+	return err
+}
+
+// ListHaltAudit returns the most recent audit rows first
+func ListHaltAudit(limit int) ([]HaltAudit, error) {
+	rows, err := db.Query(`SELECT id, action, scope, target, reason, actor, orders_cancelled, created_at
+        FROM halt_audit ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var audit []HaltAudit
+	for rows.Next() {
+		var a HaltAudit
+		err := rows.Scan(&a.ID, &a.Action, &a.Scope, &a.Target, &a.Reason, &a.Actor, &a.Orders_cancelled, &a.Created_at)
+		if err != nil {
+			return nil, err
+		}
+		audit = append(audit, a)
+	}
+	return audit, rows.Err()
+}
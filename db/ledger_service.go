@@ -0,0 +1,297 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"jotacomputing/go-api/decimal"
+)
+
+// reservations.status
+const (
+	ReservationActive   = 0
+	ReservationReleased = 1
+	ReservationSpent    = 2
+)
+
+// ledger_entries.kind
+const (
+	LedgerReserve = "reserve"
+	LedgerRelease = "release"
+	LedgerSpend   = "spend"
+	LedgerCredit  = "credit"
+	LedgerFee     = "fee"
+)
+
+var ErrInsufficientFunds = errors.New("insufficient available balance")
+
+const reservationsSchema = `
+    CREATE TABLE IF NOT EXISTS reservations (
+        user_id INTEGER NOT NULL,
+        order_id INTEGER NOT NULL,
+        amount INTEGER NOT NULL,
+        status INTEGER NOT NULL DEFAULT 0,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (user_id, order_id)
+    )
+`
+
+// every balance movement, reservations included, so the balance can be audited
+const ledgerEntriesSchema = `
+    CREATE TABLE IF NOT EXISTS ledger_entries (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        order_id INTEGER NOT NULL DEFAULT 0,
+        kind TEXT NOT NULL,
+        amount INTEGER NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    )
+`
+
+func addLedgerEntry(tx *sql.Tx, userID, orderID uint64, kind string, amount decimal.Amount) error {
+	_, err := tx.Exec(
+		"INSERT INTO ledger_entries (user_id, order_id, kind, amount) VALUES (?, ?, ?, ?)",
+		userID, orderID, kind, amount,
+	)
+	return err
+}
+
+func reservedFunds(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, userID uint64) (decimal.Amount, error) {
+	var reserved decimal.Amount
+	err := q.QueryRow(
+		"SELECT COALESCE(SUM(amount), 0) FROM reservations WHERE user_id = ? AND status = ?",
+		userID, ReservationActive,
+	).Scan(&reserved)
+	return reserved, err
+}
+
+// AvailableBalance is the balance minus every active reservation
+func AvailableBalance(userID uint64) (balance, reserved decimal.Amount, err error) {
+	err = db.QueryRow("SELECT balance_minor FROM users WHERE id = ?", userID).Scan(&balance)
+	if err != nil {
+		return 0, 0, err
+	}
+	reserved, err = reservedFunds(db, userID)
+	return balance, reserved, err
+}
+
+// ReserveFunds holds amount for an order, failing with ErrInsufficientFunds
+// when the available balance doesn't cover it
+func ReserveFunds(userID, orderID uint64, amount decimal.Amount) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var balance decimal.Amount
+	if err := tx.QueryRow("SELECT balance_minor FROM users WHERE id = ?", userID).Scan(&balance); err != nil {
+		return err
+	}
+	reserved, err := reservedFunds(tx, userID)
+	if err != nil {
+		return err
+	}
+	if balance-reserved < amount {
+		return ErrInsufficientFunds
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO reservations (user_id, order_id, amount) VALUES (?, ?, ?)",
+		userID, orderID, amount,
+	)
+	if err != nil {
+		return err
+	}
+	if err := addLedgerEntry(tx, userID, orderID, LedgerReserve, amount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReservedFor returns what is still held for an order, 0 without an active
+// reservation
+func ReservedFor(userID, orderID uint64) (decimal.Amount, error) {
+	var amount decimal.Amount
+	err := db.QueryRow(
+		"SELECT amount FROM reservations WHERE user_id = ? AND order_id = ? AND status = ?",
+		userID, orderID, ReservationActive,
+	).Scan(&amount)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return amount, err
+}
+
+// GrowReservation adds extra to an order's reservation for an amend, failing
+// with ErrInsufficientFunds when the available balance doesn't cover it. an
+// order without an active reservation gets a new one.
+func GrowReservation(userID, orderID uint64, extra decimal.Amount) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var balance decimal.Amount
+	if err := tx.QueryRow("SELECT balance_minor FROM users WHERE id = ?", userID).Scan(&balance); err != nil {
+		return err
+	}
+	if err := growReservation(tx, userID, orderID, extra, balance); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// growReservation adds extra to a reservation if limit, less every active
+// reservation, covers it
+func growReservation(tx *sql.Tx, userID, orderID uint64, extra, limit decimal.Amount) error {
+	reserved, err := reservedFunds(tx, userID)
+	if err != nil {
+		return err
+	}
+	if limit-reserved < extra {
+		return ErrInsufficientFunds
+	}
+	_, err = tx.Exec(`INSERT INTO reservations (user_id, order_id, amount) VALUES (?, ?, ?)
+        ON CONFLICT(user_id, order_id) DO UPDATE SET amount = CASE WHEN status = ? THEN amount + excluded.amount ELSE excluded.amount END,
+            status = ?, updated_at = CURRENT_TIMESTAMP`,
+		userID, orderID, extra, ReservationActive, ReservationActive,
+	)
+	if err != nil {
+		return err
+	}
+	return addLedgerEntry(tx, userID, orderID, LedgerReserve, extra)
+}
+
+// ReleaseReservation frees whatever is still held for an order.
+// releasing an order without an active reservation is a no-op.
+func ReleaseReservation(userID, orderID uint64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	amount, err := activeReservation(tx, userID, orderID)
+	if err != nil || amount == 0 {
+		return err
+	}
+	_, err = tx.Exec(
+		"UPDATE reservations SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ? AND order_id = ?",
+		ReservationReleased, userID, orderID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := addLedgerEntry(tx, userID, orderID, LedgerRelease, amount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SpendReservation books a fill of a buy order: spent leaves the balance and
+// the reservation shrinks by as much, staying active for the rest of the order
+func SpendReservation(userID, orderID uint64, spent decimal.Amount) error {
+	return spendReservation(userID, orderID, spent, spent)
+}
+
+// SpendMarginReservation books a fill of a margin buy: the whole notional
+// leaves the balance (going negative is the loan) but the reservation only
+// shrinks by the initial margin of the fill
+func SpendMarginReservation(userID, orderID uint64, spent, margin decimal.Amount) error {
+	return spendReservation(userID, orderID, spent, margin)
+}
+
+func spendReservation(userID, orderID uint64, spent, release decimal.Amount) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	amount, err := activeReservation(tx, userID, orderID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET balance_minor = balance_minor - ? WHERE id = ?", spent, userID); err != nil {
+		return err
+	}
+	if err := addLedgerEntry(tx, userID, orderID, LedgerSpend, spent); err != nil {
+		return err
+	}
+	if err := shrinkReservation(tx, userID, orderID, amount, release); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ShrinkReservation takes used off an order's reservation without moving the
+// balance, for margin short sales whose proceeds are credited separately
+func ShrinkReservation(userID, orderID uint64, used decimal.Amount) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	amount, err := activeReservation(tx, userID, orderID)
+	if err != nil {
+		return err
+	}
+	if err := shrinkReservation(tx, userID, orderID, amount, used); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// shrinkReservation lowers an active reservation of amount by used, marking
+// it spent once nothing is left
+func shrinkReservation(tx *sql.Tx, userID, orderID uint64, amount, used decimal.Amount) error {
+	if amount == 0 {
+		return nil
+	}
+	if used > amount {
+		used = amount
+	}
+	status := ReservationActive
+	if used == amount {
+		status = ReservationSpent
+	}
+	_, err := tx.Exec(
+		"UPDATE reservations SET amount = amount - ?, status = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ? AND order_id = ?",
+		used, status, userID, orderID,
+	)
+	return err
+}
+
+func activeReservation(tx *sql.Tx, userID, orderID uint64) (decimal.Amount, error) {
+	var amount decimal.Amount
+	err := tx.QueryRow(
+		"SELECT amount FROM reservations WHERE user_id = ? AND order_id = ? AND status = ?",
+		userID, orderID, ReservationActive,
+	).Scan(&amount)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return amount, err
+}
+
+// CreditBalance adds sale proceeds (or any other credit) to the balance
+func CreditBalance(userID, orderID uint64, amount decimal.Amount, kind string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET balance_minor = balance_minor + ? WHERE id = ?", amount, userID); err != nil {
+		return err
+	}
+	if err := addLedgerEntry(tx, userID, orderID, kind, amount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
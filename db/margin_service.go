@@ -0,0 +1,251 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"jotacomputing/go-api/decimal"
+)
+
+// margin requirements in basis points of position value, symbol 0 is the
+// default for symbols without their own row
+const marginRatesSchema = `
+    CREATE TABLE IF NOT EXISTS margin_rates (
+        symbol INTEGER PRIMARY KEY,
+        initial_bps INTEGER NOT NULL,
+        maintenance_bps INTEGER NOT NULL,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    )
+`
+
+// Reg T style defaults: 50% to open, 25% to hold
+const marginRatesSeed = `
+    INSERT OR IGNORE INTO margin_rates (symbol, initial_bps, maintenance_bps) VALUES (0, 5000, 2500)
+`
+
+// margin calls opened by the monitor. a call stays open until equity is back
+// above maintenance, liquidations counts the orders issued to close it and is
+// stored before each one is sent, so the last one is known after a restart.
+const marginCallsSchema = `
+    CREATE TABLE IF NOT EXISTS margin_calls (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        status TEXT NOT NULL,
+        deficit_minor INTEGER NOT NULL,
+        liquidations INTEGER NOT NULL DEFAULT 0,
+        opened_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL,
+        closed_at INTEGER NOT NULL DEFAULT 0
+    );
+    CREATE INDEX IF NOT EXISTS margin_calls_user ON margin_calls (user_id, id);
+`
+
+// margin_calls.status
+const (
+	MarginCallOpen     = "open"
+	MarginCallResolved = "resolved"
+)
+
+type MarginRate struct {
+	Symbol          uint32 `json:"symbol"`
+	Initial_bps     uint64 `json:"initial_bps"`
+	Maintenance_bps uint64 `json:"maintenance_bps"`
+}
+
+type MarginCall struct {
+	ID           uint64
+	User_id      uint64
+	Status       string
+	Deficit      decimal.Amount
+	Liquidations int
+	Opened_at    int64
+	Updated_at   int64
+	Closed_at    int64
+}
+
+// ListMarginRates returns every configured rate, the default included
+func ListMarginRates() ([]MarginRate, error) {
+	rows, err := db.Query("SELECT symbol, initial_bps, maintenance_bps FROM margin_rates")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []MarginRate
+	for rows.Next() {
+		var r MarginRate
+		if err := rows.Scan(&r.Symbol, &r.Initial_bps, &r.Maintenance_bps); err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+	return rates, rows.Err()
+}
+
+// SetMarginRate creates or updates the rate of a symbol
+func SetMarginRate(r MarginRate) error {
+	_, err := db.Exec(`
+        INSERT INTO margin_rates (symbol, initial_bps, maintenance_bps) VALUES (?, ?, ?)
+        ON CONFLICT(symbol) DO UPDATE SET initial_bps = excluded.initial_bps,
+            maintenance_bps = excluded.maintenance_bps, updated_at = CURRENT_TIMESTAMP`,
+		r.Symbol, r.Initial_bps, r.Maintenance_bps,
+	)
+	return err
+}
+
+// ListMarginAccounts returns the ids of the margin enabled accounts
+func ListMarginAccounts() ([]uint64, error) {
+	rows, err := db.Query("SELECT id FROM users WHERE margin_enabled = 1 ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetMarginEnabled turns an account into a margin account or back into a
+// cash account, false if there is no such user
+func SetMarginEnabled(userID uint64, enabled bool) (bool, error) {
+	result, err := db.Exec("UPDATE users SET margin_enabled = ? WHERE id = ?", enabled, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// UserBalance returns the cash balance, negative on a margin account that
+// borrowed
+func UserBalance(userID uint64) (decimal.Amount, error) {
+	var balance decimal.Amount
+	err := db.QueryRow("SELECT balance_minor FROM users WHERE id = ?", userID).Scan(&balance)
+	return balance, err
+}
+
+// ReserveMargin holds amount of initial margin for an order, failing with
+// ErrInsufficientFunds when excess, the account's equity above its initial
+// requirement, doesn't cover it on top of the other active reservations
+func ReserveMargin(userID, orderID uint64, amount, excess decimal.Amount) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	reserved, err := reservedFunds(tx, userID)
+	if err != nil {
+		return err
+	}
+	if excess-reserved < amount {
+		return ErrInsufficientFunds
+	}
+	_, err = tx.Exec(
+		"INSERT INTO reservations (user_id, order_id, amount) VALUES (?, ?, ?)",
+		userID, orderID, amount,
+	)
+	if err != nil {
+		return err
+	}
+	if err := addLedgerEntry(tx, userID, orderID, LedgerReserve, amount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GrowMarginReservation adds extra initial margin to an order's reservation
+// for an amend, failing with ErrInsufficientFunds when excess doesn't cover it
+// on top of the other active reservations
+func GrowMarginReservation(userID, orderID uint64, extra, excess decimal.Amount) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := growReservation(tx, userID, orderID, extra, excess); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// OpenMarginCall records a new call and sets c.ID
+func OpenMarginCall(c *MarginCall) error {
+	ts := now()
+	c.Status, c.Opened_at, c.Updated_at = MarginCallOpen, ts, ts
+	result, err := db.Exec(`INSERT INTO margin_calls (user_id, status, deficit_minor, opened_at, updated_at)
+        VALUES (?, ?, ?, ?, ?)`,
+		c.User_id, c.Status, c.Deficit, ts, ts,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	c.ID = uint64(id)
+	return nil
+}
+
+// UpdateMarginCall stores the deficit, liquidation count and status of a call
+func UpdateMarginCall(c *MarginCall) error {
+	c.Updated_at = now()
+	if c.Status != MarginCallOpen && c.Closed_at == 0 {
+		c.Closed_at = c.Updated_at
+	}
+	_, err := db.Exec(`UPDATE margin_calls SET status = ?, deficit_minor = ?, liquidations = ?, updated_at = ?,
+            closed_at = ? WHERE id = ?`,
+		c.Status, c.Deficit, c.Liquidations, c.Updated_at, c.Closed_at, c.ID,
+	)
+	return err
+}
+
+const marginCallColumns = "id, user_id, status, deficit_minor, liquidations, opened_at, updated_at, closed_at"
+
+func scanMarginCall(row interface{ Scan(...interface{}) error }) (*MarginCall, error) {
+	c := &MarginCall{}
+	err := row.Scan(&c.ID, &c.User_id, &c.Status, &c.Deficit, &c.Liquidations, &c.Opened_at, &c.Updated_at, &c.Closed_at)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadOpenMarginCalls returns every call still open, used on startup
+func LoadOpenMarginCalls() ([]*MarginCall, error) {
+	rows, err := db.Query("SELECT "+marginCallColumns+" FROM margin_calls WHERE status = ?", MarginCallOpen)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var calls []*MarginCall
+	for rows.Next() {
+		c, err := scanMarginCall(rows)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, c)
+	}
+	return calls, rows.Err()
+}
+
+// LatestMarginCall returns the user's most recent call, nil if there never was one
+func LatestMarginCall(userID uint64) (*MarginCall, error) {
+	c, err := scanMarginCall(db.QueryRow(
+		"SELECT "+marginCallColumns+" FROM margin_calls WHERE user_id = ? ORDER BY id DESC LIMIT 1", userID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return c, err
+}
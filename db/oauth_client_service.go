@@ -0,0 +1,104 @@
+package db
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrDuplicateClient = errors.New("client id already used")
+
+// OAuth2 clients allowed to ask for tokens. the secret is only kept as a
+// sha256 hash, redirect_uris, grants and scopes are space separated lists.
+const oauthClientsSchema = `
+    CREATE TABLE IF NOT EXISTS oauth_clients (
+        id TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
+        secret_hash TEXT NOT NULL,
+        redirect_uris TEXT NOT NULL DEFAULT '',
+        grants TEXT NOT NULL,
+        scopes TEXT NOT NULL DEFAULT '',
+        disabled INTEGER NOT NULL DEFAULT 0,
+        created_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL
+    )
+`
+
+type OAuthClient struct {
+	ID            string   `json:"client_id"`
+	Name          string   `json:"name"`
+	Secret_hash   string   `json:"-"`
+	Redirect_uris []string `json:"redirect_uris"`
+	Grants        []string `json:"grants"`
+	Scopes        []string `json:"scopes"`
+	Disabled      bool     `json:"disabled"`
+	Created_at    int64    `json:"created_at"`
+	Updated_at    int64    `json:"updated_at"`
+}
+
+// ListOAuthClients returns every client, disabled ones included
+func ListOAuthClients() ([]OAuthClient, error) {
+	rows, err := db.Query(`SELECT id, name, secret_hash, redirect_uris, grants, scopes, disabled, created_at, updated_at
+        FROM oauth_clients ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []OAuthClient
+	for rows.Next() {
+		var (
+			c                       OAuthClient
+			redirects, grants, scps string
+		)
+		err := rows.Scan(&c.ID, &c.Name, &c.Secret_hash, &redirects, &grants, &scps, &c.Disabled, &c.Created_at, &c.Updated_at)
+		if err != nil {
+			return nil, err
+		}
+		c.Redirect_uris, c.Grants, c.Scopes = strings.Fields(redirects), strings.Fields(grants), strings.Fields(scps)
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// InsertOAuthClient stores a new client and sets its timestamps, failing with
+// ErrDuplicateClient if the id is taken
+func InsertOAuthClient(c *OAuthClient) error {
+	c.Created_at = now()
+	c.Updated_at = c.Created_at
+	_, err := db.Exec(`INSERT INTO oauth_clients
+            (id, name, secret_hash, redirect_uris, grants, scopes, disabled, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.Name, c.Secret_hash, strings.Join(c.Redirect_uris, " "), strings.Join(c.Grants, " "),
+		strings.Join(c.Scopes, " "), c.Disabled, c.Created_at, c.Updated_at,
+	)
+	if isDuplicate(err) {
+		return ErrDuplicateClient
+	}
+	return err
+}
+
+// SetOAuthClientSecret replaces the secret hash of a client, false if there
+// is no such client
+func SetOAuthClientSecret(id, secretHash string) (bool, error) {
+	result, err := db.Exec(
+		"UPDATE oauth_clients SET secret_hash = ?, updated_at = ? WHERE id = ?", secretHash, now(), id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// SetOAuthClientDisabled disables or re-enables a client, false if there is
+// no such client
+func SetOAuthClientDisabled(id string, disabled bool) (bool, error) {
+	result, err := db.Exec(
+		"UPDATE oauth_clients SET disabled = ?, updated_at = ? WHERE id = ?", disabled, now(), id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+
+	"jotacomputing/go-api/structs"
+)
+
+// linked orders (OCO pairs and brackets) managed by the gateway.
+// each leg keeps the full order it was created with so bracket exits that
+// are only sent once the entry fills survive a restart.
+const orderGroupsSchema = `
+    CREATE TABLE IF NOT EXISTS order_groups (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        kind TEXT NOT NULL,
+        status TEXT NOT NULL,
+        created_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS order_groups_status ON order_groups (status);
+`
+
+const orderGroupLegsSchema = `
+    CREATE TABLE IF NOT EXISTS order_group_legs (
+        group_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        order_id INTEGER NOT NULL,
+        role TEXT NOT NULL,
+        status TEXT NOT NULL,
+        params TEXT NOT NULL,
+        updated_at INTEGER NOT NULL,
+        PRIMARY KEY (user_id, order_id)
+    );
+    CREATE INDEX IF NOT EXISTS order_group_legs_group ON order_group_legs (group_id);
+`
+
+// order_groups.kind
+const (
+	GroupOCO     = "oco"
+	GroupBracket = "bracket"
+)
+
+// order_groups.status
+const (
+	GroupPending   = "pending" // bracket waiting for its entry to fill
+	GroupActive    = "active"
+	GroupCompleted = "completed"
+	GroupCancelled = "cancelled"
+	GroupRejected  = "rejected"
+)
+
+// order_group_legs.role
+const (
+	LegEntry      = "entry"
+	LegTakeProfit = "take_profit"
+	LegStopLoss   = "stop_loss"
+	LegOCO        = "leg"
+)
+
+// order_group_legs.status
+const (
+	LegPending   = "pending" // not sent yet
+	LegWorking   = "working"
+	LegFilled    = "filled"
+	LegCancelled = "cancelled"
+	LegRejected  = "rejected"
+)
+
+var ErrDuplicateLeg = errors.New("order id already used")
+
+type OrderGroupLeg struct {
+	Role   string
+	Status string
+	Order  structs.StopOrder // Stop_price is 0 for anything but stop legs
+}
+
+type OrderGroup struct {
+	ID         uint64
+	User_id    uint64
+	Kind       string
+	Status     string
+	Legs       []OrderGroupLeg
+	Created_at int64
+	Updated_at int64
+}
+
+// CreateOrderGroup stores a new group with its legs and sets g.ID. fails with
+// ErrDuplicateLeg if one of the order ids was already used by the user.
+func CreateOrderGroup(g *OrderGroup) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ts := now()
+	result, err := tx.Exec(
+		"INSERT INTO order_groups (user_id, kind, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		g.User_id, g.Kind, g.Status, ts, ts,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, leg := range g.Legs {
+		o := leg.Order.Order
+		var used int
+		err := tx.QueryRow(
+			"SELECT COUNT(*) FROM orders WHERE user_id = ? AND order_id = ?", o.User_id, o.Order_id,
+		).Scan(&used)
+		if err != nil {
+			return err
+		}
+		if used > 0 {
+			return ErrDuplicateLeg
+		}
+		params, err := json.Marshal(leg.Order)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO order_group_legs (group_id, user_id, order_id, role, status, params, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, o.User_id, o.Order_id, leg.Role, leg.Status, string(params), ts,
+		)
+		if isDuplicate(err) {
+			return ErrDuplicateLeg
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	g.ID = uint64(id)
+	g.Created_at, g.Updated_at = ts, ts
+	return nil
+}
+
+// SetOrderGroupStatus updates the status of a group
+func SetOrderGroupStatus(groupID uint64, status string) error {
+	_, err := db.Exec("UPDATE order_groups SET status = ?, updated_at = ? WHERE id = ?", status, now(), groupID)
+	return err
+}
+
+// UpdateOrderGroupLeg stores a leg's status and the order it was sent with
+func UpdateOrderGroupLeg(leg OrderGroupLeg) error {
+	params, err := json.Marshal(leg.Order)
+	if err != nil {
+		return err
+	}
+	o := leg.Order.Order
+	_, err = db.Exec("UPDATE order_group_legs SET status = ?, params = ?, updated_at = ? WHERE user_id = ? AND order_id = ?",
+		leg.Status, string(params), now(), o.User_id, o.Order_id)
+	return err
+}
+
+const orderGroupColumns = "id, user_id, kind, status, created_at, updated_at"
+
+func scanOrderGroup(row interface{ Scan(...interface{}) error }) (*OrderGroup, error) {
+	g := &OrderGroup{}
+	if err := row.Scan(&g.ID, &g.User_id, &g.Kind, &g.Status, &g.Created_at, &g.Updated_at); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func loadLegs(g *OrderGroup) error {
+	rows, err := db.Query(
+		"SELECT role, status, params FROM order_group_legs WHERE group_id = ? ORDER BY rowid", g.ID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var leg OrderGroupLeg
+		var params string
+		if err := rows.Scan(&leg.Role, &leg.Status, &params); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(params), &leg.Order); err != nil {
+			return err
+		}
+		g.Legs = append(g.Legs, leg)
+	}
+	return rows.Err()
+}
+
+// GetOrderGroup returns one of the user's groups with its legs, sql.ErrNoRows
+// if the user has no such group
+func GetOrderGroup(userID, groupID uint64) (*OrderGroup, error) {
+	g, err := scanOrderGroup(db.QueryRow(
+		"SELECT "+orderGroupColumns+" FROM order_groups WHERE user_id = ? AND id = ?", userID, groupID,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return g, loadLegs(g)
+}
+
+// LoadLiveOrderGroups returns every group with a leg that is not done yet,
+// used to rebuild the gateway's view of them on startup
+func LoadLiveOrderGroups() ([]*OrderGroup, error) {
+	rows, err := db.Query(
+		"SELECT "+orderGroupColumns+" FROM order_groups WHERE id IN "+
+			"(SELECT group_id FROM order_group_legs WHERE status IN (?, ?))",
+		LegPending, LegWorking,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*OrderGroup
+	for rows.Next() {
+		g, err := scanOrderGroup(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		if err := loadLegs(g); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
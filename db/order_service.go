@@ -0,0 +1,360 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"jotacomputing/go-api/structs"
+)
+
+var ErrDuplicateOrder = errors.New("order id already used")
+
+// every order the gateway saw, with the engine status it last reported.
+// times are unix nanos so they sort and paginate without parsing.
+const ordersSchema = `
+    CREATE TABLE IF NOT EXISTS orders (
+        user_id INTEGER NOT NULL,
+        order_id INTEGER NOT NULL,
+        symbol INTEGER NOT NULL,
+        side INTEGER NOT NULL,
+        order_type INTEGER NOT NULL,
+        price INTEGER NOT NULL,
+        shares_qty INTEGER NOT NULL,
+        time_in_force INTEGER NOT NULL DEFAULT 0,
+        expire_at INTEGER NOT NULL DEFAULT 0,
+        timestamp INTEGER NOT NULL,
+        status INTEGER NOT NULL DEFAULT 0,
+        reason TEXT NOT NULL DEFAULT '',
+        created_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL,
+        PRIMARY KEY (user_id, order_id)
+    );
+    CREATE INDEX IF NOT EXISTS orders_status ON orders (status);
+    CREATE INDEX IF NOT EXISTS orders_user_created ON orders (user_id, created_at, order_id);
+`
+
+const orderEventsSchema = `
+    CREATE TABLE IF NOT EXISTS order_events (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        order_id INTEGER NOT NULL,
+        event TEXT NOT NULL,
+        status INTEGER NOT NULL,
+        price INTEGER NOT NULL DEFAULT 0,
+        shares_qty INTEGER NOT NULL DEFAULT 0,
+        reason TEXT NOT NULL DEFAULT '',
+        created_at INTEGER NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS order_events_order ON order_events (user_id, order_id);
+`
+
+// order_events.event
+const (
+	EventSubmitted        = "submitted"
+	EventRejected         = "rejected" // refused by the gateway before reaching the engine
+	EventCancelRequested  = "cancel_requested"
+	EventReplaceRequested = "replace_requested"
+	EventStatus           = "status" // engine status update
+)
+
+type OrderRecord struct {
+	structs.Order
+	Reason     string
+	Created_at int64
+	Updated_at int64
+}
+
+type OrderEvent struct {
+	ID         uint64 `json:"id"`
+	User_id    uint64 `json:"user_id"`
+	Order_id   uint64 `json:"order_id"`
+	Event      string `json:"event"`
+	Status     uint8  `json:"status"`
+	Price      uint64 `json:"price"`
+	Shares_qty uint32 `json:"shares_qty"`
+	Reason     string `json:"reason,omitempty"`
+	Created_at int64  `json:"created_at"`
+}
+
+func now() int64 {
+	return time.Now().UnixNano()
+}
+
+func addOrderEvent(q interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, e OrderEvent) error {
+	_, err := q.Exec(`INSERT INTO order_events (user_id, order_id, event, status, price, shares_qty, reason, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.User_id, e.Order_id, e.Event, e.Status, e.Price, e.Shares_qty, e.Reason, now(),
+	)
+	return err
+}
+
+// leaves_qty is what is still working on the book, the same quantity the
+// open order tracker holds: shares_qty less the fills, or what an amend set.
+// it is kept with the fills and amends so a restart rebuilds the tracker
+// from it.
+func migrateOrders() error {
+	added, err := addColumnIfMissing("orders", "leaves_qty", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil || !added {
+		return err
+	}
+	// one off for the open orders, the fills since the last amend come off
+	// the quantity that amend set
+	_, err = db.Exec(`UPDATE orders SET leaves_qty = MAX(shares_qty - COALESCE((
+            SELECT SUM(e.shares_qty) FROM executions e
+            WHERE e.user_id = orders.user_id AND e.order_id = orders.order_id AND e.created_at > COALESCE((
+                SELECT MAX(v.created_at) FROM order_events v
+                WHERE v.user_id = orders.user_id AND v.order_id = orders.order_id AND v.event = ? AND v.status = ?), 0)
+        ), 0), 0) WHERE status IN (?, ?)`,
+		EventStatus, structs.StatusReplaced, structs.StatusPending, structs.StatusPartiallyFilled,
+	)
+	return err
+}
+
+// InsertOrder records a new order before it goes anywhere, failing with
+// ErrDuplicateOrder if the user already used the order id
+func InsertOrder(o structs.Order) error {
+	return insertOrder(db, o)
+}
+
+func insertOrder(q interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, o structs.Order) error {
+	ts := now()
+	_, err := q.Exec(`INSERT INTO orders (user_id, order_id, symbol, side, order_type, price, shares_qty, leaves_qty,
+            display_qty, time_in_force, expire_at, stp_mode, stp_group, timestamp, status, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		o.User_id, o.Order_id, o.Symbol, o.Side, o.Order_type, o.Price, o.Shares_qty, o.Shares_qty, o.Display_qty,
+		o.Time_in_force, o.Expire_at, o.Stp_mode, o.Stp_group, o.Timestamp, o.Status, ts, ts,
+	)
+	if isDuplicate(err) {
+		return ErrDuplicateOrder
+	}
+	return err
+}
+
+// MarkOrderSubmitted notes that the order reached the engine's ring, along
+// with the short sale flag which is only known once the holdings check passed
+func MarkOrderSubmitted(o structs.Order) error {
+	if o.Short_sale != 0 {
+		_, err := db.Exec("UPDATE orders SET short_sale = ? WHERE user_id = ? AND order_id = ?", o.Short_sale, o.User_id, o.Order_id)
+		if err != nil {
+			return err
+		}
+	}
+	return addOrderEvent(db, OrderEvent{
+		User_id: o.User_id, Order_id: o.Order_id, Event: EventSubmitted,
+		Status: o.Status, Price: o.Price, Shares_qty: o.Shares_qty,
+	})
+}
+
+// MarkOrderRejected closes an order the gateway refused
+func MarkOrderRejected(o structs.Order, reason string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE orders SET status = ?, reason = ?, updated_at = ? WHERE user_id = ? AND order_id = ?",
+		structs.StatusRejected, reason, now(), o.User_id, o.Order_id)
+	if err != nil {
+		return err
+	}
+	err = addOrderEvent(tx, OrderEvent{
+		User_id: o.User_id, Order_id: o.Order_id, Event: EventRejected,
+		Status: structs.StatusRejected, Price: o.Price, Shares_qty: o.Shares_qty, Reason: reason,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddOrderEvent records a request made on an existing order (cancel, replace)
+func AddOrderEvent(userID, orderID uint64, event string, price uint64, qty uint32, reason string) error {
+	return addOrderEvent(db, OrderEvent{
+		User_id: userID, Order_id: orderID, Event: event,
+		Price: price, Shares_qty: qty, Reason: reason,
+	})
+}
+
+// RecordStatusUpdate applies an engine status update to the order row and
+// logs it. replace answers only touch price/qty, the order stays open.
+func RecordStatusUpdate(update structs.Order) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	switch update.Status {
+	case structs.StatusReplaced:
+		_, err = tx.Exec("UPDATE orders SET price = ?, shares_qty = ?, leaves_qty = ?, status = ?, updated_at = ? WHERE user_id = ? AND order_id = ?",
+			update.Price, update.Shares_qty, update.Shares_qty, structs.StatusPending, now(), update.User_id, update.Order_id)
+	case structs.StatusReplaceRejected:
+	default:
+		_, err = tx.Exec("UPDATE orders SET status = ?, updated_at = ? WHERE user_id = ? AND order_id = ?",
+			update.Status, now(), update.User_id, update.Order_id)
+	}
+	if err != nil {
+		return err
+	}
+	err = addOrderEvent(tx, OrderEvent{
+		User_id: update.User_id, Order_id: update.Order_id, Event: EventStatus,
+		Status: update.Status, Price: update.Price, Shares_qty: update.Shares_qty,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const orderColumns = `user_id, order_id, symbol, side, order_type, price, shares_qty,
+    display_qty, time_in_force, expire_at, stp_mode, stp_group, short_sale, timestamp, status, reason, created_at, updated_at`
+
+func scanOrder(row interface{ Scan(...interface{}) error }) (*OrderRecord, error) {
+	r := &OrderRecord{}
+	o := &r.Order
+	err := row.Scan(&o.User_id, &o.Order_id, &o.Symbol, &o.Side, &o.Order_type, &o.Price, &o.Shares_qty,
+		&o.Display_qty, &o.Time_in_force, &o.Expire_at, &o.Stp_mode, &o.Stp_group, &o.Short_sale, &o.Timestamp, &o.Status, &r.Reason, &r.Created_at, &r.Updated_at)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetOrder returns one order, sql.ErrNoRows if the user has no such order
+func GetOrder(userID, orderID uint64) (*OrderRecord, error) {
+	return scanOrder(db.QueryRow(
+		"SELECT "+orderColumns+" FROM orders WHERE user_id = ? AND order_id = ?",
+		userID, orderID,
+	))
+}
+
+// scanFunc lets a query with extra columns reuse scanOrder
+type scanFunc func(...interface{}) error
+
+func (f scanFunc) Scan(dest ...interface{}) error { return f(dest...) }
+
+// LoadOpenOrders returns every order the engine may still be working, used
+// to rebuild the open order tracker on startup. Shares_qty is the leaves_qty,
+// what the tracker holds. armed stops are left out, they are in the trigger
+// book.
+func LoadOpenOrders() ([]structs.Order, error) {
+	rows, err := db.Query(
+		"SELECT "+orderColumns+", leaves_qty FROM orders WHERE status IN (?, ?) AND order_type NOT IN (?, ?)",
+		structs.StatusPending, structs.StatusPartiallyFilled, structs.OrderTypeStop, structs.OrderTypeStopLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var open []structs.Order
+	for rows.Next() {
+		var leaves uint32
+		r, err := scanOrder(scanFunc(func(dest ...interface{}) error {
+			return rows.Scan(append(dest, &leaves)...)
+		}))
+		if err != nil {
+			return nil, err
+		}
+		r.Order.Shares_qty = leaves
+		open = append(open, r.Order)
+	}
+	return open, rows.Err()
+}
+
+// ListOrderEvents returns the full timeline of one order, oldest first
+func ListOrderEvents(userID, orderID uint64) ([]OrderEvent, error) {
+	rows, err := db.Query(`SELECT id, user_id, order_id, event, status, price, shares_qty, reason, created_at
+        FROM order_events WHERE user_id = ? AND order_id = ? ORDER BY id`,
+		userID, orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []OrderEvent
+	for rows.Next() {
+		var e OrderEvent
+		err := rows.Scan(&e.ID, &e.User_id, &e.Order_id, &e.Event, &e.Status, &e.Price, &e.Shares_qty, &e.Reason, &e.Created_at)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// OrderQuery selects a page of one user's orders, newest first.
+// zero fields don't filter. After* is the cursor: the last row of the previous page.
+type OrderQuery struct {
+	User_id        uint64
+	Statuses       []uint8
+	Symbol         uint32
+	Side           *uint8
+	From, To       int64 // created_at range, unix nanos, To exclusive
+	After_created  int64
+	After_order_id uint64
+	Limit          int
+}
+
+// ListOrders returns one page of orders matching q
+func ListOrders(q OrderQuery) ([]OrderRecord, error) {
+	where := []string{"user_id = ?"}
+	args := []interface{}{q.User_id}
+
+	if len(q.Statuses) > 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(q.Statuses)-1)+")")
+		for _, s := range q.Statuses {
+			args = append(args, s)
+		}
+	}
+	if q.Symbol != 0 {
+		where = append(where, "symbol = ?")
+		args = append(args, q.Symbol)
+	}
+	if q.Side != nil {
+		where = append(where, "side = ?")
+		args = append(args, *q.Side)
+	}
+	if q.From != 0 {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.From)
+	}
+	if q.To != 0 {
+		where = append(where, "created_at < ?")
+		args = append(args, q.To)
+	}
+	if q.After_created != 0 {
+		where = append(where, "(created_at < ? OR (created_at = ? AND order_id < ?))")
+		args = append(args, q.After_created, q.After_created, q.After_order_id)
+	}
+	args = append(args, q.Limit)
+
+	rows, err := db.Query(
+		"SELECT "+orderColumns+" FROM orders WHERE "+strings.Join(where, " AND ")+
+			" ORDER BY created_at DESC, order_id DESC LIMIT ?",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []OrderRecord
+	for rows.Next() {
+		r, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *r)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"testing"
+
+	"jotacomputing/go-api/structs"
+)
+
+func TestLeavesQty(t *testing.T) {
+	t.Chdir(t.TempDir())
+	InitDB()
+
+	sell := structs.Order{User_id: 1, Order_id: 5, Symbol: 1, Side: 1, Order_type: structs.OrderTypeLimit, Price: 100, Shares_qty: 10, Timestamp: 1}
+	if err := InsertOrder(sell); err != nil {
+		t.Fatal(err)
+	}
+	fill := func(trade uint64, qty uint32) {
+		t.Helper()
+		_, err := InsertFill(structs.Fill{Trade_id: trade, Sell_user_id: 1, Sell_order_id: 5, Buy_user_id: 2, Buy_order_id: 9,
+			Symbol: 1, Price: 100, Shares_qty: qty, Timestamp: trade}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	leaves := func(step string, want uint32) {
+		t.Helper()
+		open, err := LoadOpenOrders()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(open) != 1 || open[0].Shares_qty != want {
+			t.Errorf("%s: open orders %+v, want one with %d left", step, open, want)
+		}
+	}
+
+	fill(1, 3)
+	leaves("partial fill", 7)
+	fill(1, 3)
+	leaves("replayed fill", 7)
+
+	// an amend sets what is left, earlier fills are not taken off again
+	amended := sell
+	amended.Status = structs.StatusReplaced
+	amended.Shares_qty = 4
+	if err := RecordStatusUpdate(amended); err != nil {
+		t.Fatal(err)
+	}
+	leaves("amended", 4)
+	fill(2, 1)
+	leaves("fill after the amend", 3)
+	fill(3, 5)
+	leaves("overfill", 0)
+}
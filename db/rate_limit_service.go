@@ -0,0 +1,77 @@
+package db
+
+// throttles per account tier, users.tier points at rate_tiers.tier.
+// max_cancel_ratio is the cancels per submitted order over the ratio window
+// beyond which new orders are throttled.
+const rateTiersSchema = `
+    CREATE TABLE IF NOT EXISTS rate_tiers (
+        tier TEXT PRIMARY KEY,
+        order_per_sec INTEGER NOT NULL,
+        order_burst INTEGER NOT NULL,
+        cancel_per_sec INTEGER NOT NULL,
+        cancel_burst INTEGER NOT NULL,
+        query_per_sec INTEGER NOT NULL,
+        query_burst INTEGER NOT NULL,
+        max_cancel_ratio INTEGER NOT NULL
+    )
+`
+
+const DefaultTier = "standard"
+
+// seeded once, edit the rows to retune a tier
+const rateTiersSeed = `
+    INSERT OR IGNORE INTO rate_tiers VALUES
+        ('standard',      10,   20,   10,   20,   20,   40,  5),
+        ('pro',           50,  100,   50,  100,   50,  100, 10),
+        ('market_maker', 500, 1000, 1000, 2000,  100,  200, 50)
+`
+
+type RateTier struct {
+	Tier           string
+	OrderPerSec    int
+	OrderBurst     int
+	CancelPerSec   int
+	CancelBurst    int
+	QueryPerSec    int
+	QueryBurst     int
+	MaxCancelRatio int
+}
+
+// ListRateTiers returns every configured tier
+func ListRateTiers() ([]RateTier, error) {
+	rows, err := db.Query(`SELECT tier, order_per_sec, order_burst, cancel_per_sec, cancel_burst,
+        query_per_sec, query_burst, max_cancel_ratio FROM rate_tiers`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tiers []RateTier
+	for rows.Next() {
+		var t RateTier
+		err := rows.Scan(&t.Tier, &t.OrderPerSec, &t.OrderBurst, &t.CancelPerSec, &t.CancelBurst,
+			&t.QueryPerSec, &t.QueryBurst, &t.MaxCancelRatio)
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, t)
+	}
+	return tiers, rows.Err()
+}
+
+// UserTier returns the account tier of a user
+func UserTier(userID uint64) (string, error) {
+	var tier string
+	err := db.QueryRow("SELECT tier FROM users WHERE id = ?", userID).Scan(&tier)
+	return tier, err
+}
+
+// SetUserTier moves an account to another tier, false if there is no such user
+func SetUserTier(userID uint64, tier string) (bool, error) {
+	result, err := db.Exec("UPDATE users SET tier = ? WHERE id = ?", tier, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
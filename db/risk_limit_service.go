@@ -0,0 +1,58 @@
+package db
+
+// risk limit overrides, user_id 0 and symbol 0 mean "any". see risk.Limits
+const riskLimitsSchema = `
+    CREATE TABLE IF NOT EXISTS risk_limits (
+        user_id INTEGER NOT NULL DEFAULT 0,
+        symbol INTEGER NOT NULL DEFAULT 0,
+        name TEXT NOT NULL,
+        value INTEGER NOT NULL,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (user_id, symbol, name)
+    )
+`
+
+type RiskLimit struct {
+	User_id uint64 `json:"user_id"`
+	Symbol  uint32 `json:"symbol"`
+	Name    string `json:"name"`
+	Value   int64  `json:"value"`
+}
+
+// ListRiskLimits returns every configured override
+func ListRiskLimits() ([]RiskLimit, error) {
+	rows, err := db.Query("SELECT user_id, symbol, name, value FROM risk_limits")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var limits []RiskLimit
+	for rows.Next() {
+		var l RiskLimit
+		if err := rows.Scan(&l.User_id, &l.Symbol, &l.Name, &l.Value); err != nil {
+			return nil, err
+		}
+		limits = append(limits, l)
+	}
+	return limits, rows.Err()
+}
+
+// SetRiskLimit creates or updates one override
+func SetRiskLimit(l RiskLimit) error {
+	_, err := db.Exec(`
+        INSERT INTO risk_limits (user_id, symbol, name, value) VALUES (?, ?, ?, ?)
+        ON CONFLICT(user_id, symbol, name) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP`,
+		l.User_id, l.Symbol, l.Name, l.Value,
+	)
+	return err
+}
+
+// DeleteRiskLimit drops one override so the next broader scope applies again
+func DeleteRiskLimit(userID uint64, symbol uint32, name string) error {
+	_, err := db.Exec(
+		"DELETE FROM risk_limits WHERE user_id = ? AND symbol = ? AND name = ?",
+		userID, symbol, name,
+	)
+	return err
+}
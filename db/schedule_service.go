@@ -0,0 +1,244 @@
+package db
+
+import (
+	"jotacomputing/go-api/decimal"
+)
+
+// order templates sent on a cron schedule. a template has either a fixed
+// shares_qty or a cash notional that is turned into a quantity when it fires.
+// next_run is the claim: whoever moves it forward owns that run.
+const schedulesSchema = `
+    CREATE TABLE IF NOT EXISTS schedules (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        name TEXT NOT NULL DEFAULT '',
+        cron TEXT NOT NULL,
+        timezone TEXT NOT NULL,
+        symbol INTEGER NOT NULL,
+        side INTEGER NOT NULL,
+        order_type INTEGER NOT NULL,
+        price INTEGER NOT NULL DEFAULT 0,
+        shares_qty INTEGER NOT NULL DEFAULT 0,
+        notional_minor INTEGER NOT NULL DEFAULT 0,
+        time_in_force INTEGER NOT NULL DEFAULT 0,
+        enabled INTEGER NOT NULL DEFAULT 1,
+        next_run INTEGER NOT NULL,
+        last_run INTEGER NOT NULL DEFAULT 0,
+        created_at INTEGER NOT NULL,
+        updated_at INTEGER NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS schedules_due ON schedules (enabled, next_run);
+`
+
+const scheduleRunsSchema = `
+    CREATE TABLE IF NOT EXISTS schedule_runs (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        schedule_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        due_at INTEGER NOT NULL,
+        order_id INTEGER NOT NULL DEFAULT 0,
+        outcome TEXT NOT NULL,
+        reason TEXT NOT NULL DEFAULT '',
+        created_at INTEGER NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS schedule_runs_schedule ON schedule_runs (schedule_id, id);
+`
+
+// schedule_runs.outcome
+const (
+	RunPending   = "pending"
+	RunSubmitted = "submitted"
+	RunRejected  = "rejected" // invalid template or refused by the pre-trade checks
+	RunFailed    = "failed"
+	RunMissed    = "missed" // gateway was down past the due time
+)
+
+type Schedule struct {
+	ID            uint64
+	User_id       uint64
+	Name          string
+	Cron          string
+	Timezone      string
+	Symbol        uint32
+	Side          uint8
+	Order_type    uint8
+	Price         uint64
+	Shares_qty    uint64
+	Notional      decimal.Amount
+	Time_in_force uint8
+	Enabled       bool
+	Next_run      int64 // unix nanos
+	Last_run      int64
+	Created_at    int64
+	Updated_at    int64
+}
+
+type ScheduleRun struct {
+	ID          uint64
+	Schedule_id uint64
+	User_id     uint64
+	Due_at      int64
+	Order_id    uint64
+	Outcome     string
+	Reason      string
+	Created_at  int64
+}
+
+const scheduleColumns = `id, user_id, name, cron, timezone, symbol, side, order_type, price, shares_qty,
+    notional_minor, time_in_force, enabled, next_run, last_run, created_at, updated_at`
+
+func scanSchedule(row interface{ Scan(...interface{}) error }) (*Schedule, error) {
+	s := &Schedule{}
+	err := row.Scan(&s.ID, &s.User_id, &s.Name, &s.Cron, &s.Timezone, &s.Symbol, &s.Side, &s.Order_type,
+		&s.Price, &s.Shares_qty, &s.Notional, &s.Time_in_force, &s.Enabled, &s.Next_run, &s.Last_run,
+		&s.Created_at, &s.Updated_at)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func querySchedules(where string, args ...interface{}) ([]*Schedule, error) {
+	rows, err := db.Query("SELECT "+scheduleColumns+" FROM schedules WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []*Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+// CreateSchedule stores a new schedule and sets s.ID
+func CreateSchedule(s *Schedule) error {
+	ts := now()
+	result, err := db.Exec(`INSERT INTO schedules (user_id, name, cron, timezone, symbol, side, order_type, price,
+            shares_qty, notional_minor, time_in_force, enabled, next_run, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.User_id, s.Name, s.Cron, s.Timezone, s.Symbol, s.Side, s.Order_type, s.Price,
+		s.Shares_qty, s.Notional, s.Time_in_force, s.Enabled, s.Next_run, ts, ts,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	s.ID = uint64(id)
+	s.Created_at, s.Updated_at = ts, ts
+	return nil
+}
+
+// UpdateSchedule replaces the template and timing of one of the user's
+// schedules, false if there is no such schedule
+func UpdateSchedule(s *Schedule) (bool, error) {
+	s.Updated_at = now()
+	result, err := db.Exec(`UPDATE schedules SET name = ?, cron = ?, timezone = ?, symbol = ?, side = ?,
+            order_type = ?, price = ?, shares_qty = ?, notional_minor = ?, time_in_force = ?, enabled = ?,
+            next_run = ?, updated_at = ?
+        WHERE id = ? AND user_id = ?`,
+		s.Name, s.Cron, s.Timezone, s.Symbol, s.Side, s.Order_type, s.Price, s.Shares_qty, s.Notional,
+		s.Time_in_force, s.Enabled, s.Next_run, s.Updated_at, s.ID, s.User_id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteSchedule removes one of the user's schedules, its run history stays
+func DeleteSchedule(userID, scheduleID uint64) (bool, error) {
+	result, err := db.Exec("DELETE FROM schedules WHERE id = ? AND user_id = ?", scheduleID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetSchedule returns one of the user's schedules, sql.ErrNoRows if missing
+func GetSchedule(userID, scheduleID uint64) (*Schedule, error) {
+	return scanSchedule(db.QueryRow(
+		"SELECT "+scheduleColumns+" FROM schedules WHERE id = ? AND user_id = ?", scheduleID, userID,
+	))
+}
+
+// ListSchedules returns the user's schedules
+func ListSchedules(userID uint64) ([]*Schedule, error) {
+	return querySchedules("user_id = ? ORDER BY id", userID)
+}
+
+// DueSchedules returns enabled schedules whose next run is at or before t
+func DueSchedules(t int64) ([]*Schedule, error) {
+	return querySchedules("enabled = 1 AND next_run <= ? ORDER BY next_run", t)
+}
+
+// ClaimScheduleRun moves a schedule from the run due at dueAt to nextRun.
+// only one caller can win the claim for a given due time, so a run is never
+// fired twice even across restarts.
+func ClaimScheduleRun(scheduleID uint64, dueAt, nextRun int64) (bool, error) {
+	result, err := db.Exec(
+		"UPDATE schedules SET next_run = ?, last_run = ?, updated_at = ? WHERE id = ? AND next_run = ? AND enabled = 1",
+		nextRun, dueAt, now(), scheduleID, dueAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// InsertScheduleRun records a run and sets r.ID
+func InsertScheduleRun(r *ScheduleRun) error {
+	r.Created_at = now()
+	result, err := db.Exec(`INSERT INTO schedule_runs (schedule_id, user_id, due_at, order_id, outcome, reason, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		r.Schedule_id, r.User_id, r.Due_at, r.Order_id, r.Outcome, r.Reason, r.Created_at,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	r.ID = uint64(id)
+	return nil
+}
+
+// FinishScheduleRun stores the outcome of a run
+func FinishScheduleRun(r *ScheduleRun) error {
+	_, err := db.Exec("UPDATE schedule_runs SET order_id = ?, outcome = ?, reason = ? WHERE id = ?",
+		r.Order_id, r.Outcome, r.Reason, r.ID)
+	return err
+}
+
+// ListScheduleRuns returns the latest runs of a schedule, newest first
+func ListScheduleRuns(scheduleID uint64, limit int) ([]ScheduleRun, error) {
+	rows, err := db.Query(`SELECT id, schedule_id, user_id, due_at, order_id, outcome, reason, created_at
+        FROM schedule_runs WHERE schedule_id = ? ORDER BY id DESC LIMIT ?`, scheduleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []ScheduleRun
+	for rows.Next() {
+		var r ScheduleRun
+		err := rows.Scan(&r.ID, &r.Schedule_id, &r.User_id, &r.Due_at, &r.Order_id, &r.Outcome, &r.Reason, &r.Created_at)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,259 @@
+package db
+
+import (
+	"database/sql"
+	"jotacomputing/go-api/structs"
+)
+
+// stop_orders.status
+const (
+	StopArmed     = 0
+	StopTriggered = 1
+	StopCancelled = 2
+	StopRejected  = 3 // triggered but refused by the pre-trade checks
+	StopExpired   = 4 // GTD or DAY stop that lapsed before it triggered
+)
+
+const stopOrdersSchema = `
+    CREATE TABLE IF NOT EXISTS stop_orders (
+        user_id INTEGER NOT NULL,
+        order_id INTEGER NOT NULL,
+        symbol INTEGER NOT NULL,
+        side INTEGER NOT NULL,
+        order_type INTEGER NOT NULL,
+        price INTEGER NOT NULL,
+        stop_price INTEGER NOT NULL,
+        shares_qty INTEGER NOT NULL,
+        timestamp INTEGER NOT NULL,
+        time_in_force INTEGER NOT NULL DEFAULT 0,
+        expire_at INTEGER NOT NULL DEFAULT 0,
+        status INTEGER NOT NULL DEFAULT 0,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (user_id, order_id)
+    )
+`
+
+const stopOrderColumns = "user_id, order_id, symbol, side, order_type, price, stop_price, shares_qty, timestamp, time_in_force, expire_at, stp_mode, stp_group, display_qty"
+
+func scanStopOrder(row interface{ Scan(...interface{}) error }) (*structs.StopOrder, error) {
+	stop := &structs.StopOrder{}
+	o := &stop.Order
+	err := row.Scan(&o.User_id, &o.Order_id, &o.Symbol, &o.Side, &o.Order_type,
+		&o.Price, &stop.Stop_price, &o.Shares_qty, &o.Timestamp, &o.Time_in_force, &o.Expire_at, &o.Stp_mode, &o.Stp_group, &o.Display_qty)
+	if err != nil {
+		return nil, err
+	}
+	return stop, nil
+}
+
+// CreateStopOrder stores a newly armed stop order. its row in orders is
+// written along with it, pending with the stop order type, so the order id is
+// taken right away. fails with ErrDuplicateOrder if the user already used it.
+func CreateStopOrder(stop structs.StopOrder) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	o := stop.Order
+	if err := insertOrder(tx, o); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO stop_orders ("+stopOrderColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		o.User_id, o.Order_id, o.Symbol, o.Side, o.Order_type, o.Price, stop.Stop_price, o.Shares_qty, o.Timestamp,
+		o.Time_in_force, o.Expire_at, o.Stp_mode, o.Stp_group, o.Display_qty,
+	)
+	if isDuplicate(err) {
+		return ErrDuplicateOrder
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListArmedStopOrders returns the armed stops of one user
+func ListArmedStopOrders(userID uint64) ([]structs.StopOrder, error) {
+	rows, err := db.Query(
+		"SELECT "+stopOrderColumns+" FROM stop_orders WHERE user_id = ? AND status = ? ORDER BY created_at",
+		userID, StopArmed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return collectStopOrders(rows)
+}
+
+// LoadArmedStopOrders returns every armed stop, used to rebuild the book on startup
+func LoadArmedStopOrders() ([]structs.StopOrder, error) {
+	rows, err := db.Query(
+		"SELECT "+stopOrderColumns+" FROM stop_orders WHERE status = ?",
+		StopArmed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return collectStopOrders(rows)
+}
+
+func collectStopOrders(rows *sql.Rows) ([]structs.StopOrder, error) {
+	defer rows.Close()
+	var stops []structs.StopOrder
+	for rows.Next() {
+		stop, err := scanStopOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		stops = append(stops, *stop)
+	}
+	return stops, rows.Err()
+}
+
+// SetStopOrderQty resizes an armed stop and its order row, sql.ErrNoRows if
+// it is no longer armed
+func SetStopOrderQty(userID, orderID uint64, qty, displayQty uint32) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE stop_orders SET shares_qty = ?, display_qty = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ? AND order_id = ? AND status = ?",
+		qty, displayQty, userID, orderID, StopArmed,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	_, err = tx.Exec("UPDATE orders SET shares_qty = ?, leaves_qty = ?, display_qty = ?, updated_at = ? WHERE user_id = ? AND order_id = ?",
+		qty, qty, displayQty, now(), userID, orderID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetStopOrderStatus takes an armed stop out of the book as cancelled or
+// expired, its order row is closed with it. returns sql.ErrNoRows if the
+// stop doesn't exist or is no longer armed.
+func SetStopOrderStatus(userID, orderID uint64, status int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := moveStopOrder(tx, userID, orderID, StopArmed, status); err != nil {
+		return err
+	}
+	reason := "stop cancelled"
+	if status == StopExpired {
+		reason = "stop expired"
+	}
+	_, err = tx.Exec("UPDATE orders SET status = ?, reason = ?, updated_at = ? WHERE user_id = ? AND order_id = ?",
+		structs.StatusCancelled, reason, now(), userID, orderID)
+	if err != nil {
+		return err
+	}
+	err = addOrderEvent(tx, OrderEvent{
+		User_id: userID, Order_id: orderID, Event: EventStatus, Status: structs.StatusCancelled, Reason: reason,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TriggerStopOrder marks an armed stop triggered and turns its order row into
+// the released order, which is then sent like any other. stops armed before
+// they had an order row get one now. returns sql.ErrNoRows if the stop is no
+// longer armed.
+func TriggerStopOrder(o structs.Order) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := moveStopOrder(tx, o.User_id, o.Order_id, StopArmed, StopTriggered); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`UPDATE orders SET order_type = ?, price = ?, shares_qty = ?, leaves_qty = ?, display_qty = ?, expire_at = ?,
+            stp_group = ?, timestamp = ?, status = ?, reason = '', updated_at = ? WHERE user_id = ? AND order_id = ?`,
+		o.Order_type, o.Price, o.Shares_qty, o.Shares_qty, o.Display_qty, o.Expire_at,
+		o.Stp_group, o.Timestamp, o.Status, now(), o.User_id, o.Order_id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		if err := insertOrder(tx, o); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RearmStopOrder puts a triggered stop that never reached the engine back in
+// the armed state, its order row goes back to the stop so it isn't taken for
+// a working order
+func RearmStopOrder(stop structs.StopOrder) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	o := stop.Order
+	if err := moveStopOrder(tx, o.User_id, o.Order_id, StopTriggered, StopArmed); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE orders SET order_type = ?, price = ?, shares_qty = ?, leaves_qty = ?, display_qty = ?, expire_at = ?,
+            stp_group = ?, timestamp = ?, status = ?, reason = '', updated_at = ? WHERE user_id = ? AND order_id = ?`,
+		o.Order_type, o.Price, o.Shares_qty, o.Shares_qty, o.Display_qty, o.Expire_at,
+		o.Stp_group, o.Timestamp, structs.StatusPending, now(), o.User_id, o.Order_id,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RejectTriggeredStopOrder records that the order released by a stop was
+// refused by the pre-trade checks
+func RejectTriggeredStopOrder(userID, orderID uint64) error {
+	return moveStopOrder(db, userID, orderID, StopTriggered, StopRejected)
+}
+
+// moveStopOrder changes the status of a stop, sql.ErrNoRows if it is not in from
+func moveStopOrder(q interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, userID, orderID uint64, from, to int) error {
+	result, err := q.Exec(
+		"UPDATE stop_orders SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ? AND order_id = ? AND status = ?",
+		to, userID, orderID, from,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/symbols"
+)
+
+const symbolsSchema = `
+    CREATE TABLE IF NOT EXISTS symbols (
+        id INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        price_scale INTEGER NOT NULL DEFAULT 2,
+        qty_scale INTEGER NOT NULL DEFAULT 0
+    )
+`
+
+// symbols without a group follow this group's trading calendar
+const DefaultSymbolGroup = "default"
+
+// migrateSymbols adds the columns that came after the table
+func migrateSymbols() error {
+	if _, err := addColumnIfMissing("symbols", "grp", "TEXT NOT NULL DEFAULT '"+DefaultSymbolGroup+"'"); err != nil {
+		return err
+	}
+	_, err := addColumnIfMissing("symbols", "lot_size", "INTEGER NOT NULL DEFAULT 1")
+	return err
+}
+
+var (
+	ErrInvalidSymbol = errors.New("invalid symbol spec")
+	// prices and quantities are stored in ticks, a new scale would reprice
+	// every order already on record
+	ErrScaleInUse = errors.New("symbol already has orders, its scales can't change")
+)
+
+// checkSpec fills in the defaults of a spec and refuses one the rest of the
+// gateway can't work with
+func checkSpec(spec *symbols.Spec) error {
+	if spec.PriceScale > decimal.MaxScale || spec.QtyScale > decimal.MaxScale {
+		return fmt.Errorf("%w: scales must be at most %d", ErrInvalidSymbol, decimal.MaxScale)
+	}
+	if spec.Group == "" {
+		spec.Group = DefaultSymbolGroup
+	}
+	if spec.LotSize == 0 {
+		spec.LotSize = 1
+	}
+	return nil
+}
+
+// LoadSymbols pushes every row of the symbols table into the symbols registry
+func LoadSymbols() error {
+	rows, err := db.Query("SELECT id, name, price_scale, qty_scale, grp, lot_size FROM symbols")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var spec symbols.Spec
+		if err := rows.Scan(&spec.ID, &spec.Name, &spec.PriceScale, &spec.QtyScale, &spec.Group, &spec.LotSize); err != nil {
+			return err
+		}
+		if err := checkSpec(&spec); err != nil {
+			return fmt.Errorf("symbol %d: %w", spec.ID, err)
+		}
+		symbols.Register(spec)
+	}
+	return rows.Err()
+}
+
+// UpsertSymbol stores a symbol spec and registers it right away. the scales
+// of a symbol that already has orders are fixed, ErrScaleInUse otherwise.
+func UpsertSymbol(spec symbols.Spec) error {
+	if err := checkSpec(&spec); err != nil {
+		return err
+	}
+	current := symbols.Lookup(spec.ID)
+	if spec.PriceScale != current.PriceScale || spec.QtyScale != current.QtyScale {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM orders WHERE symbol = ?", spec.ID).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrScaleInUse
+		}
+	}
+	_, err := db.Exec(`
+        INSERT INTO symbols (id, name, price_scale, qty_scale, grp, lot_size) VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(id) DO UPDATE SET name = excluded.name,
+            price_scale = excluded.price_scale, qty_scale = excluded.qty_scale, grp = excluded.grp,
+            lot_size = excluded.lot_size`,
+		spec.ID, spec.Name, spec.PriceScale, spec.QtyScale, spec.Group, spec.LotSize,
+	)
+	if err != nil {
+		return err
+	}
+	symbols.Register(spec)
+	return nil
+}
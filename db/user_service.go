@@ -2,19 +2,31 @@ package db
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"jotacomputing/go-api/decimal"
 	"jotacomputing/go-api/queue"
 	"jotacomputing/go-api/structs"
 	"log"
 
-	_ "github.com/mattn/go-sqlite3" // SQLite driver
+	"github.com/mattn/go-sqlite3" // SQLite driver
 )
 
 type User struct {
-	ID       uint64  `json:"id"`
-	Username string  `json:"username"`
-	Balance  float64 `json:"balance"`
+	ID       uint64         `json:"id"`
+	Username string         `json:"username"`
+	Balance  decimal.Amount `json:"balance"` // minor units at decimal.CashScale
+	Role     string         `json:"role"`
 }
 
+// users.role
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+var ErrUsernameTaken = errors.New("username already taken")
+
 var db *sql.DB
 
 func InitDB() {
@@ -36,15 +48,100 @@ func InitDB() {
 	if err != nil {
 		panic(err)
 	}
+
+	// balances used to be a REAL column, keep them as integer minor units instead
+	added, err := addColumnIfMissing("users", "balance_minor", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		panic(err)
+	}
+	if added {
+		// one off carry over of the old float balances, rounded to the cash scale
+		_, err = db.Exec(fmt.Sprintf(
+			"UPDATE users SET balance_minor = CAST(ROUND(balance * %d) AS INTEGER)",
+			decimal.Pow10(decimal.CashScale),
+		))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if _, err = addColumnIfMissing("users", "role", "TEXT NOT NULL DEFAULT '"+RoleUser+"'"); err != nil {
+		panic(err)
+	}
+	if _, err = addColumnIfMissing("users", "tier", "TEXT NOT NULL DEFAULT '"+DefaultTier+"'"); err != nil {
+		panic(err)
+	}
+	// accounts of one firm share an stp group, 0 means the account is its own group
+	if _, err = addColumnIfMissing("users", "stp_group", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		panic(err)
+	}
+	// accounts allowed to sell beyond their holdings
+	if _, err = addColumnIfMissing("users", "can_short", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		panic(err)
+	}
+	// margin accounts may borrow against their positions, see margin.Enabled
+	if _, err = addColumnIfMissing("users", "margin_enabled", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		panic(err)
+	}
+
+	// bcrypt hash, empty for accounts created before passwords were checked.
+	// tokens issued before password_changed_at (unix nanos) are refused.
+	if _, err = addColumnIfMissing("users", "password_hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		panic(err)
+	}
+	if _, err = addColumnIfMissing("users", "password_changed_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		panic(err)
+	}
+
+	for _, schema := range []string{
+		symbolsSchema, stopOrdersSchema, riskLimitsSchema,
+		reservationsSchema, ledgerEntriesSchema,
+		rateTiersSchema, rateTiersSeed,
+		haltsSchema, haltAuditSchema,
+		calendarGroupsSchema, tradingSessionsSchema, holidaysSchema, calendarSeed,
+		ordersSchema, orderEventsSchema, executionsSchema,
+		orderGroupsSchema, orderGroupLegsSchema,
+		algoOrdersSchema, algoChildrenSchema,
+		schedulesSchema, scheduleRunsSchema,
+		marginRatesSchema, marginRatesSeed, marginCallsSchema,
+		feeSchedulesSchema, feeSchedulesSeed,
+		oauthClientsSchema,
+	} {
+		if _, err = db.Exec(schema); err != nil {
+			panic(err)
+		}
+	}
+	for _, table := range []string{"orders", "stop_orders"} {
+		for _, col := range []string{"stp_mode", "stp_group", "display_qty"} {
+			if _, err = addColumnIfMissing(table, col, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				panic(err)
+			}
+		}
+	}
+	if _, err = addColumnIfMissing("executions", "fee_minor", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		panic(err)
+	}
+	if _, err = addColumnIfMissing("orders", "short_sale", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		panic(err)
+	}
+	if err = migrateOrders(); err != nil {
+		panic(err)
+	}
+	if err = migrateSymbols(); err != nil {
+		panic(err)
+	}
+	if err = LoadSymbols(); err != nil {
+		panic(err)
+	}
 }
 
 // 1. FIND EXISTING USER BY USERNAME
 func FindUserByUsername(username string) (*User, error) {
 	user := &User{}
 	err := db.QueryRow(
-		"SELECT id, username, balance FROM users WHERE username = ?",
+		"SELECT id, username, balance_minor, role FROM users WHERE username = ?",
 		username,
-	).Scan(&user.ID, &user.Username, &user.Balance)
+	).Scan(&user.ID, &user.Username, &user.Balance, &user.Role)
 
 	if err != nil {
 		return nil, err // Returns sql.ErrNoRows if not found
@@ -56,9 +153,9 @@ func FindUserByUsername(username string) (*User, error) {
 func FindUserByID(id int64) (*User, error) {
 	user := &User{}
 	err := db.QueryRow(
-		"SELECT id, username, balance FROM users WHERE id = ?",
+		"SELECT id, username, balance_minor, role FROM users WHERE id = ?",
 		id,
-	).Scan(&user.ID, &user.Username, &user.Balance)
+	).Scan(&user.ID, &user.Username, &user.Balance, &user.Role)
 
 	if err != nil {
 		return nil, err
@@ -67,11 +164,34 @@ func FindUserByID(id int64) (*User, error) {
 }
 
 // 3. CREATE NEW USER (auto-generates ID)
-func CreateUser(username string, balance float64) (*User, error) {
+func CreateUser(username string, balance decimal.Amount) (*User, error) {
+	return createUser(username, "", balance)
+}
+
+// RegisterUser creates an account with a password, failing with
+// ErrUsernameTaken if the username is in use
+func RegisterUser(username, passwordHash string) (*User, error) {
+	return createUser(username, passwordHash, 0)
+}
+
+// isDuplicate reports whether err is a unique or primary key violation
+func isDuplicate(err error) bool {
+	var e sqlite3.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.ExtendedCode == sqlite3.ErrConstraintUnique || e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
+
+func createUser(username, passwordHash string, balance decimal.Amount) (*User, error) {
+	ts := now()
 	result, err := db.Exec(
-		"INSERT INTO users (username, balance) VALUES (?, ?)",
-		username, balance,
+		"INSERT INTO users (username, balance_minor, password_hash, password_changed_at) VALUES (?, ?, ?, ?)",
+		username, balance, passwordHash, ts,
 	)
+	if isDuplicate(err) {
+		return nil, ErrUsernameTaken
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -91,3 +211,114 @@ func CreateUser(username string, balance float64) (*User, error) {
 	}
 	return FindUserByID(id) // Fetch complete user record
 }
+
+// UserStpGroup returns the self-trade prevention group of an account, which
+// is the account's own id unless it was put in a firm wide group
+func UserStpGroup(userID uint64) (uint64, error) {
+	var group uint64
+	err := db.QueryRow("SELECT stp_group FROM users WHERE id = ?", userID).Scan(&group)
+	if err != nil {
+		return 0, err
+	}
+	if group == 0 {
+		group = userID
+	}
+	return group, nil
+}
+
+// SetUserStpGroup puts an account in a self-trade prevention group, 0 puts it
+// back on its own. false if there is no such user
+func SetUserStpGroup(userID, group uint64) (bool, error) {
+	result, err := db.Exec("UPDATE users SET stp_group = ? WHERE id = ?", group, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// PromoteUser gives the account with username the admin role, false if there
+// is no such user
+func PromoteUser(username string) (bool, error) {
+	result, err := db.Exec("UPDATE users SET role = ? WHERE username = ?", RoleAdmin, username)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// UserCanShort reports whether the account may sell more than it holds
+func UserCanShort(userID uint64) (bool, error) {
+	var canShort bool
+	err := db.QueryRow("SELECT can_short FROM users WHERE id = ?", userID).Scan(&canShort)
+	return canShort, err
+}
+
+// SetUserCanShort allows or stops short selling on an account, false if there
+// is no such user
+func SetUserCanShort(userID uint64, canShort bool) (bool, error) {
+	result, err := db.Exec("UPDATE users SET can_short = ? WHERE id = ?", canShort, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ListUserIDs returns the id of every account
+func ListUserIDs() ([]uint64, error) {
+	rows, err := db.Query("SELECT id FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// UserCredentials returns the id and password hash of a username
+func UserCredentials(username string) (uint64, string, error) {
+	var (
+		id   uint64
+		hash string
+	)
+	err := db.QueryRow("SELECT id, password_hash FROM users WHERE username = ?", username).Scan(&id, &hash)
+	return id, hash, err
+}
+
+// UserPasswordHash returns the password hash of an account
+func UserPasswordHash(userID uint64) (string, error) {
+	var hash string
+	err := db.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&hash)
+	return hash, err
+}
+
+// PasswordChangedAt is when the account's password was last set, unix nanos
+func PasswordChangedAt(userID uint64) (int64, error) {
+	var ts int64
+	err := db.QueryRow("SELECT password_changed_at FROM users WHERE id = ?", userID).Scan(&ts)
+	return ts, err
+}
+
+// SetPasswordHash replaces the password of an account and returns the new
+// password_changed_at, false if there is no such user
+func SetPasswordHash(userID uint64, hash string) (int64, bool, error) {
+	ts := now()
+	result, err := db.Exec(
+		"UPDATE users SET password_hash = ?, password_changed_at = ? WHERE id = ?", hash, ts, userID,
+	)
+	if err != nil {
+		return 0, false, err
+	}
+	n, err := result.RowsAffected()
+	return ts, n > 0, err
+}
@@ -0,0 +1,73 @@
+package decimal
+
+import (
+	"encoding/json"
+	"math"
+	"math/bits"
+)
+
+const MaxAmount = Amount(math.MaxInt64)
+
+// Amount is a cash amount in minor units at CashScale (cents at scale 2).
+// it goes over JSON as a decimal string so clients never see float rounding.
+type Amount int64
+
+// ParseAmount parses a non-negative decimal string into an Amount
+func ParseAmount(s string) (Amount, error) {
+	n, err := Parse(s, CashScale)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(MaxAmount) {
+		return 0, ErrRange
+	}
+	return Amount(n), nil
+}
+
+func (a Amount) String() string {
+	return FormatSigned(int64(a), CashScale)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	var v Value
+	if err := v.UnmarshalJSON(b); err != nil {
+		return err
+	}
+	s := string(v)
+	neg := len(s) > 0 && s[0] == '-'
+	if neg {
+		s = s[1:]
+	}
+	n, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	if neg {
+		n = -n
+	}
+	*a = n
+	return nil
+}
+
+// OfBps is rate basis points of a, rounded up so a fee or requirement is never
+// short by a minor unit. negative amounts give 0.
+func OfBps(a Amount, rate uint64) Amount {
+	if a <= 0 {
+		return 0
+	}
+	hi, lo := bits.Mul64(uint64(a), rate)
+	lo, carry := bits.Add64(lo, 9999, 0)
+	hi += carry
+	if hi >= 10000 {
+		return MaxAmount
+	}
+	q, _ := bits.Div64(hi, lo, 10000)
+	if q > uint64(MaxAmount) {
+		return MaxAmount
+	}
+	return Amount(q)
+}
@@ -0,0 +1,27 @@
+package decimal
+
+import "testing"
+
+func TestOfBps(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount Amount
+		rate   uint64
+		want   Amount
+	}{
+		{"exact", 10000, 25, 25},
+		{"rounded up", 12345, 10, 13}, // 12.345
+		{"under a minor unit", 1, 1, 1},
+		{"zero rate", 12345, 0, 0},
+		{"zero amount", 0, 25, 0},
+		{"negative amount", -5000, 25, 0},
+		{"full rate", MaxAmount, 10000, MaxAmount},
+		{"above the range", MaxAmount, 20000, MaxAmount},
+		{"product above 64 bits", MaxAmount / 2, 15000, MaxAmount/2 + MaxAmount/4 + 1},
+	}
+	for _, tt := range tests {
+		if got := OfBps(tt.amount, tt.rate); got != tt.want {
+			t.Errorf("%s: OfBps(%d, %d) = %d, want %d", tt.name, tt.amount, tt.rate, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package decimal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// CashScale is the number of decimal places account balances are kept in.
+// Balances are stored and moved around as integer minor units at this scale.
+const CashScale uint8 = 2
+
+// MaxScale is the largest scale we accept for a symbol (10^18 still fits a uint64)
+const MaxScale uint8 = 18
+
+var (
+	ErrEmpty     = errors.New("empty decimal")
+	ErrSyntax    = errors.New("invalid decimal syntax")
+	ErrNegative  = errors.New("negative values are not allowed")
+	ErrPrecision = errors.New("too many decimal places")
+	ErrRange     = errors.New("value out of range")
+)
+
+// Value is a decimal number exactly as it came over the wire ("123.45").
+// it is never turned into a float, only into integer ticks for a given scale.
+type Value string
+
+// UnmarshalJSON accepts both "123.45" and a bare 123.45 literal.
+// the literal text is kept as is, so no float conversion happens here either.
+func (v *Value) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if bytes.Equal(b, []byte("null")) {
+		*v = ""
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		s, err := strconv.Unquote(string(b))
+		if err != nil {
+			return fmt.Errorf("decimal: %w", ErrSyntax)
+		}
+		*v = Value(strings.TrimSpace(s))
+		return nil
+	}
+	*v = Value(b)
+	return nil
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(string(v))), nil
+}
+
+// IsZero reports whether the value was left out of the request
+func (v Value) IsZero() bool {
+	return v == ""
+}
+
+// Ticks converts the value into an integer count of 10^-scale units.
+// inputs with more decimal places than scale are rejected, not rounded.
+func (v Value) Ticks(scale uint8) (uint64, error) {
+	return Parse(string(v), scale)
+}
+
+// Parse converts a decimal string into integer ticks at the given scale
+func Parse(s string, scale uint8) (uint64, error) {
+	if scale > MaxScale {
+		return 0, fmt.Errorf("decimal: scale %d above max %d", scale, MaxScale)
+	}
+	if s == "" {
+		return 0, ErrEmpty
+	}
+	if s[0] == '-' {
+		return 0, ErrNegative
+	}
+	if s[0] == '+' {
+		s = s[1:]
+	}
+
+	intPart, fracPart, hasDot := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return 0, ErrSyntax
+	}
+	if hasDot && fracPart == "" {
+		return 0, ErrSyntax
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, ErrSyntax
+	}
+
+	// trailing zeros don't carry precision, "1.500" is fine at scale 1
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > int(scale) {
+		return 0, ErrPrecision
+	}
+
+	digits := strings.TrimLeft(intPart, "0") + fracPart + strings.Repeat("0", int(scale)-len(fracPart))
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return 0, ErrRange
+	}
+	return n, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Format renders integer ticks at the given scale back into a decimal string
+func Format(ticks uint64, scale uint8) string {
+	s := strconv.FormatUint(ticks, 10)
+	if scale == 0 {
+		return s
+	}
+	if len(s) <= int(scale) {
+		s = strings.Repeat("0", int(scale)-len(s)+1) + s
+	}
+	cut := len(s) - int(scale)
+	return s[:cut] + "." + s[cut:]
+}
+
+// FormatSigned is Format for values that can go below zero (balances, pnl)
+func FormatSigned(ticks int64, scale uint8) string {
+	if ticks < 0 {
+		// go through uint64 so math.MinInt64 doesn't overflow on negation
+		return "-" + Format(uint64(-(ticks+1))+1, scale)
+	}
+	return Format(uint64(ticks), scale)
+}
+
+// Pow10 returns 10^n, n must be <= MaxScale
+func Pow10(n uint8) uint64 {
+	p := uint64(1)
+	for i := uint8(0); i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// Notional computes price * qty expressed in cash minor units.
+// price is in ticks of priceScale, qty in ticks of qtyScale. the result is
+// rounded up when roundUp is set (reservations) and down otherwise.
+func Notional(price uint64, priceScale uint8, qty uint64, qtyScale uint8, roundUp bool) (Amount, error) {
+	hi, lo := bits.Mul64(price, qty)
+
+	shift := int(priceScale) + int(qtyScale) - int(CashScale)
+	if shift <= 0 {
+		if hi != 0 {
+			return 0, ErrRange
+		}
+		mul := Pow10(uint8(-shift))
+		h, l := bits.Mul64(lo, mul)
+		if h != 0 || l > uint64(MaxAmount) {
+			return 0, ErrRange
+		}
+		return Amount(l), nil
+	}
+
+	// shift can be up to 2*MaxScale, go through big.Int rather than chaining Div64
+	n := new(big.Int).SetUint64(hi)
+	n.Lsh(n, 64).Or(n, new(big.Int).SetUint64(lo))
+	d := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(shift)), nil)
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if roundUp && r.Sign() != 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if !q.IsInt64() {
+		return 0, ErrRange
+	}
+	return Amount(q.Int64()), nil
+}
+
+// QtyFor is the inverse of Notional: how many qty ticks amount buys at price,
+// rounded down
+func QtyFor(amount Amount, price uint64, priceScale uint8, qtyScale uint8) (uint64, error) {
+	if amount < 0 || price == 0 {
+		return 0, ErrRange
+	}
+	ten := big.NewInt(10)
+	n := new(big.Int).Mul(big.NewInt(int64(amount)), new(big.Int).Exp(ten, big.NewInt(int64(priceScale)+int64(qtyScale)), nil))
+	d := new(big.Int).Mul(new(big.Int).SetUint64(price), new(big.Int).Exp(ten, big.NewInt(int64(CashScale)), nil))
+	q := n.Quo(n, d)
+	if !q.IsUint64() {
+		return 0, ErrRange
+	}
+	return q.Uint64(), nil
+}
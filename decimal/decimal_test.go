@@ -0,0 +1,169 @@
+package decimal
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale uint8
+		want  uint64
+		err   error
+	}{
+		{"123.45", 2, 12345, nil},
+		{"123.4", 2, 12340, nil},
+		{"123", 2, 12300, nil},
+		{"+7", 0, 7, nil},
+		{".5", 1, 5, nil},
+		{"0.00", 2, 0, nil},
+		{"007.10", 2, 710, nil},
+		{"1.500", 1, 15, nil}, // trailing zeros carry no precision
+		{"18446744073709551615", 0, math.MaxUint64, nil},
+		{"1.55", 1, 0, ErrPrecision},
+		{"", 2, 0, ErrEmpty},
+		{"-1", 2, 0, ErrNegative},
+		{"1.", 2, 0, ErrSyntax},
+		{".", 2, 0, ErrSyntax},
+		{"1e3", 2, 0, ErrSyntax},
+		{"1,5", 2, 0, ErrSyntax},
+		{"18446744073709551616", 0, 0, ErrRange},
+		{"184467440737095516.16", 2, 0, ErrRange},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in, tt.scale)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Parse(%q, %d) = %d, %v, want %d, %v", tt.in, tt.scale, got, err, tt.want, tt.err)
+		}
+	}
+
+	if _, err := Parse("1", MaxScale+1); err == nil {
+		t.Errorf("Parse above MaxScale: want an error")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		ticks uint64
+		scale uint8
+		want  string
+	}{
+		{12345, 2, "123.45"},
+		{100, 2, "1.00"},
+		{5, 2, "0.05"},
+		{0, 2, "0.00"},
+		{7, 0, "7"},
+		{math.MaxUint64, 18, "18.446744073709551615"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.ticks, tt.scale); got != tt.want {
+			t.Errorf("Format(%d, %d) = %q, want %q", tt.ticks, tt.scale, got, tt.want)
+		}
+		// what Format writes Parse reads back
+		if back, err := Parse(tt.want, tt.scale); err != nil || back != tt.ticks {
+			t.Errorf("Parse(Format(%d, %d)) = %d, %v", tt.ticks, tt.scale, back, err)
+		}
+	}
+}
+
+func TestFormatSigned(t *testing.T) {
+	tests := []struct {
+		ticks int64
+		scale uint8
+		want  string
+	}{
+		{-5, 2, "-0.05"},
+		{-12345, 2, "-123.45"},
+		{0, 2, "0.00"},
+		{42, 1, "4.2"},
+		{math.MinInt64, 0, "-9223372036854775808"},
+	}
+	for _, tt := range tests {
+		if got := FormatSigned(tt.ticks, tt.scale); got != tt.want {
+			t.Errorf("FormatSigned(%d, %d) = %q, want %q", tt.ticks, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestNotional(t *testing.T) {
+	tests := []struct {
+		name       string
+		price      uint64
+		priceScale uint8
+		qty        uint64
+		qtyScale   uint8
+		down, up   Amount
+		err        error
+	}{
+		{"exact", 12345, 2, 3, 0, 37035, 37035, nil},
+		{"rounded", 12345, 2, 15, 1, 18517, 18518, nil}, // 123.45 x 1.5 = 185.175
+		{"below a cent", 1, 4, 1, 0, 0, 1, nil},
+		{"scaled up", 5, 0, 2, 0, 1000, 1000, nil},
+		{"product overflows", math.MaxUint64, 0, 2, 0, 0, 0, ErrRange},
+		{"amount overflows", math.MaxUint64, 4, math.MaxUint64, 4, 0, 0, ErrRange},
+	}
+	for _, tt := range tests {
+		down, err := Notional(tt.price, tt.priceScale, tt.qty, tt.qtyScale, false)
+		if !errors.Is(err, tt.err) || down != tt.down {
+			t.Errorf("%s: Notional rounded down = %d, %v, want %d, %v", tt.name, down, err, tt.down, tt.err)
+		}
+		up, err := Notional(tt.price, tt.priceScale, tt.qty, tt.qtyScale, true)
+		if !errors.Is(err, tt.err) || up != tt.up {
+			t.Errorf("%s: Notional rounded up = %d, %v, want %d, %v", tt.name, up, err, tt.up, tt.err)
+		}
+	}
+}
+
+func TestQtyFor(t *testing.T) {
+	tests := []struct {
+		amount     Amount
+		price      uint64
+		priceScale uint8
+		qtyScale   uint8
+		want       uint64
+		err        error
+	}{
+		{37035, 12345, 2, 0, 3, nil},
+		{37034, 12345, 2, 0, 2, nil}, // rounded down
+		{18518, 12345, 2, 1, 15, nil},
+		{100, 1, 4, 0, 10000, nil},
+		{0, 12345, 2, 0, 0, nil},
+		{100, 0, 2, 0, 0, ErrRange},
+		{-1, 12345, 2, 0, 0, ErrRange},
+		{MaxAmount, 1, 18, 18, 0, ErrRange},
+	}
+	for _, tt := range tests {
+		got, err := QtyFor(tt.amount, tt.price, tt.priceScale, tt.qtyScale)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("QtyFor(%d, %d, %d, %d) = %d, %v, want %d, %v",
+				tt.amount, tt.price, tt.priceScale, tt.qtyScale, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+		err  error
+	}{
+		{`"12.34"`, 1234, nil},
+		{`12.3`, 1230, nil},
+		{`"-0.05"`, -5, nil},
+		{`"1.234"`, 0, ErrPrecision},
+		{`"abc"`, 0, ErrSyntax},
+	}
+	for _, tt := range tests {
+		var a Amount
+		err := a.UnmarshalJSON([]byte(tt.in))
+		if !errors.Is(err, tt.err) || a != tt.want {
+			t.Errorf("UnmarshalJSON(%s) = %d, %v, want %d, %v", tt.in, a, err, tt.want, tt.err)
+		}
+	}
+
+	if b, _ := Amount(-1234).MarshalJSON(); string(b) != `"-12.34"` {
+		t.Errorf("MarshalJSON(-1234) = %s", b)
+	}
+}
@@ -0,0 +1,51 @@
+package feeds
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/structs"
+)
+
+var (
+	fillMu       sync.RWMutex
+	fillHandlers []func(structs.Fill)
+)
+
+// OnFill registers fn to be called for every execution on the fills ring.
+// handlers run on the consumer goroutine so they must not block.
+func OnFill(fn func(structs.Fill)) {
+	fillMu.Lock()
+	fillHandlers = append(fillHandlers, fn)
+	fillMu.Unlock()
+}
+
+// StartFillFeed drains the fills ring in the background
+func StartFillFeed(q *queue.FillQueue) {
+	go func() {
+		for {
+			fill, err := q.Dequeue()
+			if err != nil {
+				log.Printf("fill feed: %v", err)
+				continue
+			}
+			if fill == nil {
+				time.Sleep(idleBackoff)
+				continue
+			}
+			dispatchFill(*fill)
+		}
+	}()
+}
+
+func dispatchFill(fill structs.Fill) {
+	fillMu.RLock()
+	handlers := fillHandlers
+	fillMu.RUnlock()
+
+	for _, fn := range handlers {
+		fn(fill)
+	}
+}
@@ -0,0 +1,51 @@
+package feeds
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/structs"
+)
+
+var (
+	responseMu       sync.RWMutex
+	responseHandlers []func(structs.QueryResponse)
+)
+
+// OnQueryResponse registers fn to be called for every answer on the query
+// response ring. handlers run on the consumer goroutine so they must not block.
+func OnQueryResponse(fn func(structs.QueryResponse)) {
+	responseMu.Lock()
+	responseHandlers = append(responseHandlers, fn)
+	responseMu.Unlock()
+}
+
+// StartQueryResponseFeed drains the query response ring in the background
+func StartQueryResponseFeed(q *queue.QueryResponseQueue) {
+	go func() {
+		for {
+			response, err := q.Dequeue()
+			if err != nil {
+				log.Printf("query response feed: %v", err)
+				continue
+			}
+			if response == nil {
+				time.Sleep(idleBackoff)
+				continue
+			}
+			dispatchQueryResponse(*response)
+		}
+	}()
+}
+
+func dispatchQueryResponse(response structs.QueryResponse) {
+	responseMu.RLock()
+	handlers := responseHandlers
+	responseMu.RUnlock()
+
+	for _, fn := range handlers {
+		fn(response)
+	}
+}
@@ -0,0 +1,51 @@
+package feeds
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/structs"
+)
+
+var (
+	statusMu       sync.RWMutex
+	statusHandlers []func(structs.Order)
+)
+
+// OnStatus registers fn to be called for every order status update from the
+// engine. handlers run on the consumer goroutine so they must not block.
+func OnStatus(fn func(structs.Order)) {
+	statusMu.Lock()
+	statusHandlers = append(statusHandlers, fn)
+	statusMu.Unlock()
+}
+
+// StartStatusFeed drains the order status ring in the background
+func StartStatusFeed(q *queue.Queue) {
+	go func() {
+		for {
+			update, err := q.Dequeue()
+			if err != nil {
+				log.Printf("status feed: %v", err)
+				continue
+			}
+			if update == nil {
+				time.Sleep(idleBackoff)
+				continue
+			}
+			dispatchStatus(*update)
+		}
+	}()
+}
+
+func dispatchStatus(update structs.Order) {
+	statusMu.RLock()
+	handlers := statusHandlers
+	statusMu.RUnlock()
+
+	for _, fn := range handlers {
+		fn(update)
+	}
+}
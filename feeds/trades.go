@@ -0,0 +1,64 @@
+package feeds
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/structs"
+)
+
+// how long the consumer sleeps when a ring is empty
+const idleBackoff = 200 * time.Microsecond
+
+var (
+	tradeMu       sync.RWMutex
+	tradeHandlers []func(structs.Trade)
+	lastPrices    = make(map[uint32]uint64)
+)
+
+// OnTrade registers fn to be called for every print on the trade feed.
+// handlers run on the consumer goroutine so they must not block.
+func OnTrade(fn func(structs.Trade)) {
+	tradeMu.Lock()
+	tradeHandlers = append(tradeHandlers, fn)
+	tradeMu.Unlock()
+}
+
+// LastPrice returns the last traded price (engine ticks) seen for symbol
+func LastPrice(symbol uint32) (uint64, bool) {
+	tradeMu.RLock()
+	defer tradeMu.RUnlock()
+	price, ok := lastPrices[symbol]
+	return price, ok
+}
+
+// StartTradeFeed drains the trade feed ring in the background
+func StartTradeFeed(q *queue.TradeQueue) {
+	go func() {
+		for {
+			trade, err := q.Dequeue()
+			if err != nil {
+				log.Printf("trade feed: %v", err)
+				continue
+			}
+			if trade == nil {
+				time.Sleep(idleBackoff)
+				continue
+			}
+			dispatchTrade(*trade)
+		}
+	}()
+}
+
+func dispatchTrade(trade structs.Trade) {
+	tradeMu.Lock()
+	lastPrices[trade.Symbol] = trade.Price
+	handlers := tradeHandlers
+	tradeMu.Unlock()
+
+	for _, fn := range handlers {
+		fn(trade)
+	}
+}
@@ -0,0 +1,141 @@
+package fees
+
+import (
+	"log"
+	"sync"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+)
+
+// commission is charged per fill at the maker or taker rate of the account's
+// tier. the minimum fee is per order: the first fills are topped up until the
+// order has paid at least the minimum, later fills pay the plain rate.
+
+var (
+	mu        sync.RWMutex
+	schedules = make(map[string]db.FeeSchedule)
+)
+
+// Load reads the fee schedules into memory
+func Load() error {
+	list, err := db.ListFeeSchedules()
+	if err != nil {
+		return err
+	}
+	next := make(map[string]db.FeeSchedule, len(list))
+	for _, f := range list {
+		next[f.Tier] = f
+	}
+	mu.Lock()
+	schedules = next
+	mu.Unlock()
+	return nil
+}
+
+// Schedules returns every configured tier
+func Schedules() []db.FeeSchedule {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]db.FeeSchedule, 0, len(schedules))
+	for _, f := range schedules {
+		list = append(list, f)
+	}
+	return list
+}
+
+// SetSchedule stores the schedule of a tier
+func SetSchedule(f db.FeeSchedule) error {
+	if err := db.SetFeeSchedule(f); err != nil {
+		return err
+	}
+	mu.Lock()
+	schedules[f.Tier] = f
+	mu.Unlock()
+	return nil
+}
+
+// ScheduleFor returns the schedule of a user's tier, the default tier's when
+// the user's tier has none
+func ScheduleFor(userID uint64) (db.FeeSchedule, error) {
+	tier, err := db.UserTier(userID)
+	if err != nil {
+		return db.FeeSchedule{}, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if f, ok := schedules[tier]; ok {
+		return f, nil
+	}
+	return schedules[db.DefaultTier], nil
+}
+
+// Commission is the fee on notional at the maker or taker rate of f, before
+// the minimum
+func Commission(f db.FeeSchedule, notional decimal.Amount, maker bool) decimal.Amount {
+	if maker {
+		return decimal.OfBps(notional, f.Maker_bps)
+	}
+	return decimal.OfBps(notional, f.Taker_bps)
+}
+
+// Worst is the most the user's order of notional can pay if it fills
+// completely: the higher of the two rates, minimum included
+func Worst(userID uint64, notional decimal.Amount) (decimal.Amount, error) {
+	f, err := ScheduleFor(userID)
+	if err != nil {
+		return 0, err
+	}
+	fee := Commission(f, notional, false)
+	if maker := Commission(f, notional, true); maker > fee {
+		fee = maker
+	}
+	return withMinimum(f, fee, 0), nil
+}
+
+// withMinimum tops fee up so an order that already paid charged pays at
+// least the minimum of f in total
+func withMinimum(f db.FeeSchedule, fee, charged decimal.Amount) decimal.Amount {
+	if charged+fee < f.Min_fee {
+		return f.Min_fee - charged
+	}
+	return fee
+}
+
+// Charge is the fee of one side of a fill, given to db.InsertFill so the fee
+// is charged in the same transaction that stores the fill. charged is what
+// the order paid on its earlier fills.
+func Charge(fill structs.Fill, userID uint64, maker bool, charged decimal.Amount) (decimal.Amount, error) {
+	spec := symbols.Lookup(fill.Symbol)
+	notional, err := decimal.Notional(fill.Price, spec.PriceScale, uint64(fill.Shares_qty), spec.QtyScale, false)
+	if err != nil {
+		// the fill itself still has to be booked
+		log.Printf("fees: fill %d notional out of range, not charged: %v", fill.Trade_id, err)
+		return 0, nil
+	}
+	f, err := ScheduleFor(userID)
+	if err != nil {
+		return 0, err
+	}
+	return withMinimum(f, Commission(f, notional, maker), charged), nil
+}
+
+// Estimate is what an order would pay if it filled completely at its limit
+// price (the last trade for market orders), as maker and as taker, minimum
+// included
+func Estimate(order structs.Order, price uint64) (maker, taker decimal.Amount, err error) {
+	f, err := ScheduleFor(order.User_id)
+	if err != nil {
+		return 0, 0, err
+	}
+	spec := symbols.Lookup(order.Symbol)
+	notional, err := decimal.Notional(price, spec.PriceScale, uint64(order.Shares_qty), spec.QtyScale, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	maker = withMinimum(f, Commission(f, notional, true), 0)
+	taker = withMinimum(f, Commission(f, notional, false), 0)
+	return maker, taker, nil
+}
@@ -0,0 +1,130 @@
+package halts
+
+import (
+	"fmt"
+	"sync"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/oms"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/stops"
+	"jotacomputing/go-api/structs"
+)
+
+// kill switch: a halt stops new orders for one user, one symbol or the whole
+// venue. cancels keep working so people can still get out.
+
+type key struct {
+	scope  string
+	target uint64
+}
+
+var (
+	mu     sync.RWMutex
+	active = make(map[key]db.Halt)
+)
+
+// Load reads the active halts into memory
+func Load() error {
+	rows, err := db.ListHalts()
+	if err != nil {
+		return err
+	}
+	next := make(map[key]db.Halt)
+	for _, h := range rows {
+		next[key{h.Scope, h.Target}] = h
+	}
+	mu.Lock()
+	active = next
+	mu.Unlock()
+	return nil
+}
+
+// ValidScope reports whether scope/target make a valid halt
+func ValidScope(scope string, target uint64) error {
+	switch scope {
+	case db.HaltGlobal:
+		if target != 0 {
+			return fmt.Errorf("global halts take no target")
+		}
+	case db.HaltUser, db.HaltSymbol:
+		if target == 0 {
+			return fmt.Errorf("%s halts need a target", scope)
+		}
+	default:
+		return fmt.Errorf("scope must be %q, %q or %q", db.HaltGlobal, db.HaltUser, db.HaltSymbol)
+	}
+	return nil
+}
+
+// Gate plugs the halt check into the pre-trade chain, see risk.Prepend
+type Gate struct{}
+
+func (Gate) Name() string { return "halt" }
+
+func (Gate) Check(order structs.Order, _ risk.Limits) *risk.Rejection {
+	return Check(order)
+}
+
+// Check refuses orders covered by any active halt
+func Check(order structs.Order) *risk.Rejection {
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(active) == 0 {
+		return nil
+	}
+	for _, k := range []key{{db.HaltGlobal, 0}, {db.HaltUser, order.User_id}, {db.HaltSymbol, uint64(order.Symbol)}} {
+		if h, ok := active[k]; ok {
+			return &risk.Rejection{
+				Code:    risk.ReasonHalted,
+				Message: fmt.Sprintf("trading halted (%s): %s", k.scope, h.Reason),
+			}
+		}
+	}
+	return nil
+}
+
+// Halt activates a halt. with cancelOpen every open order and armed stop in
+// the scope is pulled too, the number of cancels sent is returned.
+func Halt(h db.Halt, cancelOpen bool) (int, error) {
+	if err := ValidScope(h.Scope, h.Target); err != nil {
+		return 0, err
+	}
+	if err := db.SetHalt(h); err != nil {
+		return 0, err
+	}
+	if err := Load(); err != nil {
+		return 0, err
+	}
+	if !cancelOpen {
+		return 0, nil
+	}
+
+	var f orders.Filter
+	switch h.Scope {
+	case db.HaltUser:
+		f.User_id = h.Target
+	case db.HaltSymbol:
+		f.Symbol = uint32(h.Target)
+	}
+	cancelled := len(stops.CancelMatching(f))
+	_, sent, err := oms.CancelAll(f, "halt: "+h.Reason)
+	cancelled += sent
+	if auditErr := db.RecordHaltCancels(h.Scope, h.Target, cancelled); auditErr != nil && err == nil {
+		err = auditErr
+	}
+	return cancelled, err
+}
+
+// Resume lifts a halt, false if there was none
+func Resume(scope string, target, actor uint64, reason string) (bool, error) {
+	if err := ValidScope(scope, target); err != nil {
+		return false, err
+	}
+	ok, err := db.ClearHalt(scope, target, actor, reason)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, Load()
+}
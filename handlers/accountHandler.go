@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"jotacomputing/go-api/auth"
+	"jotacomputing/go-api/db"
+	"net/http"
+	"strconv"
+
+	echoserver "github.com/dasjott/oauth2-echo-server"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/labstack/echo/v4"
+)
+
+type tempRegister struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// creates an account, POST /api/register. it is not behind the token
+// middleware, the client logs in with the password grant afterwards.
+func RegisterHandler(c echo.Context) error {
+	var req tempRegister
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+
+	user, err := auth.Register(req.Username, req.Password)
+	if errors.Is(err, db.ErrUsernameTaken) {
+		return echo.NewHTTPError(http.StatusConflict, "Username already taken")
+	}
+	if err != nil {
+		var policy *auth.PolicyError
+		if errors.As(err, &policy) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create account")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"status":   "Account created",
+		"user_id":  user.ID,
+		"username": user.Username,
+	})
+}
+
+type tempChangePassword struct {
+	Current_password string `json:"current_password"`
+	New_password     string `json:"new_password"`
+}
+
+// changes the caller's password. every token of the account is revoked, the
+// one used for this request included, so the client has to log in again.
+func ChangePasswordHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+	var req tempChangePassword
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+
+	err = auth.ChangePassword(userID, req.Current_password, req.New_password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Current password is wrong")
+	}
+	if err != nil {
+		var policy *auth.PolicyError
+		if errors.As(err, &policy) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to change password")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":  "Password changed, log in again",
+		"user_id": userID,
+	})
+}
+
+type tempSetPassword struct {
+	Password string `json:"password"`
+}
+
+// sets a user's password, PUT /api/admin/users/:userId/password. accounts
+// from before passwords were checked need this before they can log in.
+func SetUserPasswordHandler(c echo.Context) error {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user id")
+	}
+	var req tempSetPassword
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+
+	err = auth.SetPassword(userID, req.Password)
+	if errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "No such user")
+	}
+	if err != nil {
+		var policy *auth.PolicyError
+		if errors.As(err, &policy) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to set password")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":  "Password set, existing tokens revoked",
+		"user_id": userID,
+	})
+}
+
+// RejectRevokedTokens refuses tokens of disabled clients and tokens issued
+// before the user's last password change. it has to run after the token
+// middleware.
+func RejectRevokedTokens(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ti, exists := c.Get(echoserver.DefaultConfig.TokenKey).(oauth2.TokenInfo)
+		if !exists {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or missing token")
+		}
+		userID, err := userIDFromToken(c)
+		if err != nil {
+			return err
+		}
+		if !auth.ClientEnabled(ti.GetClientID()) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Token revoked, client disabled")
+		}
+		revoked, err := auth.TokenRevoked(userID, ti.GetAccessCreateAt())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or missing token")
+		}
+		if revoked {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Token revoked, log in again")
+		}
+		return next(c)
+	}
+}
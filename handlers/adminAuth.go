@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"jotacomputing/go-api/db"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RequireAdmin only lets users with the admin role through. it has to run
+// after the token middleware.
+func RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := userIDFromToken(c)
+		if err != nil {
+			return err
+		}
+		user, err := db.FindUserByID(int64(userID))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+		}
+		if user.Role != db.RoleAdmin {
+			return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+		}
+		return next(c)
+	}
+}
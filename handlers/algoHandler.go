@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"jotacomputing/go-api/algo"
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/symbols"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type tempAlgo struct {
+	Strategy       string        `json:"strategy"` // twap or vwap
+	Symbol         uint32        `json:"symbol"`
+	Side           uint8         `json:"side"`
+	Order_type     uint8         `json:"order_type"` // 0=market 1=limit, children are sent IOC
+	Price          decimal.Value `json:"price"`
+	Shares_qty     decimal.Value `json:"shares_qty"`
+	Duration_secs  uint64        `json:"duration_secs"`
+	Slices         int           `json:"slices"`         // twap only
+	Volume_profile []uint64      `json:"volume_profile"` // vwap only, relative volume of each slice
+	Start_at       uint64        `json:"start_at"`       // unix nanos, optional
+}
+
+type algoView struct {
+	Algo_id       uint64 `json:"algo_id"`
+	Strategy      string `json:"strategy"`
+	Status        string `json:"status"`
+	Symbol        uint32 `json:"symbol"`
+	Side          uint8  `json:"side"`
+	Order_type    uint8  `json:"order_type"`
+	Price         string `json:"price,omitempty"`
+	Shares_qty    string `json:"shares_qty"`
+	Sent_qty      string `json:"sent_qty"`
+	Filled_qty    string `json:"filled_qty"`
+	Remaining_qty string `json:"remaining_qty"`
+	Progress_pct  string `json:"progress_pct"`
+	Slices        int    `json:"slices"`
+	Slices_sent   int    `json:"slices_sent"`
+	Next_slice_at string `json:"next_slice_at,omitempty"`
+	Last_error    string `json:"last_error,omitempty"`
+	Created_at    string `json:"created_at"`
+	Updated_at    string `json:"updated_at"`
+}
+
+type algoChildView struct {
+	Order_id   uint64 `json:"order_id"`
+	Slice      int    `json:"slice"`
+	Shares_qty string `json:"shares_qty"`
+	Filled_qty string `json:"filled_qty"`
+	Status     string `json:"status"`
+	Created_at string `json:"created_at"`
+}
+
+func newAlgoView(a *db.AlgoOrder) algoView {
+	spec := symbols.Lookup(a.Symbol)
+	var remaining uint64
+	if a.Total_qty > a.Filled_qty {
+		remaining = a.Total_qty - a.Filled_qty
+	}
+	// filled / total in hundredths of a percent
+	hi, lo := bits.Mul64(a.Filled_qty, 10000)
+	progress := uint64(10000)
+	if a.Filled_qty < a.Total_qty {
+		progress, _ = bits.Div64(hi, lo, a.Total_qty)
+	}
+
+	v := algoView{
+		Algo_id:       a.ID,
+		Strategy:      a.Strategy,
+		Status:        a.Status,
+		Symbol:        a.Symbol,
+		Side:          a.Side,
+		Order_type:    a.Order_type,
+		Shares_qty:    decimal.Format(a.Total_qty, spec.QtyScale),
+		Sent_qty:      decimal.Format(a.Sent_qty, spec.QtyScale),
+		Filled_qty:    decimal.Format(a.Filled_qty, spec.QtyScale),
+		Remaining_qty: decimal.Format(remaining, spec.QtyScale),
+		Progress_pct:  decimal.Format(progress, 2),
+		Slices:        len(a.Weights),
+		Slices_sent:   a.Next_slice,
+		Last_error:    a.Last_error,
+		Created_at:    formatNanos(a.Created_at),
+		Updated_at:    formatNanos(a.Updated_at),
+	}
+	if a.Price != 0 {
+		v.Price = decimal.Format(a.Price, spec.PriceScale)
+	}
+	if a.Status == db.AlgoRunning && a.Next_slice < len(a.Weights) {
+		v.Next_slice_at = formatNanos(a.Next_at)
+	}
+	return v
+}
+
+func algoError(err error) error {
+	switch {
+	case errors.Is(err, algo.ErrInvalid):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, algo.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "Algo order not found")
+	case errors.Is(err, algo.ErrState):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update algo order")
+}
+
+// starts a TWAP or VWAP parent order, slices go out on schedule as IOC children
+func PostAlgoHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	var req tempAlgo
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	spec := symbols.Lookup(req.Symbol)
+
+	var price uint64
+	if !req.Price.IsZero() {
+		if price, err = req.Price.Ticks(spec.PriceScale); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "price: "+err.Error())
+		}
+	}
+	qty, err := req.Shares_qty.Ticks(spec.QtyScale)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "shares_qty: "+err.Error())
+	}
+	params := algo.Params{
+		User_id:    userID,
+		Strategy:   req.Strategy,
+		Symbol:     req.Symbol,
+		Side:       req.Side,
+		Order_type: req.Order_type,
+		Price:      price,
+		Total_qty:  qty,
+		Duration:   time.Duration(req.Duration_secs) * time.Second,
+		Slices:     req.Slices,
+		Profile:    req.Volume_profile,
+	}
+	if req.Start_at != 0 {
+		params.Start_at = time.Unix(0, int64(req.Start_at))
+	}
+
+	a, err := algo.Start(params)
+	if err != nil {
+		if errors.Is(err, algo.ErrInvalid) {
+			return algoError(err)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start algo order")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status": "Algo order started",
+		"algo":   newAlgoView(a),
+	})
+}
+
+// lists the user's algo orders, newest first
+func GetAlgosHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	algos, err := db.ListAlgoOrders(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load algo orders")
+	}
+	views := make([]algoView, 0, len(algos))
+	for _, a := range algos {
+		views = append(views, newAlgoView(a))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"algos":   views,
+	})
+}
+
+func parseAlgoID(c echo.Context) (uint64, error) {
+	id, err := strconv.ParseUint(c.Param("algoId"), 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid algo id")
+	}
+	return id, nil
+}
+
+// progress of one algo order with every child sent so far
+func GetAlgoHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+	algoID, err := parseAlgoID(c)
+	if err != nil {
+		return err
+	}
+
+	a, err := db.GetAlgoOrder(userID, algoID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "Algo order not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load algo order")
+	}
+	kids, err := db.ListAlgoChildren(algoID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load algo children")
+	}
+
+	spec := symbols.Lookup(a.Symbol)
+	views := make([]algoChildView, 0, len(kids))
+	for _, k := range kids {
+		views = append(views, algoChildView{
+			Order_id:   k.Order_id,
+			Slice:      k.Slice,
+			Shares_qty: decimal.Format(uint64(k.Qty), spec.QtyScale),
+			Filled_qty: decimal.Format(uint64(k.Filled_qty), spec.QtyScale),
+			Status:     statusName(k.Status),
+			Created_at: formatNanos(k.Created_at),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"algo":     newAlgoView(a),
+		"children": views,
+	})
+}
+
+func algoAction(c echo.Context, action func(userID, algoID uint64) (*db.AlgoOrder, error), status string) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+	algoID, err := parseAlgoID(c)
+	if err != nil {
+		return err
+	}
+
+	a, err := action(userID, algoID)
+	if err != nil {
+		return algoError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status": status,
+		"algo":   newAlgoView(a),
+	})
+}
+
+func PauseAlgoHandler(c echo.Context) error {
+	return algoAction(c, algo.Pause, "Algo order paused")
+}
+
+func ResumeAlgoHandler(c echo.Context) error {
+	return algoAction(c, algo.Resume, "Algo order resumed")
+}
+
+// stops the algo and cancels its working children
+func CancelAlgoHandler(c echo.Context) error {
+	return algoAction(c, algo.Cancel, "Algo order cancelled")
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	echoserver "github.com/dasjott/oauth2-echo-server"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/labstack/echo/v4"
+)
+
+// userIDFromToken pulls the authenticated user id out of the OAuth2 token
+func userIDFromToken(c echo.Context) (uint64, error) {
+	ti, exists := c.Get(echoserver.DefaultConfig.TokenKey).(oauth2.TokenInfo)
+	if !exists {
+		return 0, echo.NewHTTPError(http.StatusUnauthorized, "Invalid or missing token")
+	}
+
+	// Parse string userID back to uint64 (matches your matching engine)
+	userID, err := strconv.ParseUint(ti.GetUserID(), 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Invalid user ID format")
+	}
+	return userID, nil
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"jotacomputing/go-api/oms"
+	"jotacomputing/go-api/ratelimit"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type tempBatch struct {
+	Orders []structs.TempOrder `json:"orders"`
+}
+
+// legResult is the outcome of one order of a batch, in request order
+type legResult struct {
+	Index       int    `json:"index"`
+	Order_id    uint64 `json:"order_id"`
+	Status      string `json:"status"` // accepted or rejected
+	Reason_code string `json:"reason_code,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+func rejectedLeg(i int, orderID uint64, err error) legResult {
+	leg := legResult{Index: i, Order_id: orderID, Status: "rejected", Error: err.Error()}
+	var r *risk.Rejection
+	if errors.As(err, &r) {
+		leg.Reason_code, leg.Error = r.Code, r.Message
+	}
+	return leg
+}
+
+// places a basket of orders all-or-nothing: if any leg fails validation or the
+// pre-trade checks none of them is sent. every leg costs one order token, a
+// basket the bucket can't cover is refused as a whole.
+func PostBatchOrderHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	var req tempBatch
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	if len(req.Orders) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "orders must not be empty")
+	}
+	if len(req.Orders) > oms.MaxBatchSize {
+		return c.JSON(http.StatusUnprocessableEntity, &risk.Rejection{
+			Code:    risk.ReasonBatchTooLarge,
+			Message: fmt.Sprintf("batch of %d orders, at most %d allowed", len(req.Orders), oms.MaxBatchSize),
+		})
+	}
+	d := ratelimit.AllowN(userID, ratelimit.ClassOrder, len(req.Orders))
+	if !d.Allowed {
+		return rateLimited(c, d)
+	}
+	setRateHeaders(c, d)
+
+	// field validation first, nothing is stored if a leg is malformed
+	batch := make([]structs.Order, len(req.Orders))
+	legs := make([]legResult, len(req.Orders))
+	invalid := false
+	for i := range req.Orders {
+		t := &req.Orders[i]
+		legs[i] = legResult{Index: i, Order_id: t.Order_id, Status: "accepted"}
+		if t.IsStop() {
+			legs[i] = rejectedLeg(i, t.Order_id, errors.New("stop orders can't be part of a batch"))
+			invalid = true
+			continue
+		}
+		if batch[i], err = t.ToOrder(userID); err != nil {
+			legs[i] = rejectedLeg(i, t.Order_id, err)
+			invalid = true
+		}
+	}
+	if invalid {
+		for i := range legs {
+			if legs[i].Status == "accepted" {
+				legs[i] = rejectedLeg(i, legs[i].Order_id, &risk.Rejection{Code: risk.ReasonBatchRejected, Message: "another order of the batch was rejected"})
+			}
+		}
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"status": "Batch rejected",
+			"orders": legs,
+		})
+	}
+
+	results, err := oms.SubmitBatch(batch)
+	var rejection *risk.Rejection
+	if errors.As(err, &rejection) {
+		return c.JSON(http.StatusUnprocessableEntity, rejection)
+	}
+	if err != nil && !errors.Is(err, oms.ErrBatchRejected) {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to enqueue batch")
+	}
+	for i, legErr := range results {
+		if legErr != nil {
+			legs[i] = rejectedLeg(i, batch[i].Order_id, legErr)
+		}
+	}
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": "Batch rejected",
+			"orders": legs,
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":  "Batch placed successfully",
+		"user_id": userID,
+		"orders":  legs,
+	})
+}
@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/oms"
 	"jotacomputing/go-api/structs"
 	"net/http"
 	"strconv"
@@ -38,7 +38,7 @@ func CancelOrderHandler(c echo.Context) error {
 	cancelOrder.Symbol = tempOrderCancel.Symbol
 
 	// Enqueue the order cancel
-	if err := queue.CancelOrderQueue.Enqueue(cancelOrder); err != nil {
+	if err := oms.Cancel(cancelOrder, "user request"); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to enqueue cancel order")
 	}
 	return c.JSON(http.StatusOK, map[string]interface{}{
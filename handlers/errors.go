@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"errors"
+	"jotacomputing/go-api/risk"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// submitError maps an oms.Submit failure to a response. pre-trade rejections
+// carry their reason code so clients can tell them apart.
+func submitError(c echo.Context, err error) error {
+	var rejection *risk.Rejection
+	if errors.As(err, &rejection) {
+		return c.JSON(http.StatusUnprocessableEntity, rejection)
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, "Failed to enqueue order")
+}
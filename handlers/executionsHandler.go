@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/symbols"
+	"math/big"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// extra decimals on average prices so they don't get rounded to a tick
+const avgPriceExtraScale = 4
+
+type executionView struct {
+	Execution_id uint64         `json:"execution_id"`
+	Trade_id     uint64         `json:"trade_id"`
+	Order_id     uint64         `json:"order_id"`
+	Symbol       uint32         `json:"symbol"`
+	Side         uint8          `json:"side"`
+	Price        string         `json:"price"`
+	Shares_qty   string         `json:"shares_qty"`
+	Liquidity    string         `json:"liquidity"`
+	Fee          decimal.Amount `json:"fee"`
+	Timestamp    uint64         `json:"timestamp"`
+	Created_at   string         `json:"created_at"`
+}
+
+func newExecutionView(e db.Execution) executionView {
+	spec := symbols.Lookup(e.Symbol)
+	liquidity := "taker"
+	if e.Maker {
+		liquidity = "maker"
+	}
+	return executionView{
+		Execution_id: e.ID,
+		Trade_id:     e.Trade_id,
+		Order_id:     e.Order_id,
+		Symbol:       e.Symbol,
+		Side:         e.Side,
+		Price:        decimal.Format(e.Price, spec.PriceScale),
+		Shares_qty:   decimal.Format(uint64(e.Shares_qty), spec.QtyScale),
+		Liquidity:    liquidity,
+		Fee:          e.Fee,
+		Timestamp:    e.Timestamp,
+		Created_at:   formatNanos(e.Created_at),
+	}
+}
+
+// lists the user's executions, newest first.
+// filters: symbol, from/to (RFC3339). paginate with limit and the returned next_cursor.
+func GetExecutionsHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	q := db.ExecutionQuery{User_id: userID, Limit: defaultPageSize}
+
+	if q.Symbol, _, err = parseSymbolSide(c); err != nil {
+		return err
+	}
+	if q.From, err = parseTimeParam(c, "from"); err != nil {
+		return err
+	}
+	if q.To, err = parseTimeParam(c, "to"); err != nil {
+		return err
+	}
+
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxPageSize {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+		}
+		q.Limit = n
+	}
+	if s := c.QueryParam("cursor"); s != "" {
+		if q.After_id, err = decodeIDCursor(s); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+		}
+	}
+
+	execs, err := db.ListExecutions(q)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load executions")
+	}
+
+	views := make([]executionView, 0, len(execs))
+	for _, e := range execs {
+		views = append(views, newExecutionView(e))
+	}
+	nextCursor := ""
+	if len(execs) == q.Limit {
+		last := execs[len(execs)-1]
+		nextCursor = encodeIDCursor(last.ID)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user_id":     userID,
+		"executions":  views,
+		"next_cursor": nextCursor,
+	})
+}
+
+// execution ids only grow, the id of the last row is the whole sort key
+func encodeIDCursor(id uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(id, 10)))
+}
+
+func decodeIDCursor(cursor string) (uint64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(raw), 10, 64)
+}
+
+// executions of one of the user's orders with the cumulative quantity and
+// volume weighted average price
+func GetOrderExecutionsHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	orderID, err := strconv.ParseUint(c.Param("orderId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid order id")
+	}
+
+	record, err := db.GetOrder(userID, orderID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "Order not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load order")
+	}
+	execs, err := db.ListExecutions(db.ExecutionQuery{User_id: userID, Order_id: orderID})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load executions")
+	}
+
+	spec := symbols.Lookup(record.Symbol)
+	views := make([]executionView, 0, len(execs))
+	var cumQty uint64
+	notional := new(big.Int)
+	for _, e := range execs {
+		views = append(views, newExecutionView(e))
+		cumQty += uint64(e.Shares_qty)
+		notional.Add(notional, new(big.Int).Mul(
+			new(big.Int).SetUint64(e.Price),
+			new(big.Int).SetUint64(uint64(e.Shares_qty)),
+		))
+	}
+
+	avgPrice := ""
+	if cumQty > 0 {
+		avgPrice = formatAverage(notional, cumQty, spec.PriceScale)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"order":          newOrderView(*record),
+		"executions":     views,
+		"cumulative_qty": decimal.Format(cumQty, spec.QtyScale),
+		"avg_price":      avgPrice,
+	})
+}
+
+// formatAverage divides price*qty by qty, keeping a few decimals past the
+// price scale and rounding half up
+func formatAverage(notional *big.Int, qty uint64, priceScale uint8) string {
+	scale := priceScale + avgPriceExtraScale
+	if scale > decimal.MaxScale {
+		scale = decimal.MaxScale
+	}
+	extra := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale-priceScale)), nil)
+	num := new(big.Int).Mul(notional, extra)
+	den := new(big.Int).SetUint64(qty)
+	num.Add(num, new(big.Int).Rsh(den, 1))
+	num.Quo(num, den)
+	if !num.IsUint64() {
+		return ""
+	}
+	return decimal.Format(num.Uint64(), scale)
+}
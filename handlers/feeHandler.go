@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/feeds"
+	"jotacomputing/go-api/fees"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// estimates the commission of an order without sending it. the body is the
+// same as POST /api/order. an order that would cross the last trade (or is
+// a market, IOC or FOK order) is estimated as taker, anything else as maker.
+func PostFeePreviewHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	var tempOrder structs.TempOrder
+	if err := c.Bind(&tempOrder); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	order, err := tempOrder.ToOrder(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	last, traded := feeds.LastPrice(order.Symbol)
+	price := order.Price
+	if order.Order_type == structs.OrderTypeMarket || order.Order_type == structs.OrderTypeStop {
+		if !traded {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "no last trade price to value the order at")
+		}
+		price = last
+	}
+
+	maker, taker, err := fees.Estimate(order, price)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to estimate fee")
+	}
+
+	liquidity, estimate := "taker", taker
+	crosses := !traded || (order.Side == 0 && price >= last) || (order.Side == 1 && price <= last)
+	immediate := order.Time_in_force == structs.TifIOC || order.Time_in_force == structs.TifFOK
+	if order.Order_type == structs.OrderTypeLimit && !immediate && !crosses {
+		liquidity, estimate = "maker", maker
+	}
+
+	spec := symbols.Lookup(order.Symbol)
+	notional, _ := decimal.Notional(price, spec.PriceScale, uint64(order.Shares_qty), spec.QtyScale, false)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user_id":       userID,
+		"symbol":        order.Symbol,
+		"price":         decimal.Format(price, spec.PriceScale),
+		"quantity":      decimal.Format(uint64(order.Shares_qty), spec.QtyScale),
+		"notional":      notional,
+		"maker_fee":     maker,
+		"taker_fee":     taker,
+		"liquidity":     liquidity,
+		"estimated_fee": estimate,
+	})
+}
+
+// lists the fee schedule of every tier
+func GetFeeSchedulesHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"schedules": fees.Schedules()})
+}
+
+type tempFeeSchedule struct {
+	Maker_bps uint64         `json:"maker_bps"`
+	Taker_bps uint64         `json:"taker_bps"`
+	Min_fee   decimal.Amount `json:"min_fee"`
+}
+
+// sets the fee schedule of a tier, PUT /api/admin/fees/:tier
+func PutFeeScheduleHandler(c echo.Context) error {
+	tier := c.Param("tier")
+	if tier == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "tier must be specified")
+	}
+	var req tempFeeSchedule
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Maker_bps > 10000 || req.Taker_bps > 10000 {
+		return echo.NewHTTPError(http.StatusBadRequest, "rates must be at most 10000 bps")
+	}
+	if req.Min_fee < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "min_fee must not be negative")
+	}
+
+	schedule := db.FeeSchedule{Tier: tier, Maker_bps: req.Maker_bps, Taker_bps: req.Taker_bps, Min_fee: req.Min_fee}
+	if err := fees.SetSchedule(schedule); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to store fee schedule")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":   "Fee schedule updated",
+		"schedule": schedule,
+	})
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/halts"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type tempHalt struct {
+	Scope         string `json:"scope"`  // global, user or symbol
+	Target        uint64 `json:"target"` // user id or symbol, 0 for global
+	Reason        string `json:"reason"`
+	Cancel_orders bool   `json:"cancel_orders"` // also pull open orders in the scope
+}
+
+// halts trading for a user, a symbol or everything
+func PostHaltHandler(c echo.Context) error {
+	adminID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	var req tempHalt
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	if err := halts.ValidScope(req.Scope, req.Target); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Reason == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "reason must be specified")
+	}
+
+	cancelled, err := halts.Halt(db.Halt{
+		Scope:      req.Scope,
+		Target:     req.Target,
+		Reason:     req.Reason,
+		Created_by: adminID,
+	}, req.Cancel_orders)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error":            "Halt recorded but cancelling open orders failed: " + err.Error(),
+			"orders_cancelled": cancelled,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":           "Trading halted",
+		"scope":            req.Scope,
+		"target":           req.Target,
+		"orders_cancelled": cancelled,
+	})
+}
+
+// lifts a halt, DELETE /api/admin/halts/:scope/:target (target 0 for global)
+func DeleteHaltHandler(c echo.Context) error {
+	adminID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	target, err := strconv.ParseUint(c.Param("target"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid target")
+	}
+	scope := c.Param("scope")
+
+	ok, err := halts.Resume(scope, target, adminID, c.QueryParam("reason"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "No such halt")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status": "Trading resumed",
+		"scope":  scope,
+		"target": target,
+	})
+}
+
+func GetHaltsHandler(c echo.Context) error {
+	active, err := db.ListHalts()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load halts")
+	}
+	if active == nil {
+		active = []db.Halt{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"halts": active,
+	})
+}
+
+func GetHaltAuditHandler(c echo.Context) error {
+	limit := 100
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > 1000 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 1000")
+		}
+		limit = n
+	}
+	audit, err := db.ListHaltAudit(limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load halt audit")
+	}
+	if audit == nil {
+		audit = []db.HaltAudit{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"audit": audit,
+	})
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/margin"
+	"jotacomputing/go-api/positions"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type marginCallView struct {
+	ID           uint64         `json:"id"`
+	Status       string         `json:"status"`
+	Deficit      decimal.Amount `json:"deficit"`
+	Liquidations int            `json:"liquidations"`
+	Opened_at    int64          `json:"opened_at"`
+	Closed_at    int64          `json:"closed_at,omitempty"`
+}
+
+// the account's equity against its margin requirements. buying power is what
+// the excess equity not held by open orders can open at the default initial
+// rate, symbols with their own rate get more or less.
+func GetMarginHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+	if !margin.Enabled(userID) {
+		return echo.NewHTTPError(http.StatusNotFound, "Not a margin account")
+	}
+
+	summary, ok, err := margin.Compute(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to value account")
+	}
+	if !ok {
+		if err := positions.Request(userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to enqueue holdings query")
+		}
+		return c.JSON(http.StatusAccepted, map[string]interface{}{
+			"status":  "Holdings requested, try again shortly",
+			"user_id": userID,
+		})
+	}
+	_, reserved, err := db.AvailableBalance(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load reservations")
+	}
+
+	var buyingPower decimal.Amount
+	free := summary.Excess() - reserved
+	if rate := margin.RateFor(0).Initial_bps; free > 0 && rate > 0 {
+		if free > math.MaxInt64/10000 {
+			buyingPower = decimal.MaxAmount
+		} else {
+			buyingPower = free * 10000 / decimal.Amount(rate)
+		}
+	}
+
+	resp := map[string]interface{}{
+		"user_id":      userID,
+		"cash":         summary.Cash,
+		"long_value":   summary.Long_value,
+		"short_value":  summary.Short_value,
+		"equity":       summary.Equity,
+		"initial":      summary.Initial,
+		"maintenance":  summary.Maintenance,
+		"excess":       summary.Excess(),
+		"reserved":     reserved,
+		"buying_power": buyingPower,
+	}
+	last, err := db.LatestMarginCall(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load margin calls")
+	}
+	if last != nil {
+		resp["margin_call"] = marginCallView{
+			ID:           last.ID,
+			Status:       last.Status,
+			Deficit:      last.Deficit,
+			Liquidations: last.Liquidations,
+			Opened_at:    last.Opened_at,
+			Closed_at:    last.Closed_at,
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// lists the margin rates, symbol 0 is the default
+func GetMarginRatesHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"rates": margin.Rates()})
+}
+
+type tempMarginRate struct {
+	Initial_bps     uint64 `json:"initial_bps"`
+	Maintenance_bps uint64 `json:"maintenance_bps"`
+}
+
+// sets the margin rates of a symbol, PUT /api/admin/margin/rates/:symbol
+// (symbol 0 for the default)
+func PutMarginRateHandler(c echo.Context) error {
+	symbol, err := strconv.ParseUint(c.Param("symbol"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid symbol")
+	}
+	var req tempMarginRate
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Maintenance_bps == 0 || req.Maintenance_bps > req.Initial_bps || req.Initial_bps > 10000 {
+		return echo.NewHTTPError(http.StatusBadRequest, "rates must satisfy 0 < maintenance_bps <= initial_bps <= 10000")
+	}
+
+	rate := db.MarginRate{Symbol: uint32(symbol), Initial_bps: req.Initial_bps, Maintenance_bps: req.Maintenance_bps}
+	if err := margin.SetRate(rate); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to store margin rate")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status": "Margin rate updated",
+		"rate":   rate,
+	})
+}
+
+type tempMarginAccount struct {
+	Enabled bool `json:"enabled"`
+}
+
+// switches an account between margin and cash, PUT /api/admin/margin/accounts/:userId
+func PutMarginAccountHandler(c echo.Context) error {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user id")
+	}
+	var req tempMarginAccount
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+
+	found, err := margin.SetEnabled(userID, req.Enabled)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update account")
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, "No such user")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":         "Margin account updated",
+		"user_id":        userID,
+		"margin_enabled": req.Enabled,
+	})
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"jotacomputing/go-api/calendar"
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/symbols"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type marketStatusView struct {
+	calendar.Status
+	Allowed_order_types []uint8 `json:"allowed_order_types"`
+}
+
+func newMarketStatusView(group string, now time.Time) marketStatusView {
+	status := calendar.StatusOf(group, now)
+	allowed := calendar.AllowedOrderTypes(status.Session)
+	if allowed == nil {
+		allowed = []uint8{}
+	}
+	return marketStatusView{Status: status, Allowed_order_types: allowed}
+}
+
+// current trading session, for one symbol (?symbol=), one group (?group=) or every group
+func GetMarketStatusHandler(c echo.Context) error {
+	now := time.Now()
+
+	if s := c.QueryParam("symbol"); s != "" {
+		symbol, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || symbol == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid symbol")
+		}
+		return c.JSON(http.StatusOK, newMarketStatusView(symbols.Lookup(uint32(symbol)).Group, now))
+	}
+	if group := c.QueryParam("group"); group != "" {
+		return c.JSON(http.StatusOK, newMarketStatusView(group, now))
+	}
+
+	views := make([]marketStatusView, 0)
+	for _, group := range calendar.Groups() {
+		views = append(views, newMarketStatusView(group, now))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"markets": views,
+	})
+}
+
+// closes a symbol group for a whole day
+func PostHolidayHandler(c echo.Context) error {
+	var holiday db.Holiday
+	if err := c.Bind(&holiday); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	if holiday.Group == "" {
+		holiday.Group = db.DefaultSymbolGroup
+	}
+	if _, err := time.Parse("2006-01-02", holiday.Day); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "day must be YYYY-MM-DD")
+	}
+
+	if err := db.AddHoliday(holiday); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to store holiday")
+	}
+	if err := calendar.Load(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to reload calendar")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":  "Holiday added",
+		"holiday": holiday,
+	})
+}
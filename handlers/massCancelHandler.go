@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"jotacomputing/go-api/oms"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/stops"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// parseSymbolSide reads the optional symbol and side query params
+func parseSymbolSide(c echo.Context) (uint32, *uint8, error) {
+	var symbol uint32
+	var side *uint8
+	if s := c.QueryParam("symbol"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || n == 0 {
+			return 0, nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid symbol")
+		}
+		symbol = uint32(n)
+	}
+	if s := c.QueryParam("side"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 8)
+		if err != nil || n > 1 {
+			return 0, nil, echo.NewHTTPError(http.StatusBadRequest, "side must be 0 (buy) or 1 (sell)")
+		}
+		sideU8 := uint8(n)
+		side = &sideU8
+	}
+	return symbol, side, nil
+}
+
+// cancels every open order of the user, optionally only for one symbol and/or side.
+// stop orders still held by the gateway are disarmed as well.
+func MassCancelHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	symbol, side, err := parseSymbolSide(c)
+	if err != nil {
+		return err
+	}
+	filter := orders.Filter{User_id: userID, Symbol: symbol, Side: side}
+
+	stopsCancelled := len(stops.CancelMatching(filter))
+	targeted, sent, err := oms.CancelAll(filter, "mass cancel")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error":           "Failed to enqueue all cancels",
+			"targeted":        targeted,
+			"cancels_sent":    sent,
+			"stops_cancelled": stopsCancelled,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":          "Mass cancel sent successfully",
+		"user_id":         userID,
+		"targeted":        targeted,
+		"cancels_sent":    sent,
+		"stops_cancelled": stopsCancelled,
+	})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"jotacomputing/go-api/auth"
+	"jotacomputing/go-api/db"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// lists the registered oauth clients, secrets are never shown
+func GetOAuthClientsHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"clients": auth.Clients()})
+}
+
+type tempOAuthClient struct {
+	Client_id     string   `json:"client_id"` // generated when empty
+	Name          string   `json:"name"`
+	Redirect_uris []string `json:"redirect_uris"`
+	Grants        []string `json:"grants"`
+	Scopes        []string `json:"scopes"`
+}
+
+// registers a client, the secret is in the response and nowhere else
+func PostOAuthClientHandler(c echo.Context) error {
+	var req tempOAuthClient
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+
+	client, secret, err := auth.CreateClient(db.OAuthClient{
+		ID:            req.Client_id,
+		Name:          req.Name,
+		Redirect_uris: req.Redirect_uris,
+		Grants:        req.Grants,
+		Scopes:        req.Scopes,
+	})
+	if errors.Is(err, db.ErrDuplicateClient) {
+		return echo.NewHTTPError(http.StatusConflict, "Client id already used")
+	}
+	if err != nil {
+		var policy *auth.PolicyError
+		if errors.As(err, &policy) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create client")
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"status":        "Client created",
+		"client":        client,
+		"client_secret": secret,
+	})
+}
+
+// issues a new secret, POST /api/admin/oauth/clients/:clientId/rotate. the
+// old secret stops working immediately.
+func RotateOAuthClientHandler(c echo.Context) error {
+	id := c.Param("clientId")
+	secret, err := auth.RotateClientSecret(id)
+	if errors.Is(err, auth.ErrUnknownClient) {
+		return echo.NewHTTPError(http.StatusNotFound, "No such client")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to rotate secret")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":        "Secret rotated",
+		"client_id":     id,
+		"client_secret": secret,
+	})
+}
+
+type tempOAuthClientState struct {
+	Disabled bool `json:"disabled"`
+}
+
+// disables or re-enables a client, PUT /api/admin/oauth/clients/:clientId.
+// a disabled client gets no tokens and the ones it has are refused.
+func PutOAuthClientHandler(c echo.Context) error {
+	id := c.Param("clientId")
+	var req tempOAuthClientState
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+
+	err := auth.SetClientDisabled(id, req.Disabled)
+	if errors.Is(err, auth.ErrUnknownClient) {
+		return echo.NewHTTPError(http.StatusNotFound, "No such client")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update client")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":    "Client updated",
+		"client_id": id,
+		"disabled":  req.Disabled,
+	})
+}
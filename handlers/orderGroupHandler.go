@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/oco"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type tempBracket struct {
+	Entry       *structs.TempOrder `json:"entry"`
+	Take_profit *structs.TempOrder `json:"take_profit"`
+	Stop_loss   *structs.TempOrder `json:"stop_loss"`
+}
+
+type tempOCO struct {
+	Legs []structs.TempOrder `json:"legs"`
+}
+
+type orderGroupLegView struct {
+	Role         string `json:"role"`
+	Status       string `json:"status"`
+	Order_id     uint64 `json:"order_id"`
+	Side         uint8  `json:"side"`
+	Order_type   uint8  `json:"order_type"`
+	Price        string `json:"price"`
+	Stop_price   string `json:"stop_price,omitempty"`
+	Shares_qty   string `json:"shares_qty"`
+	Order_status string `json:"order_status,omitempty"` // engine status once the leg was sent
+}
+
+type orderGroupView struct {
+	Group_id   uint64              `json:"group_id"`
+	Kind       string              `json:"kind"`
+	Status     string              `json:"status"`
+	Symbol     uint32              `json:"symbol"`
+	Legs       []orderGroupLegView `json:"legs"`
+	Created_at string              `json:"created_at"`
+	Updated_at string              `json:"updated_at"`
+}
+
+func newOrderGroupView(g *db.OrderGroup) orderGroupView {
+	v := orderGroupView{
+		Group_id:   g.ID,
+		Kind:       g.Kind,
+		Status:     g.Status,
+		Legs:       make([]orderGroupLegView, 0, len(g.Legs)),
+		Created_at: formatNanos(g.Created_at),
+		Updated_at: formatNanos(g.Updated_at),
+	}
+	for _, leg := range g.Legs {
+		o := leg.Order.Order
+		spec := symbols.Lookup(o.Symbol)
+		v.Symbol = o.Symbol
+		lv := orderGroupLegView{
+			Role:       leg.Role,
+			Status:     leg.Status,
+			Order_id:   o.Order_id,
+			Side:       o.Side,
+			Order_type: o.Order_type,
+			Price:      decimal.Format(o.Price, spec.PriceScale),
+			Shares_qty: decimal.Format(uint64(o.Shares_qty), spec.QtyScale),
+		}
+		if leg.Order.Stop_price != 0 {
+			lv.Stop_price = decimal.Format(leg.Order.Stop_price, spec.PriceScale)
+		}
+		if record, err := db.GetOrder(o.User_id, o.Order_id); err == nil {
+			lv.Order_status = statusName(record.Status)
+		}
+		v.Legs = append(v.Legs, lv)
+	}
+	return v
+}
+
+// toLeg converts one leg of a group request, stop legs keep their trigger
+func toLeg(t *structs.TempOrder, userID uint64) (structs.StopOrder, error) {
+	if t.IsStop() {
+		return t.ToStopOrder(userID)
+	}
+	order, err := t.ToOrder(userID)
+	return structs.StopOrder{Order: order}, err
+}
+
+func orderGroupError(c echo.Context, g *db.OrderGroup, err error) error {
+	if errors.Is(err, oco.ErrInvalidGroup) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, db.ErrDuplicateLeg) {
+		return c.JSON(http.StatusUnprocessableEntity, &risk.Rejection{Code: risk.ReasonDuplicateOrder, Message: "order id already used"})
+	}
+	var rejection *risk.Rejection
+	if errors.As(err, &rejection) && g != nil && g.ID != 0 {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"reason_code": rejection.Code,
+			"error":       rejection.Message,
+			"group":       newOrderGroupView(g),
+		})
+	}
+	return submitError(c, err)
+}
+
+// places entry, take profit and stop loss as one unit. the exits are held
+// until the entry fills and then cancel each other.
+func PostBracketOrderHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	var req tempBracket
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Entry == nil || req.Take_profit == nil || req.Stop_loss == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "entry, take_profit and stop_loss must be specified")
+	}
+
+	entry, err := toLeg(req.Entry, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "entry: "+err.Error())
+	}
+	takeProfit, err := toLeg(req.Take_profit, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "take_profit: "+err.Error())
+	}
+	stopLoss, err := toLeg(req.Stop_loss, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "stop_loss: "+err.Error())
+	}
+
+	g, err := oco.CreateBracket(entry, takeProfit, stopLoss)
+	if err != nil {
+		return orderGroupError(c, g, err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status": "Bracket order placed successfully",
+		"group":  newOrderGroupView(g),
+	})
+}
+
+// places two orders where the first execution on either cancels the other
+func PostOCOOrderHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	var req tempOCO
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	if len(req.Legs) != 2 {
+		return echo.NewHTTPError(http.StatusBadRequest, "an OCO order needs exactly 2 legs")
+	}
+
+	var legs [2]structs.StopOrder
+	for i := range req.Legs {
+		if legs[i], err = toLeg(&req.Legs[i], userID); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "leg "+strconv.Itoa(i)+": "+err.Error())
+		}
+	}
+
+	g, err := oco.CreateOCO(legs[0], legs[1])
+	if err != nil {
+		return orderGroupError(c, g, err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status": "OCO order placed successfully",
+		"group":  newOrderGroupView(g),
+	})
+}
+
+// every leg of one of the user's order groups
+func GetOrderGroupHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	groupID, err := strconv.ParseUint(c.Param("groupId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid group id")
+	}
+
+	g, err := db.GetOrderGroup(userID, groupID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "Order group not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load order group")
+	}
+	return c.JSON(http.StatusOK, newOrderGroupView(g))
+}
@@ -1,8 +1,13 @@
 package handlers
 
 import (
-	"jotacomputing/go-api/queue"
+	"errors"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/oms"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/stops"
 	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
 	"net/http"
 	"strconv"
 
@@ -30,32 +35,60 @@ func PostOrderHandler(c echo.Context) error {
 		return c.JSON(400, map[string]string{"error": "Invalid request body"})
 	}
 
-	// Validate order fields
-	if err := tempOrder.Validate(); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	// stop orders stay in the gateway trigger book until the last price crosses them
+	if tempOrder.IsStop() {
+		return postStopOrder(c, userID, &tempOrder)
 	}
 
+	// Validate order fields and convert decimal price/qty into engine ticks
 	// Create order with AUTHENTICATED user_id (secure - from token, not request!)
-	var order structs.Order
-	order.Order_id = tempOrder.Order_id
-	order.Price = tempOrder.Price
-	order.Timestamp = tempOrder.Timestamp
-	order.User_id = userID
-	order.Shares_qty = tempOrder.Shares_qty
-	order.Symbol = tempOrder.Symbol
-	order.Side = tempOrder.Side
-	order.Order_type = tempOrder.Order_type
-	order.Status = 0 // pending
+	order, err := tempOrder.ToOrder(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	spec := symbols.Lookup(order.Symbol)
 
 	// Enqueue the order
-	if err := queue.IncomingOrderQueue.Enqueue(order); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to enqueue order")
+	if err := oms.Submit(order); err != nil {
+		return submitError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":        "Order placed successfully",
+		"order_id":      order.Order_id,
+		"user_id":       userID,
+		"symbol":        order.Symbol,
+		"price":         decimal.Format(order.Price, spec.PriceScale),
+		"quantity":      decimal.Format(uint64(order.Shares_qty), spec.QtyScale),
+		"time_in_force": order.Time_in_force,
+	})
+}
+
+func postStopOrder(c echo.Context, userID uint64, tempOrder *structs.TempOrder) error {
+	stop, err := tempOrder.ToStopOrder(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if r := stops.Check(stop); r != nil {
+		return c.JSON(http.StatusUnprocessableEntity, r)
+	}
+
+	if err := stops.Add(stop); err != nil {
+		var rejection *risk.Rejection
+		if errors.As(err, &rejection) {
+			return c.JSON(http.StatusUnprocessableEntity, rejection)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to store stop order")
 	}
 
+	view := newStopOrderView(stop)
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"status":   "Order placed successfully",
-		"order_id": order.Order_id,
-		"user_id":  userID,
-		"symbol":   order.Symbol,
+		"status":     "Stop order accepted",
+		"order_id":   view.Order_id,
+		"user_id":    userID,
+		"symbol":     view.Symbol,
+		"stop_price": view.Stop_price,
 	})
 }
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// status filter names of GET /api/orders and the engine statuses behind them
+var statusFilters = map[string][]uint8{
+	"open":      {structs.StatusPending, structs.StatusPartiallyFilled},
+	"filled":    {structs.StatusFilled},
+	"cancelled": {structs.StatusCancelled},
+	"rejected":  {structs.StatusRejected},
+}
+
+func statusName(status uint8) string {
+	switch status {
+	case structs.StatusPending:
+		return "open"
+	case structs.StatusPartiallyFilled:
+		return "partially_filled"
+	case structs.StatusFilled:
+		return "filled"
+	case structs.StatusRejected:
+		return "rejected"
+	case structs.StatusCancelled:
+		return "cancelled"
+	case structs.StatusReplaced:
+		return "replaced"
+	case structs.StatusReplaceRejected:
+		return "replace_rejected"
+	}
+	return "unknown"
+}
+
+type orderView struct {
+	Order_id      uint64 `json:"order_id"`
+	Symbol        uint32 `json:"symbol"`
+	Side          uint8  `json:"side"`
+	Order_type    uint8  `json:"order_type"`
+	Price         string `json:"price"`
+	Shares_qty    string `json:"shares_qty"`
+	Display_qty   string `json:"display_qty,omitempty"`
+	Time_in_force uint8  `json:"time_in_force"`
+	Expire_at     uint64 `json:"expire_at,omitempty"`
+	Stp_mode      uint8  `json:"stp_mode,omitempty"`
+	Stp_group     uint64 `json:"stp_group,omitempty"`
+	Short_sale    bool   `json:"short_sale,omitempty"`
+	Timestamp     uint64 `json:"timestamp"`
+	Status        string `json:"status"`
+	Reason        string `json:"reason,omitempty"`
+	Created_at    string `json:"created_at"`
+	Updated_at    string `json:"updated_at"`
+}
+
+func formatNanos(ns int64) string {
+	return time.Unix(0, ns).UTC().Format(time.RFC3339Nano)
+}
+
+func newOrderView(r db.OrderRecord) orderView {
+	spec := symbols.Lookup(r.Symbol)
+	v := orderView{
+		Order_id:      r.Order_id,
+		Symbol:        r.Symbol,
+		Side:          r.Side,
+		Order_type:    r.Order_type,
+		Price:         decimal.Format(r.Price, spec.PriceScale),
+		Shares_qty:    decimal.Format(uint64(r.Shares_qty), spec.QtyScale),
+		Time_in_force: r.Time_in_force,
+		Expire_at:     r.Expire_at,
+		Stp_mode:      r.Stp_mode,
+		Stp_group:     r.Stp_group,
+		Short_sale:    r.Short_sale != 0,
+		Timestamp:     r.Timestamp,
+		Status:        statusName(r.Status),
+		Reason:        r.Reason,
+		Created_at:    formatNanos(r.Created_at),
+		Updated_at:    formatNanos(r.Updated_at),
+	}
+	if r.Display_qty != 0 {
+		v.Display_qty = decimal.Format(uint64(r.Display_qty), spec.QtyScale)
+	}
+	return v
+}
+
+// cursors are opaque to clients: the sort key of the last row of a page
+func encodeCursor(createdAt int64, orderID uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", createdAt, orderID)))
+}
+
+func decodeCursor(cursor string) (int64, uint64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+	created, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("malformed cursor")
+	}
+	createdAt, err := strconv.ParseInt(created, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	orderID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return createdAt, orderID, nil
+}
+
+func parseTimeParam(c echo.Context, name string) (int64, error) {
+	s := c.QueryParam(name)
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, name+" must be an RFC3339 time")
+	}
+	return t.UnixNano(), nil
+}
+
+// lists the user's orders, newest first.
+// filters: status (open, filled, cancelled, rejected, comma separated), symbol,
+// side, from/to (RFC3339). paginate with limit and the returned next_cursor.
+func GetOrdersHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	q := db.OrderQuery{User_id: userID, Limit: defaultPageSize}
+
+	if s := c.QueryParam("status"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			statuses, ok := statusFilters[strings.TrimSpace(name)]
+			if !ok {
+				return echo.NewHTTPError(http.StatusBadRequest, "status must be open, filled, cancelled or rejected")
+			}
+			q.Statuses = append(q.Statuses, statuses...)
+		}
+	}
+
+	if q.Symbol, q.Side, err = parseSymbolSide(c); err != nil {
+		return err
+	}
+
+	if q.From, err = parseTimeParam(c, "from"); err != nil {
+		return err
+	}
+	if q.To, err = parseTimeParam(c, "to"); err != nil {
+		return err
+	}
+
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxPageSize {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+		}
+		q.Limit = n
+	}
+	if s := c.QueryParam("cursor"); s != "" {
+		if q.After_created, q.After_order_id, err = decodeCursor(s); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+		}
+	}
+
+	records, err := db.ListOrders(q)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load orders")
+	}
+
+	views := make([]orderView, 0, len(records))
+	for _, r := range records {
+		views = append(views, newOrderView(r))
+	}
+	nextCursor := ""
+	if len(records) == q.Limit {
+		last := records[len(records)-1]
+		nextCursor = encodeCursor(last.Created_at, last.Order_id)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user_id":     userID,
+		"orders":      views,
+		"next_cursor": nextCursor,
+	})
+}
+
+type orderEventView struct {
+	Event      string `json:"event"`
+	Status     string `json:"status,omitempty"`
+	Price      string `json:"price,omitempty"`
+	Shares_qty string `json:"shares_qty,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Created_at string `json:"created_at"`
+}
+
+// full timeline of one of the user's orders
+func GetOrderEventsHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	orderID, err := strconv.ParseUint(c.Param("orderId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid order id")
+	}
+
+	record, err := db.GetOrder(userID, orderID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "Order not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load order")
+	}
+	events, err := db.ListOrderEvents(userID, orderID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load order events")
+	}
+
+	spec := symbols.Lookup(record.Symbol)
+	views := make([]orderEventView, 0, len(events))
+	for _, e := range events {
+		v := orderEventView{Event: e.Event, Reason: e.Reason, Created_at: formatNanos(e.Created_at)}
+		if e.Event == db.EventStatus || e.Event == db.EventRejected {
+			v.Status = statusName(e.Status)
+		}
+		if e.Price != 0 {
+			v.Price = decimal.Format(e.Price, spec.PriceScale)
+		}
+		if e.Shares_qty != 0 {
+			v.Shares_qty = decimal.Format(uint64(e.Shares_qty), spec.QtyScale)
+		}
+		views = append(views, v)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"order":  newOrderView(*record),
+		"events": views,
+	})
+}
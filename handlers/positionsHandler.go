@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/positions"
+	"jotacomputing/go-api/symbols"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type positionView struct {
+	Symbol    uint32 `json:"symbol"`
+	Qty       string `json:"qty"`
+	Reserved  string `json:"reserved"`
+	Available string `json:"available"`
+}
+
+// the gateway's cached holdings with what open sell orders tie up, a fresh
+// snapshot is requested from the engine if there is none yet
+func GetPositionsHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	list, ok := positions.Of(userID)
+	if !ok {
+		if err := positions.Request(userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to enqueue holdings query")
+		}
+		return c.JSON(http.StatusAccepted, map[string]interface{}{
+			"status":  "Holdings requested, try again shortly",
+			"user_id": userID,
+		})
+	}
+
+	views := make([]positionView, 0, len(list))
+	for _, p := range list {
+		spec := symbols.Lookup(p.Symbol)
+		views = append(views, positionView{
+			Symbol:    p.Symbol,
+			Qty:       decimal.FormatSigned(p.Qty, spec.QtyScale),
+			Reserved:  decimal.Format(p.Reserved, spec.QtyScale),
+			Available: decimal.FormatSigned(p.Available, spec.QtyScale),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user_id":   userID,
+		"positions": views,
+	})
+}
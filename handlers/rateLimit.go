@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"jotacomputing/go-api/ratelimit"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RateLimit throttles the authenticated user per endpoint class. it has to
+// run after the token middleware.
+func RateLimit(class ratelimit.Class) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, err := userIDFromToken(c)
+			if err != nil {
+				return err
+			}
+
+			d := ratelimit.Allow(userID, class)
+			if !d.Allowed {
+				return rateLimited(c, d)
+			}
+			setRateHeaders(c, d)
+			return next(c)
+		}
+	}
+}
+
+// RateLimitIP throttles a route that runs without a token by client ip
+func RateLimitIP(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d := ratelimit.AllowIP(c.RealIP())
+		if !d.Allowed {
+			return rateLimited(c, d)
+		}
+		setRateHeaders(c, d)
+		return next(c)
+	}
+}
+
+func setRateHeaders(c echo.Context, d ratelimit.Decision) {
+	h := c.Response().Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(d.Reset.Seconds()))))
+}
+
+// rateLimited answers a request the limiter refused
+func rateLimited(c echo.Context, d ratelimit.Decision) error {
+	setRateHeaders(c, d)
+	retry := int(math.Ceil(d.RetryAfter.Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(retry))
+	return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+		"error":          d.Reason,
+		"retry_after_ms": d.RetryAfter.Milliseconds(),
+	})
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/oms"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+	"jotacomputing/go-api/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// amends price and/or quantity of a resting order with a single replace message
+func ReplaceOrderHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	orderID, err := strconv.ParseUint(c.Param("orderId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid order id")
+	}
+
+	var tempReplace structs.TempOrderReplace
+	if err := c.Bind(&tempReplace); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+
+	current, ok := orders.Get(userID, orderID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Order not found or no longer open")
+	}
+
+	replace, err := tempReplace.ToReplace(current)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	accepted, update, err := oms.Replace(replace, utils.AmendAckTimeoutMs*time.Millisecond)
+	if errors.Is(err, oms.ErrReplaceTimeout) {
+		return c.JSON(http.StatusAccepted, map[string]interface{}{
+			"status":   "Amend sent, engine has not answered yet",
+			"order_id": orderID,
+			"user_id":  userID,
+		})
+	}
+	if errors.Is(err, oms.ErrNotOpen) {
+		return echo.NewHTTPError(http.StatusNotFound, "Order not found or no longer open")
+	}
+	var rejection *risk.Rejection
+	if errors.As(err, &rejection) {
+		return c.JSON(http.StatusUnprocessableEntity, rejection)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to enqueue amend")
+	}
+
+	if !accepted {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"status":        "Amend rejected by engine",
+			"accepted":      false,
+			"order_id":      orderID,
+			"user_id":       userID,
+			"engine_status": update.Status,
+		})
+	}
+
+	spec := symbols.Lookup(current.Symbol)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":        "Order amended",
+		"accepted":      true,
+		"order_id":      orderID,
+		"user_id":       userID,
+		"symbol":        current.Symbol,
+		"price":         decimal.Format(update.Price, spec.PriceScale),
+		"quantity":      decimal.Format(uint64(update.Shares_qty), spec.QtyScale),
+		"kept_priority": replace.Keep_priority == 1,
+	})
+}
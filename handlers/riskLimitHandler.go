@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/risk"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// lists every risk limit override, user_id / symbol 0 apply to everyone
+func GetRiskLimitsHandler(c echo.Context) error {
+	limits, err := db.ListRiskLimits()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load risk limits")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"limits": limits})
+}
+
+// sets one override, PUT /api/admin/risk/limits. it applies to the next order.
+func PutRiskLimitHandler(c echo.Context) error {
+	var req db.RiskLimit
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+
+	err := risk.SetLimit(req.User_id, req.Symbol, req.Name, req.Value)
+	if errors.Is(err, risk.ErrInvalidLimit) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to store risk limit")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status": "Risk limit updated",
+		"limit":  req,
+	})
+}
+
+// drops one override, DELETE /api/admin/risk/limits/:userId/:symbol/:name
+func DeleteRiskLimitHandler(c echo.Context) error {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user id")
+	}
+	symbol, err := strconv.ParseUint(c.Param("symbol"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid symbol")
+	}
+	name := c.Param("name")
+
+	err = risk.DeleteLimit(userID, uint32(symbol), name)
+	if errors.Is(err, risk.ErrInvalidLimit) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete risk limit")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":  "Risk limit removed",
+		"user_id": userID,
+		"symbol":  symbol,
+		"name":    name,
+	})
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"database/sql"
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/schedules"
+	"jotacomputing/go-api/symbols"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// how many past runs GET /schedules/:scheduleId returns
+const scheduleRunsLimit = 50
+
+type tempSchedule struct {
+	Name          string         `json:"name"`
+	Cron          string         `json:"cron"`     // minute hour day-of-month month day-of-week
+	Timezone      string         `json:"timezone"` // IANA name, defaults to the symbol's exchange
+	Symbol        uint32         `json:"symbol"`
+	Side          uint8          `json:"side"`
+	Order_type    uint8          `json:"order_type"` // 0=market 1=limit
+	Price         decimal.Value  `json:"price"`
+	Shares_qty    decimal.Value  `json:"shares_qty"` // either shares_qty or notional
+	Notional      decimal.Amount `json:"notional"`
+	Time_in_force uint8          `json:"time_in_force"`
+	Enabled       *bool          `json:"enabled"` // defaults to true
+}
+
+type scheduleView struct {
+	Schedule_id   uint64         `json:"schedule_id"`
+	Name          string         `json:"name"`
+	Cron          string         `json:"cron"`
+	Timezone      string         `json:"timezone"`
+	Symbol        uint32         `json:"symbol"`
+	Side          uint8          `json:"side"`
+	Order_type    uint8          `json:"order_type"`
+	Price         string         `json:"price,omitempty"`
+	Shares_qty    string         `json:"shares_qty,omitempty"`
+	Notional      decimal.Amount `json:"notional,omitempty"`
+	Time_in_force uint8          `json:"time_in_force"`
+	Enabled       bool           `json:"enabled"`
+	Next_run      string         `json:"next_run,omitempty"`
+	Last_run      string         `json:"last_run,omitempty"`
+	Created_at    string         `json:"created_at"`
+	Updated_at    string         `json:"updated_at"`
+}
+
+type scheduleRunView struct {
+	Run_id     uint64 `json:"run_id"`
+	Due_at     string `json:"due_at"`
+	Order_id   uint64 `json:"order_id,omitempty"`
+	Outcome    string `json:"outcome"`
+	Reason     string `json:"reason,omitempty"`
+	Created_at string `json:"created_at"`
+}
+
+func newScheduleView(s *db.Schedule) scheduleView {
+	spec := symbols.Lookup(s.Symbol)
+	v := scheduleView{
+		Schedule_id:   s.ID,
+		Name:          s.Name,
+		Cron:          s.Cron,
+		Timezone:      s.Timezone,
+		Symbol:        s.Symbol,
+		Side:          s.Side,
+		Order_type:    s.Order_type,
+		Notional:      s.Notional,
+		Time_in_force: s.Time_in_force,
+		Enabled:       s.Enabled,
+		Created_at:    formatNanos(s.Created_at),
+		Updated_at:    formatNanos(s.Updated_at),
+	}
+	if s.Price != 0 {
+		v.Price = decimal.Format(s.Price, spec.PriceScale)
+	}
+	if s.Shares_qty != 0 {
+		v.Shares_qty = decimal.Format(s.Shares_qty, spec.QtyScale)
+	}
+	if s.Enabled {
+		v.Next_run = formatNanos(s.Next_run)
+	}
+	if s.Last_run != 0 {
+		v.Last_run = formatNanos(s.Last_run)
+	}
+	return v
+}
+
+// toSchedule turns the request into a validated schedule with its next run
+// computed from now
+func (req tempSchedule) toSchedule(userID uint64) (*db.Schedule, error) {
+	spec := symbols.Lookup(req.Symbol)
+
+	s := &db.Schedule{
+		User_id:       userID,
+		Name:          req.Name,
+		Cron:          req.Cron,
+		Timezone:      req.Timezone,
+		Symbol:        req.Symbol,
+		Side:          req.Side,
+		Order_type:    req.Order_type,
+		Notional:      req.Notional,
+		Time_in_force: req.Time_in_force,
+		Enabled:       req.Enabled == nil || *req.Enabled,
+	}
+	var err error
+	if !req.Price.IsZero() {
+		if s.Price, err = req.Price.Ticks(spec.PriceScale); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "price: "+err.Error())
+		}
+	}
+	if !req.Shares_qty.IsZero() {
+		if s.Shares_qty, err = req.Shares_qty.Ticks(spec.QtyScale); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "shares_qty: "+err.Error())
+		}
+	}
+	if err := schedules.Prepare(s, time.Now()); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return s, nil
+}
+
+func parseScheduleID(c echo.Context) (uint64, error) {
+	id, err := strconv.ParseUint(c.Param("scheduleId"), 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid schedule id")
+	}
+	return id, nil
+}
+
+// stores an order template that is sent every time its cron expression matches
+func PostScheduleHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+	var req tempSchedule
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	s, err := req.toSchedule(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := db.CreateSchedule(s); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create schedule")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":   "Schedule created",
+		"schedule": newScheduleView(s),
+	})
+}
+
+func GetSchedulesHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	list, err := db.ListSchedules(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load schedules")
+	}
+	views := make([]scheduleView, 0, len(list))
+	for _, s := range list {
+		views = append(views, newScheduleView(s))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user_id":   userID,
+		"schedules": views,
+	})
+}
+
+// one schedule with the outcome of its latest runs
+func GetScheduleHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+	scheduleID, err := parseScheduleID(c)
+	if err != nil {
+		return err
+	}
+
+	s, err := db.GetSchedule(userID, scheduleID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "Schedule not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load schedule")
+	}
+	runs, err := db.ListScheduleRuns(scheduleID, scheduleRunsLimit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load schedule runs")
+	}
+
+	views := make([]scheduleRunView, 0, len(runs))
+	for _, r := range runs {
+		views = append(views, scheduleRunView{
+			Run_id:     r.ID,
+			Due_at:     formatNanos(r.Due_at),
+			Order_id:   r.Order_id,
+			Outcome:    r.Outcome,
+			Reason:     r.Reason,
+			Created_at: formatNanos(r.Created_at),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"schedule": newScheduleView(s),
+		"runs":     views,
+	})
+}
+
+// replaces a schedule, the next run is computed again from now so runs that
+// were due while it was disabled are not sent
+func PutScheduleHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+	scheduleID, err := parseScheduleID(c)
+	if err != nil {
+		return err
+	}
+	var req tempSchedule
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	s, err := req.toSchedule(userID)
+	if err != nil {
+		return err
+	}
+
+	s.ID = scheduleID
+	found, err := db.UpdateSchedule(s)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update schedule")
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, "Schedule not found")
+	}
+	// reload for created_at and last_run
+	if s, err = db.GetSchedule(userID, scheduleID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load schedule")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":   "Schedule updated",
+		"schedule": newScheduleView(s),
+	})
+}
+
+// deletes a schedule, orders it already sent are left alone
+func DeleteScheduleHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+	scheduleID, err := parseScheduleID(c)
+	if err != nil {
+		return err
+	}
+
+	found, err := db.DeleteSchedule(userID, scheduleID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete schedule")
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, "Schedule not found")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":      "Schedule deleted",
+		"schedule_id": scheduleID,
+	})
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"jotacomputing/go-api/auth"
+	"net/http"
+	"strconv"
+
+	echoserver "github.com/dasjott/oauth2-echo-server"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/server"
+	"github.com/labstack/echo/v4"
+)
+
+// RequireScope only lets tokens holding scope through. it has to run after
+// the token middleware.
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ti, exists := c.Get(echoserver.DefaultConfig.TokenKey).(oauth2.TokenInfo)
+			if !exists {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or missing token")
+			}
+			if !auth.HasScope(ti.GetScope(), scope) {
+				return echo.NewHTTPError(http.StatusForbidden, "Token lacks scope "+scope)
+			}
+			return next(c)
+		}
+	}
+}
+
+// IntrospectHandler answers RFC 7662 token introspection, POST
+// /oauth2/introspect with the token in the form. the caller authenticates as
+// a client (basic auth or client_id/client_secret) and only sees tokens issued
+// to that client, anything else is reported inactive.
+func IntrospectHandler(manager oauth2.Manager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r := c.Request()
+		if err := r.ParseForm(); err != nil {
+			return c.JSON(400, map[string]string{"error": "Invalid request body"})
+		}
+		clientID, secret, err := server.ClientBasicHandler(r)
+		if err != nil {
+			clientID, secret, err = server.ClientFormHandler(r)
+		}
+		if err != nil || !auth.VerifyClient(clientID, secret) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid client")
+		}
+
+		inactive := map[string]interface{}{"active": false}
+		token := r.FormValue("token")
+		if token == "" {
+			return c.JSON(http.StatusOK, inactive)
+		}
+		ti, err := manager.LoadAccessToken(r.Context(), token)
+		if err != nil || ti.GetClientID() != clientID {
+			return c.JSON(http.StatusOK, inactive)
+		}
+		userID, err := strconv.ParseUint(ti.GetUserID(), 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusOK, inactive)
+		}
+		if revoked, err := auth.TokenRevoked(userID, ti.GetAccessCreateAt()); err != nil || revoked {
+			return c.JSON(http.StatusOK, inactive)
+		}
+
+		issued := ti.GetAccessCreateAt()
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"active":     true,
+			"scope":      ti.GetScope(),
+			"client_id":  ti.GetClientID(),
+			"sub":        ti.GetUserID(),
+			"token_type": "Bearer",
+			"iat":        issued.Unix(),
+			"exp":        issued.Add(ti.GetAccessExpiresIn()).Unix(),
+		})
+	}
+}
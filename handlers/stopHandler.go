@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/stops"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type stopOrderView struct {
+	Order_id   uint64 `json:"order_id"`
+	Symbol     uint32 `json:"symbol"`
+	Side       uint8  `json:"side"`
+	Order_type uint8  `json:"order_type"`
+	Price      string `json:"price"`
+	Stop_price string `json:"stop_price"`
+	Shares_qty string `json:"shares_qty"`
+	Timestamp  uint64 `json:"timestamp"`
+}
+
+func newStopOrderView(stop structs.StopOrder) stopOrderView {
+	spec := symbols.Lookup(stop.Order.Symbol)
+	return stopOrderView{
+		Order_id:   stop.Order.Order_id,
+		Symbol:     stop.Order.Symbol,
+		Side:       stop.Order.Side,
+		Order_type: stop.Order.Order_type,
+		Price:      decimal.Format(stop.Order.Price, spec.PriceScale),
+		Stop_price: decimal.Format(stop.Stop_price, spec.PriceScale),
+		Shares_qty: decimal.Format(uint64(stop.Order.Shares_qty), spec.QtyScale),
+		Timestamp:  stop.Order.Timestamp,
+	}
+}
+
+// lists the stop orders the gateway is still holding for the user
+func GetStopOrdersHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	armed, err := db.ListArmedStopOrders(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load stop orders")
+	}
+	views := make([]stopOrderView, 0, len(armed))
+	for _, stop := range armed {
+		views = append(views, newStopOrderView(stop))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"stops":   views,
+	})
+}
+
+// disarms a stop order before it triggers, nothing is sent to the engine
+func CancelStopOrderHandler(c echo.Context) error {
+	userID, err := userIDFromToken(c)
+	if err != nil {
+		return err
+	}
+
+	orderID, err := strconv.ParseUint(c.Param("orderId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid order id")
+	}
+
+	stop, err := stops.Cancel(userID, orderID)
+	if errors.Is(err, stops.ErrNotFound) || errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Stop order not found or already triggered")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to cancel stop order")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":   "Stop order cancelled",
+		"order_id": stop.Order.Order_id,
+		"user_id":  userID,
+		"symbol":   stop.Order.Symbol,
+	})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"jotacomputing/go-api/calendar"
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/symbols"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type tempSymbol struct {
+	Name       string `json:"name"`
+	PriceScale uint8  `json:"price_scale"`
+	QtyScale   uint8  `json:"qty_scale"`
+	Group      string `json:"group"`
+	LotSize    uint64 `json:"lot_size"`
+}
+
+// sets a symbol's scales, calendar group and lot size, PUT /api/admin/symbols/:symbol.
+// the scales are fixed once the symbol has orders.
+func PutSymbolHandler(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("symbol"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid symbol")
+	}
+	var req tempSymbol
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "name is required")
+	}
+	if req.Group != "" {
+		known := false
+		for _, g := range calendar.Groups() {
+			known = known || g == req.Group
+		}
+		if !known {
+			return echo.NewHTTPError(http.StatusBadRequest, "unknown calendar group")
+		}
+	}
+
+	spec := symbols.Spec{
+		ID: uint32(id), Name: req.Name, PriceScale: req.PriceScale, QtyScale: req.QtyScale,
+		Group: req.Group, LotSize: req.LotSize,
+	}
+	err = db.UpsertSymbol(spec)
+	if errors.Is(err, db.ErrInvalidSymbol) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, db.ErrScaleInUse) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to store symbol")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status": "Symbol updated",
+		"symbol": symbols.Lookup(uint32(id)),
+	})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"jotacomputing/go-api/db"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type tempUserShort struct {
+	Can_short bool `json:"can_short"`
+}
+
+// allows or stops short selling on an account, PUT /api/admin/users/:userId/short.
+// open short positions are left alone, new sells beyond the holdings are
+// refused once it is off.
+func PutUserShortHandler(c echo.Context) error {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user id")
+	}
+	var req tempUserShort
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+
+	found, err := db.SetUserCanShort(userID, req.Can_short)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update account")
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, "No such user")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":    "Short selling updated",
+		"user_id":   userID,
+		"can_short": req.Can_short,
+	})
+}
+
+type tempUserTier struct {
+	Tier string `json:"tier"`
+}
+
+// moves an account to another tier, PUT /api/admin/users/:userId/tier. the
+// tier sets rate limits and fees, the rate limiter picks it up within a minute.
+func PutUserTierHandler(c echo.Context) error {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user id")
+	}
+	var req tempUserTier
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+
+	tiers, err := db.ListRateTiers()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load tiers")
+	}
+	known := false
+	for _, t := range tiers {
+		if t.Tier == req.Tier {
+			known = true
+		}
+	}
+	if !known {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unknown tier")
+	}
+
+	found, err := db.SetUserTier(userID, req.Tier)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update account")
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, "No such user")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":  "Tier updated",
+		"user_id": userID,
+		"tier":    req.Tier,
+	})
+}
+
+type tempUserStpGroup struct {
+	Stp_group uint64 `json:"stp_group"`
+}
+
+// puts an account in a firm wide self-trade prevention group,
+// PUT /api/admin/users/:userId/stp-group. 0 takes it out again. orders
+// already working keep the group they were sent with.
+func PutUserStpGroupHandler(c echo.Context) error {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user id")
+	}
+	var req tempUserStpGroup
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "Invalid request body"})
+	}
+
+	found, err := db.SetUserStpGroup(userID, req.Stp_group)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update account")
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, "No such user")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":    "STP group updated",
+		"user_id":   userID,
+		"stp_group": req.Stp_group,
+	})
+}
@@ -0,0 +1,130 @@
+package ledger
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/margin"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+)
+
+// an amend that raises what an order needs reserves the difference before the
+// replace goes out and gives it back if the engine rejects it. one that lowers
+// it keeps the old amount until the engine confirms.
+
+type amendKey struct {
+	user, order uint64
+}
+
+type pendingAmend struct {
+	extra  decimal.Amount // reserved up front, released on a reject
+	shrink decimal.Amount // released once the amend is accepted
+}
+
+var (
+	amendMu sync.Mutex
+	amends  = make(map[amendKey]pendingAmend)
+)
+
+// ReserveAmend resizes the reservation of current to what amended needs. a
+// raise that isn't covered comes back as a *risk.Rejection, so does an amend
+// while the engine hasn't answered the last one: the tracked order and the
+// reservation would be out of step.
+func ReserveAmend(current, amended structs.Order) error {
+	key := amendKey{current.User_id, current.Order_id}
+	amendMu.Lock()
+	_, pending := amends[key]
+	amendMu.Unlock()
+	if pending {
+		return &risk.Rejection{Code: risk.ReasonAmendPending, Message: "the previous amend of this order is still pending"}
+	}
+
+	need, err := Requirement(amended)
+	if err != nil {
+		return err
+	}
+	held, err := db.ReservedFor(current.User_id, current.Order_id)
+	if err != nil {
+		return err
+	}
+
+	if need <= held {
+		amendMu.Lock()
+		amends[key] = pendingAmend{shrink: held - need}
+		amendMu.Unlock()
+		return nil
+	}
+
+	extra := need - held
+	reserveMu.Lock()
+	defer reserveMu.Unlock()
+	if margin.Enabled(current.User_id) {
+		summary, ok, err := margin.Compute(current.User_id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &risk.Rejection{Code: risk.ReasonPositionUnknown, Message: "holdings not available yet, try again"}
+		}
+		err = db.GrowMarginReservation(current.User_id, current.Order_id, extra, summary.Excess())
+		if errors.Is(err, db.ErrInsufficientFunds) {
+			_, reserved, _ := db.AvailableBalance(current.User_id)
+			return &risk.Rejection{
+				Code:    risk.ReasonInsufficientFunds,
+				Message: "amend needs " + extra.String() + " more initial margin, excess equity " + (summary.Excess() - reserved).String(),
+			}
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		err = db.GrowReservation(current.User_id, current.Order_id, extra)
+		if errors.Is(err, db.ErrInsufficientFunds) {
+			balance, reserved, _ := db.AvailableBalance(current.User_id)
+			return &risk.Rejection{
+				Code:    risk.ReasonInsufficientFunds,
+				Message: "amend needs " + extra.String() + " more, available " + (balance - reserved).String(),
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	amendMu.Lock()
+	amends[key] = pendingAmend{extra: extra}
+	amendMu.Unlock()
+	return nil
+}
+
+// CancelAmend undoes ReserveAmend for an amend that never reached the engine
+func CancelAmend(order structs.Order) {
+	settleAmend(order.User_id, order.Order_id, false)
+}
+
+// settleAmend applies the engine's answer to a pending amend
+func settleAmend(userID, orderID uint64, accepted bool) {
+	key := amendKey{userID, orderID}
+	amendMu.Lock()
+	a, ok := amends[key]
+	delete(amends, key)
+	amendMu.Unlock()
+	if !ok {
+		return
+	}
+
+	release := a.extra
+	if accepted {
+		release = a.shrink
+	}
+	if release == 0 {
+		return
+	}
+	if err := db.ShrinkReservation(userID, orderID, release); err != nil {
+		log.Printf("ledger: failed to resize reservation of order %d of user %d after amend: %v", orderID, userID, err)
+	}
+}
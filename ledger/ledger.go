@@ -0,0 +1,214 @@
+package ledger
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/feeds"
+	"jotacomputing/go-api/fees"
+	"jotacomputing/go-api/margin"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+	"jotacomputing/go-api/utils"
+)
+
+// buy orders hold price x quantity of the user's balance from submission until
+// the engine is done with them, so the same cash can't back two orders.
+
+// serializes the check-and-reserve so two concurrent orders can't both pass
+var reserveMu sync.Mutex
+
+// the status and fill rings are read independently, so a Filled status can
+// overtake the last fills of its order. the reservation is then released once
+// those fills are booked, or after closeWait in case they never show up.
+const closeWait = 5 * time.Second
+
+var (
+	closeMu sync.Mutex
+	closing = make(map[orders.Key]uint32) // quantity still to be booked
+)
+
+// Requirement is what an order has to reserve: the full cost of a buy on a
+// cash account, fees at their worst included, the initial margin of what it
+// opens (buys and short sales) on a margin account. market orders are valued
+// at the last trade plus a collar since the fill price isn't known yet.
+func Requirement(order structs.Order) (decimal.Amount, error) {
+	onMargin := margin.Enabled(order.User_id)
+	if order.Side != 0 && !(onMargin && order.Short_sale != 0) {
+		return 0, nil
+	}
+	price := order.Price
+	if order.Order_type == structs.OrderTypeMarket {
+		last, ok := feeds.LastPrice(order.Symbol)
+		if !ok {
+			return 0, &risk.Rejection{Code: risk.ReasonNoReferencePrice, Message: "no last trade price to reserve funds for market order"}
+		}
+		price = last + last*utils.MarketBuyCollarBps/10000
+	}
+	spec := symbols.Lookup(order.Symbol)
+	notional, err := decimal.Notional(price, spec.PriceScale, uint64(order.Shares_qty), spec.QtyScale, true)
+	if err != nil {
+		return 0, err
+	}
+	if onMargin {
+		return margin.Initial(notional, order.Symbol), nil
+	}
+	fee, err := fees.Worst(order.User_id, notional)
+	if err != nil {
+		return 0, err
+	}
+	if fee > decimal.MaxAmount-notional {
+		return 0, decimal.ErrRange
+	}
+	return notional + fee, nil
+}
+
+// Reserve holds the funds for a buy order, sells pass straight through unless
+// they open a short on a margin account. an uncovered order comes back as a
+// *risk.Rejection.
+func Reserve(order structs.Order) error {
+	amount, err := Requirement(order)
+	if err != nil || amount == 0 {
+		return err
+	}
+
+	reserveMu.Lock()
+	defer reserveMu.Unlock()
+	if margin.Enabled(order.User_id) {
+		return reserveMargin(order, amount)
+	}
+	err = db.ReserveFunds(order.User_id, order.Order_id, amount)
+	if errors.Is(err, db.ErrInsufficientFunds) {
+		balance, reserved, _ := db.AvailableBalance(order.User_id)
+		return &risk.Rejection{
+			Code:    risk.ReasonInsufficientFunds,
+			Message: "order needs " + amount.String() + ", available " + (balance - reserved).String(),
+		}
+	}
+	return err
+}
+
+// reserveMargin holds initial margin out of the account's excess equity,
+// reserveMu must be held
+func reserveMargin(order structs.Order, amount decimal.Amount) error {
+	summary, ok, err := margin.Compute(order.User_id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &risk.Rejection{Code: risk.ReasonPositionUnknown, Message: "holdings not available yet, try again"}
+	}
+	err = db.ReserveMargin(order.User_id, order.Order_id, amount, summary.Excess())
+	if errors.Is(err, db.ErrInsufficientFunds) {
+		_, reserved, _ := db.AvailableBalance(order.User_id)
+		return &risk.Rejection{
+			Code:    risk.ReasonInsufficientFunds,
+			Message: "order needs " + amount.String() + " initial margin, excess equity " + (summary.Excess() - reserved).String(),
+		}
+	}
+	return err
+}
+
+// Release drops the reservation of an order that never reached the engine
+func Release(order structs.Order) {
+	if err := db.ReleaseReservation(order.User_id, order.Order_id); err != nil {
+		log.Printf("ledger: failed to release order %d of user %d: %v", order.Order_id, order.User_id, err)
+	}
+}
+
+// OnStatus frees what is left of a reservation once the engine is done with
+// the order and settles pending amends, registered with feeds.OnStatus
+func OnStatus(update structs.Order) {
+	switch {
+	case update.Status == structs.StatusReplaced:
+		settleAmend(update.User_id, update.Order_id, true)
+		return
+	case update.Status == structs.StatusReplaceRejected:
+		settleAmend(update.User_id, update.Order_id, false)
+		return
+	case !update.IsTerminal():
+		return
+	}
+	amendMu.Lock()
+	delete(amends, amendKey{update.User_id, update.Order_id})
+	amendMu.Unlock()
+
+	// the tracker still holds what it hasn't seen fill, it takes the status
+	// after the ledger
+	key := orders.KeyOf(update)
+	if open, ok := orders.Get(update.User_id, update.Order_id); ok && update.Status == structs.StatusFilled && open.Shares_qty > 0 {
+		closeMu.Lock()
+		closing[key] = open.Shares_qty
+		closeMu.Unlock()
+		time.AfterFunc(closeWait, func() {
+			closeMu.Lock()
+			_, waiting := closing[key]
+			delete(closing, key)
+			closeMu.Unlock()
+			if waiting {
+				release(key)
+			}
+		})
+		return
+	}
+	release(key)
+}
+
+// booked counts a fill against an order waiting to be closed and releases
+// the rest of its reservation with the last one
+func booked(key orders.Key, qty uint32) {
+	closeMu.Lock()
+	left, ok := closing[key]
+	if ok && qty < left {
+		closing[key] = left - qty
+	} else {
+		delete(closing, key)
+	}
+	closeMu.Unlock()
+	if ok && qty >= left {
+		release(key)
+	}
+}
+
+func release(key orders.Key) {
+	if err := db.ReleaseReservation(key.User_id, key.Order_id); err != nil {
+		log.Printf("ledger: failed to release order %d of user %d: %v", key.Order_id, key.User_id, err)
+	}
+}
+
+// ApplyFill moves cash for both sides of an execution: the buyer spends out
+// of their reservation, the seller is credited the proceeds
+func ApplyFill(fill structs.Fill) {
+	spec := symbols.Lookup(fill.Symbol)
+	amount, err := decimal.Notional(fill.Price, spec.PriceScale, uint64(fill.Shares_qty), spec.QtyScale, false)
+	if err != nil {
+		log.Printf("ledger: fill %d notional out of range: %v", fill.Trade_id, err)
+		return
+	}
+	// a margin buyer borrows what the balance doesn't cover, its reservation
+	// was only the initial margin
+	if margin.Enabled(fill.Buy_user_id) {
+		err = db.SpendMarginReservation(fill.Buy_user_id, fill.Buy_order_id, amount, margin.Initial(amount, fill.Symbol))
+	} else {
+		err = db.SpendReservation(fill.Buy_user_id, fill.Buy_order_id, amount)
+	}
+	if err != nil {
+		log.Printf("ledger: failed to book fill %d for buyer %d: %v", fill.Trade_id, fill.Buy_user_id, err)
+	}
+	if err := db.CreditBalance(fill.Sell_user_id, fill.Sell_order_id, amount, db.LedgerCredit); err != nil {
+		log.Printf("ledger: failed to book fill %d for seller %d: %v", fill.Trade_id, fill.Sell_user_id, err)
+	}
+	if margin.Enabled(fill.Sell_user_id) {
+		if err := db.ShrinkReservation(fill.Sell_user_id, fill.Sell_order_id, margin.Initial(amount, fill.Symbol)); err != nil {
+			log.Printf("ledger: failed to book fill %d for seller %d: %v", fill.Trade_id, fill.Sell_user_id, err)
+		}
+	}
+	booked(orders.Key{User_id: fill.Buy_user_id, Order_id: fill.Buy_order_id}, fill.Shares_qty)
+	booked(orders.Key{User_id: fill.Sell_user_id, Order_id: fill.Sell_order_id}, fill.Shares_qty)
+}
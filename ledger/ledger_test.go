@@ -0,0 +1,173 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+)
+
+// newAccounts gives the test a fresh database with a buyer holding 1000.00
+// and a seller with nothing. no fee schedule is loaded, fees are zero.
+func newAccounts(t *testing.T) (buyer, seller uint64) {
+	t.Chdir(t.TempDir())
+	db.InitDB()
+	b, err := db.CreateUser("buyer", 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := db.CreateUser("seller", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.ID, s.ID
+}
+
+// a buy of qty at 10.00 on a symbol with the default spec
+func buy(user, id uint64, qty uint32) structs.Order {
+	return structs.Order{User_id: user, Order_id: id, Symbol: 1, Order_type: structs.OrderTypeLimit, Price: 1000, Shares_qty: qty, Timestamp: 1}
+}
+
+func fill(order structs.Order, seller uint64, trade uint64, qty uint32, price uint64) {
+	orders.ApplyFill(order.User_id, order.Order_id, qty)
+	ApplyFill(structs.Fill{Trade_id: trade, Buy_user_id: order.User_id, Buy_order_id: order.Order_id,
+		Sell_user_id: seller, Sell_order_id: 1, Symbol: order.Symbol, Price: price, Shares_qty: qty})
+}
+
+func status(order structs.Order, s uint8) {
+	update := order
+	update.Status = s
+	OnStatus(update)
+	orders.OnStatus(update)
+}
+
+func wantFunds(t *testing.T, step string, user uint64, balance, reserved decimal.Amount) {
+	t.Helper()
+	b, r, err := db.AvailableBalance(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != balance || r != reserved {
+		t.Errorf("%s: balance %s reserved %s, want %s and %s", step, b, r, balance, reserved)
+	}
+}
+
+func TestReserveSettle(t *testing.T) {
+	buyer, seller := newAccounts(t)
+
+	order := buy(buyer, 1, 10)
+	if err := Reserve(order); err != nil {
+		t.Fatal(err)
+	}
+	orders.Track(order)
+	wantFunds(t, "reserved", buyer, 100000, 10000)
+
+	// filled below the limit, only the fill price is spent
+	fill(order, seller, 1, 4, 950)
+	wantFunds(t, "partial fill", buyer, 96200, 6200)
+	wantFunds(t, "seller credited", seller, 3800, 0)
+	status(order, structs.StatusCancelled)
+	wantFunds(t, "cancelled", buyer, 96200, 0)
+
+	// the Filled status overtakes the last fill, the reservation waits for it
+	order = buy(buyer, 2, 10)
+	if err := Reserve(order); err != nil {
+		t.Fatal(err)
+	}
+	orders.Track(order)
+	fill(order, seller, 2, 6, 1000)
+	status(order, structs.StatusFilled)
+	wantFunds(t, "filled ahead of its fill", buyer, 90200, 4000)
+	fill(order, seller, 3, 4, 900)
+	wantFunds(t, "last fill booked", buyer, 86600, 0)
+
+	// a buy the balance doesn't cover, nothing is held
+	err := Reserve(buy(buyer, 3, 10000))
+	var r *risk.Rejection
+	if !errors.As(err, &r) || r.Code != risk.ReasonInsufficientFunds {
+		t.Errorf("uncovered buy: err = %v, want %s", err, risk.ReasonInsufficientFunds)
+	}
+	wantFunds(t, "uncovered buy", buyer, 86600, 0)
+
+	// sells on a cash account hold no cash
+	sell := buy(seller, 4, 10)
+	sell.Side = 1
+	if err := Reserve(sell); err != nil {
+		t.Errorf("sell: %v", err)
+	}
+	wantFunds(t, "sell", seller, 3800+6000+3600, 0)
+}
+
+func TestReserveAmend(t *testing.T) {
+	buyer, _ := newAccounts(t)
+
+	tests := []struct {
+		name     string
+		qty      uint32 // amended quantity
+		price    uint64 // amended price, 0 keeps it
+		answer   uint8  // the engine's answer, 0 if the amend is never sent
+		code     string // rejection of the amend
+		reserved decimal.Amount
+		settled  decimal.Amount // reserved once the answer is in
+	}{
+		{"raise accepted", 12, 0, structs.StatusReplaced, "", 12000, 12000},
+		{"raise rejected", 12, 0, structs.StatusReplaceRejected, "", 12000, 10000},
+		{"raise never sent", 12, 0, 0, "", 12000, 10000},
+		{"price raise", 0, 1100, structs.StatusReplaced, "", 11000, 11000},
+		{"lower accepted", 5, 0, structs.StatusReplaced, "", 10000, 5000},
+		{"lower rejected", 5, 0, structs.StatusReplaceRejected, "", 10000, 10000},
+		{"raise not covered", 200, 0, 0, risk.ReasonInsufficientFunds, 10000, 10000},
+	}
+	for i, tt := range tests {
+		current := buy(buyer, uint64(i+1), 10)
+		if err := Reserve(current); err != nil {
+			t.Fatal(err)
+		}
+		amended := current
+		if tt.qty != 0 {
+			amended.Shares_qty = tt.qty
+		}
+		if tt.price != 0 {
+			amended.Price = tt.price
+		}
+
+		err := ReserveAmend(current, amended)
+		var r *risk.Rejection
+		if tt.code != "" {
+			if !errors.As(err, &r) || r.Code != tt.code {
+				t.Errorf("%s: err = %v, want %s", tt.name, err, tt.code)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got, _ := db.ReservedFor(buyer, current.Order_id); got != tt.reserved {
+			t.Errorf("%s: reserved %s, want %s", tt.name, got, tt.reserved)
+		}
+		if tt.code == "" {
+			// one amend at a time
+			if err := ReserveAmend(current, amended); !errors.As(err, &r) || r.Code != risk.ReasonAmendPending {
+				t.Errorf("%s: second amend err = %v, want %s", tt.name, err, risk.ReasonAmendPending)
+			}
+		}
+
+		switch tt.answer {
+		case 0:
+			CancelAmend(current)
+		default:
+			update := amended
+			update.Status = tt.answer
+			OnStatus(update)
+		}
+		if got, _ := db.ReservedFor(buyer, current.Order_id); got != tt.settled {
+			t.Errorf("%s: reserved %s once settled, want %s", tt.name, got, tt.settled)
+		}
+		status(current, structs.StatusCancelled)
+		if got, _ := db.ReservedFor(buyer, current.Order_id); got != 0 {
+			t.Errorf("%s: %s still reserved after the cancel", tt.name, got)
+		}
+	}
+}
@@ -2,20 +2,38 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 
+	"jotacomputing/go-api/algo"
+	"jotacomputing/go-api/auth"
+	"jotacomputing/go-api/calendar"
 	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/feeds"
+	"jotacomputing/go-api/fees"
+	"jotacomputing/go-api/halts"
 	"jotacomputing/go-api/handlers"
+	"jotacomputing/go-api/ledger"
+	"jotacomputing/go-api/margin"
+	"jotacomputing/go-api/margincall"
+	"jotacomputing/go-api/oco"
+	"jotacomputing/go-api/oms"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/positions"
 	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/ratelimit"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/schedules"
+	"jotacomputing/go-api/stops"
 	"jotacomputing/go-api/utils"
 
 	echoserver "github.com/dasjott/oauth2-echo-server"
 	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/manage"
-	"github.com/go-oauth2/oauth2/v4/models"
 	"github.com/go-oauth2/oauth2/v4/server"
 	"github.com/go-oauth2/oauth2/v4/store"
 	"github.com/labstack/echo/v4"
@@ -25,7 +43,11 @@ func main() {
 	// Initialize queues
 	queue.InitQueue(utils.IncomingOrderQueuePath)
 	queue.InitCancelQueue(utils.CancelOrderQueuePath)
+	queue.InitReplaceQueue(utils.ReplaceOrderQueuePath)
 	queue.InitQueryQueue(utils.QueryQueuePath)
+	queue.InitTradeQueue(utils.TradeFeedQueuePath)
+	queue.InitFillQueue(utils.FillQueuePath)
+	queue.InitQueryResponseQueue(utils.QueryResQueuePath)
 
 	if err := queue.InitQueues(); err != nil {
 		log.Fatalf("Failed to initialize queues: %v", err)
@@ -33,19 +55,109 @@ func main() {
 	defer queue.CloseQueues()
 
 	db.InitDB()
+	promoteAdmins(os.Getenv("ADMIN_USERS"))
+
+	if err := ratelimit.Load(); err != nil {
+		log.Fatalf("Failed to load rate limit tiers: %v", err)
+	}
+
+	// Pre-trade risk limits and the exposure they are checked against
+	if err := risk.Load(); err != nil {
+		log.Fatalf("Failed to load risk limits: %v", err)
+	}
+	// ahead of orders.OnStatus, a Filled status reads what the tracker has not seen fill yet
+	feeds.OnStatus(ledger.OnStatus)
+
+	// Margin rates and accounts, the ledger reserves initial margin for them
+	if err := margin.Load(); err != nil {
+		log.Fatalf("Failed to load margin accounts: %v", err)
+	}
+
+	// Trading calendar, gates order types per session and sweeps DAY orders at the close
+	if err := calendar.Load(); err != nil {
+		log.Fatalf("Failed to load trading calendar: %v", err)
+	}
+	risk.Prepend(calendar.Gate{})
+	calendar.StartWatcher()
+
+	// Kill switch, checked ahead of every other pre-trade check
+	if err := halts.Load(); err != nil {
+		log.Fatalf("Failed to load halts: %v", err)
+	}
+	risk.Prepend(halts.Gate{})
+
+	// Open order tracking, order history and GTD expiry
+	if err := oms.Restore(); err != nil {
+		log.Fatalf("Failed to restore open orders: %v", err)
+	}
+	feeds.OnStatus(oms.OnStatus)
+	feeds.OnStatus(orders.OnStatus)
+	feeds.OnFill(oms.OnFill)
+	orders.StartExpiryScheduler()
+
+	// Holdings cache, sells reserve shares so the same position can't back two orders
+	if err := positions.Load(); err != nil {
+		log.Fatalf("Failed to load positions: %v", err)
+	}
+	feeds.OnQueryResponse(positions.OnQueryResponse)
+	feeds.OnStatus(positions.OnStatus)
+	oms.OnExecution(positions.OnExecution)
+
+	// Commission per fill at the account tier's maker or taker rate, charged by
+	// oms.OnFill along with storing the fill
+	if err := fees.Load(); err != nil {
+		log.Fatalf("Failed to load fee schedules: %v", err)
+	}
+
+	// Margin calls, accounts left below maintenance are liquidated
+	if err := margincall.Load(); err != nil {
+		log.Fatalf("Failed to load margin calls: %v", err)
+	}
+	margincall.StartMonitor()
+
+	// Stop orders are held here and released on the trade feed
+	if err := stops.Load(); err != nil {
+		log.Fatalf("Failed to load stop orders: %v", err)
+	}
+	feeds.OnTrade(stops.OnTrade)
+
+	// OCO and bracket groups, siblings shrink with what a leg executes and are
+	// pulled once it is filled
+	if err := oco.Load(); err != nil {
+		log.Fatalf("Failed to load order groups: %v", err)
+	}
+	feeds.OnStatus(oco.OnStatus)
+	oms.OnExecution(oco.OnExecution)
+	stops.OnExpire(oco.OnStopExpired)
+	stops.StartExpiry()
+
+	// TWAP/VWAP parent orders, children are sent through the normal order path
+	if err := algo.Load(); err != nil {
+		log.Fatalf("Failed to load algo orders: %v", err)
+	}
+	feeds.OnStatus(algo.OnStatus)
+	oms.OnExecution(algo.OnExecution)
+	algo.StartScheduler()
+
+	// Recurring orders, a run is claimed in the db before its order is sent
+	schedules.StartScheduler()
+
+	// Start consuming engine feeds once every handler is registered
+	feeds.StartStatusFeed(queue.OrderStatusQueue)
+	feeds.StartTradeFeed(queue.TradeFeedQueue)
+	feeds.StartFillFeed(queue.FillsQueue)
+	feeds.StartQueryResponseFeed(queue.QueryResponses)
 
 	// OAuth2 Server Setup
 	manager := manage.NewDefaultManager()
 	manager.MustTokenStorage(store.NewFileTokenStore("data.db"))
 
-	// Register OAuth2 client
-	clientStore := store.NewClientStore()
-	clientStore.Set("stock-app", &models.Client{
-		ID:     "stock-app",
-		Secret: "supersecret",
-		Domain: "http://localhost:1323",
-	})
-	manager.MapClientStorage(clientStore)
+	// OAuth2 clients come from the oauth_clients table
+	if err := auth.LoadClients(); err != nil {
+		log.Fatalf("Failed to load oauth clients: %v", err)
+	}
+	manager.MapClientStorage(auth.ClientStore{})
+	manager.SetValidateURIHandler(auth.ValidateRedirectURI)
 
 	// Init Echo OAuth2 server
 	echoserver.InitServer(manager)
@@ -55,80 +167,139 @@ func main() {
 	// 1) Allow PASSWORD grant
 	echoserver.SetAllowedGrantType(oauth2.PasswordCredentials)
 
-	// 2) Optional: ensure this client may use password grant
-	echoserver.SetClientAuthorizedHandler(func(clientID string, grant oauth2.GrantType) (bool, error) {
-		if clientID == "stock-app" && grant == oauth2.PasswordCredentials {
-			return true, nil
-		}
-		return false, nil
-	})
+	// 2) Each client may only use the grants and scopes it was registered with
+	echoserver.SetClientAuthorizedHandler(auth.ClientAuthorized)
+	echoserver.SetClientScopeHandler(auth.ClientScope)
 
-	// 3) PasswordAuthorizationHandler: check username/password, return user ID
+	// 3) PasswordAuthorizationHandler: check username/password against the
+	// stored bcrypt hash, return user ID. accounts are created with POST /api/register.
 	echoserver.SetPasswordAuthorizationHandler(
 		func(ctx context.Context, clientID, username, password string) (string, error) {
-			// For testing: accept any non-empty username/password
 			if username == "" || password == "" {
 				return "", echo.NewHTTPError(http.StatusUnauthorized, "invalid credentials")
 			}
 
-			// Optionally verify clientID
-			if clientID != "stock-app" {
-				return "", echo.NewHTTPError(http.StatusUnauthorized, "invalid client")
-			}
-
-			// Find or create user in database
-			user, err := db.FindUserByUsername(username)
-			if err == sql.ErrNoRows {
-				// New user → create with default balance 0.0
-				user, err = db.CreateUser(username, 0.0)
+			userID, err := auth.Authenticate(username, password)
+			if errors.Is(err, auth.ErrInvalidCredentials) || errors.Is(err, auth.ErrNoPassword) {
+				return "", echo.NewHTTPError(http.StatusUnauthorized, "invalid credentials")
 			}
 			if err != nil {
 				return "", err
 			}
 
-			// TODO: Add real password verification here
-			// if !verifyPassword(user.PasswordHash, password) {
-			//     return "", echo.NewHTTPError(http.StatusUnauthorized, "invalid credentials")
-			// }
-
 			// Return user ID as string - this becomes ti.GetUserID() in handlers
-			return strconv.FormatUint(user.ID, 10), nil
+			return strconv.FormatUint(userID, 10), nil
 		},
 	)
-	/*  // 4) UserAuthorizationHandler: map that username → internal uint64 user ID (DB row)
-	    echoserver.SetUserAuthorizationHandler(func(w http.ResponseWriter, r *http.Request) (string, error) {
-	        // The password handler above returned `username` as the "user id".
-	        // That same username is now available in the request.
-	        username := r.FormValue("username")
-
-	        user, err := db.FindUserByUsername(username)
-	        if err == sql.ErrNoRows {
-	            // New user → create with default balance 0.0
-	            user, err = db.CreateUser(username, 0.0)
-	        }
-	        if err != nil {
-	            http.Error(w, "user error", http.StatusInternalServerError)
-	            return "", err
-	        }
-
-	        // This string becomes ti.GetUserID() in handlers
-	        return strconv.FormatUint(user.ID, 10), nil
-	    }) */
 
 	e := echo.New()
+	// the ip limits go by the peer address, X-Forwarded-For is set by whoever
+	// sends the request. behind a proxy use echo.ExtractIPFromXFFHeader with
+	// the proxy's range trusted instead.
+	e.IPExtractor = echo.ExtractIPDirect()
 
 	// OAuth2 endpoint
 	oauth := e.Group("/oauth2")
-	oauth.POST("/token", echoserver.HandleTokenRequest)
+	oauth.POST("/token", echoserver.HandleTokenRequest, handlers.RateLimitIP)
+	oauth.POST("/introspect", handlers.IntrospectHandler(manager))
 
-	// Protected routes
+	// Account registration, the only /api route without a token
+	e.POST("/api/register", handlers.RegisterHandler, handlers.RateLimitIP)
+
+	// Protected routes, tokens from before a password change are refused
 	api := e.Group("/api")
 	api.Use(echoserver.TokenHandler())
+	api.Use(handlers.RejectRevokedTokens)
+
+	orderLimit := handlers.RateLimit(ratelimit.ClassOrder)
+	cancelLimit := handlers.RateLimit(ratelimit.ClassCancel)
+	queryLimit := handlers.RateLimit(ratelimit.ClassQuery)
+
+	// each route checks the token's scope ahead of the rate limit
+	ordersWrite := handlers.RequireScope(auth.ScopeOrdersWrite)
+	ordersRead := handlers.RequireScope(auth.ScopeOrdersRead)
+	accountRead := handlers.RequireScope(auth.ScopeAccountRead)
 
-	api.POST("/order", handlers.PostOrderHandler)
-	api.GET("/balance/:userID", handlers.GetBalanceHandler)
-	api.GET("/holdings/:userID", handlers.GetHoldingsHandler)
-	api.DELETE("/cancel/:orderId", handlers.CancelOrderHandler)
+	// no scope, the current password is proof enough
+	api.PUT("/password", handlers.ChangePasswordHandler)
+	api.POST("/order", handlers.PostOrderHandler, ordersWrite, orderLimit)
+	api.PUT("/order/:orderId", handlers.ReplaceOrderHandler, ordersWrite, orderLimit)
+	api.POST("/orders/fee-preview", handlers.PostFeePreviewHandler, ordersRead, queryLimit)
+	// charged per leg by the handler
+	api.POST("/orders/batch", handlers.PostBatchOrderHandler, ordersWrite)
+	api.POST("/orders/bracket", handlers.PostBracketOrderHandler, ordersWrite, orderLimit)
+	api.POST("/orders/oco", handlers.PostOCOOrderHandler, ordersWrite, orderLimit)
+	api.GET("/balance/:userID", handlers.GetBalanceHandler, accountRead, queryLimit)
+	api.GET("/holdings/:userID", handlers.GetHoldingsHandler, accountRead, queryLimit)
+	api.GET("/positions", handlers.GetPositionsHandler, accountRead, queryLimit)
+	api.GET("/margin", handlers.GetMarginHandler, accountRead, queryLimit)
+	api.DELETE("/cancel/:orderId", handlers.CancelOrderHandler, ordersWrite, cancelLimit)
+	api.DELETE("/orders", handlers.MassCancelHandler, ordersWrite, cancelLimit)
+	api.GET("/orders", handlers.GetOrdersHandler, ordersRead, queryLimit)
+	api.GET("/orders/:orderId/events", handlers.GetOrderEventsHandler, ordersRead, queryLimit)
+	api.GET("/orders/:orderId/executions", handlers.GetOrderExecutionsHandler, ordersRead, queryLimit)
+	api.GET("/orders/groups/:groupId", handlers.GetOrderGroupHandler, ordersRead, queryLimit)
+	api.GET("/executions", handlers.GetExecutionsHandler, ordersRead, queryLimit)
+	api.GET("/stops", handlers.GetStopOrdersHandler, ordersRead, queryLimit)
+	api.GET("/market/status", handlers.GetMarketStatusHandler, ordersRead, queryLimit)
+	api.POST("/algos", handlers.PostAlgoHandler, ordersWrite, orderLimit)
+	api.GET("/algos", handlers.GetAlgosHandler, ordersRead, queryLimit)
+	api.GET("/algos/:algoId", handlers.GetAlgoHandler, ordersRead, queryLimit)
+	api.POST("/algos/:algoId/pause", handlers.PauseAlgoHandler, ordersWrite, orderLimit)
+	api.POST("/algos/:algoId/resume", handlers.ResumeAlgoHandler, ordersWrite, orderLimit)
+	api.DELETE("/algos/:algoId", handlers.CancelAlgoHandler, ordersWrite, cancelLimit)
+	api.DELETE("/stops/:orderId", handlers.CancelStopOrderHandler, ordersWrite, cancelLimit)
+	api.POST("/schedules", handlers.PostScheduleHandler, ordersWrite, orderLimit)
+	api.GET("/schedules", handlers.GetSchedulesHandler, ordersRead, queryLimit)
+	api.GET("/schedules/:scheduleId", handlers.GetScheduleHandler, ordersRead, queryLimit)
+	api.PUT("/schedules/:scheduleId", handlers.PutScheduleHandler, ordersWrite, orderLimit)
+	api.DELETE("/schedules/:scheduleId", handlers.DeleteScheduleHandler, ordersWrite, cancelLimit)
+
+	// Admin routes
+	admin := api.Group("/admin", handlers.RequireScope(auth.ScopeAdmin), handlers.RequireAdmin)
+	admin.GET("/halts", handlers.GetHaltsHandler)
+	admin.POST("/halts", handlers.PostHaltHandler)
+	admin.DELETE("/halts/:scope/:target", handlers.DeleteHaltHandler)
+	admin.GET("/halts/audit", handlers.GetHaltAuditHandler)
+	admin.POST("/holidays", handlers.PostHolidayHandler)
+	admin.PUT("/symbols/:symbol", handlers.PutSymbolHandler)
+	admin.PUT("/users/:userId/password", handlers.SetUserPasswordHandler)
+	admin.PUT("/users/:userId/short", handlers.PutUserShortHandler)
+	admin.PUT("/users/:userId/tier", handlers.PutUserTierHandler)
+	admin.PUT("/users/:userId/stp-group", handlers.PutUserStpGroupHandler)
+	admin.GET("/oauth/clients", handlers.GetOAuthClientsHandler)
+	admin.POST("/oauth/clients", handlers.PostOAuthClientHandler)
+	admin.PUT("/oauth/clients/:clientId", handlers.PutOAuthClientHandler)
+	admin.POST("/oauth/clients/:clientId/rotate", handlers.RotateOAuthClientHandler)
+	admin.GET("/fees", handlers.GetFeeSchedulesHandler)
+	admin.PUT("/fees/:tier", handlers.PutFeeScheduleHandler)
+	admin.GET("/margin/rates", handlers.GetMarginRatesHandler)
+	admin.PUT("/margin/rates/:symbol", handlers.PutMarginRateHandler)
+	admin.PUT("/margin/accounts/:userId", handlers.PutMarginAccountHandler)
+	admin.GET("/risk/limits", handlers.GetRiskLimitsHandler)
+	admin.PUT("/risk/limits", handlers.PutRiskLimitHandler)
+	admin.DELETE("/risk/limits/:userId/:symbol/:name", handlers.DeleteRiskLimitHandler)
 
 	e.Logger.Fatal(e.Start(":1323"))
 }
+
+// promoteAdmins gives the admin role to a comma separated list of usernames,
+// taken from ADMIN_USERS. it is how the first admin is made, the accounts must
+// be registered already.
+func promoteAdmins(names string) {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found, err := db.PromoteUser(name)
+		if err != nil {
+			log.Fatalf("Failed to promote %s to admin: %v", name, err)
+		}
+		if !found {
+			log.Printf("ADMIN_USERS: no user named %s", name)
+			continue
+		}
+		log.Printf("ADMIN_USERS: %s is an admin", name)
+	}
+}
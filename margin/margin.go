@@ -0,0 +1,192 @@
+package margin
+
+import (
+	"sync"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/feeds"
+	"jotacomputing/go-api/positions"
+	"jotacomputing/go-api/symbols"
+)
+
+// margin accounts borrow against their positions. opening exposure (buys and
+// short sales) needs initial margin out of the account's excess equity, the
+// margin call monitor watches equity against maintenance.
+
+var (
+	mu       sync.RWMutex
+	rates    = make(map[uint32]db.MarginRate) // symbol 0 is the default
+	accounts = make(map[uint64]bool)
+)
+
+// Load reads the margin rates and margin accounts
+func Load() error {
+	list, err := db.ListMarginRates()
+	if err != nil {
+		return err
+	}
+	ids, err := db.ListMarginAccounts()
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	rates = make(map[uint32]db.MarginRate, len(list))
+	for _, r := range list {
+		rates[r.Symbol] = r
+	}
+	accounts = make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		accounts[id] = true
+	}
+	return nil
+}
+
+// RateFor returns the rates of a symbol, the default when it has none of its own
+func RateFor(symbol uint32) db.MarginRate {
+	mu.RLock()
+	defer mu.RUnlock()
+	if r, ok := rates[symbol]; ok {
+		return r
+	}
+	r := rates[0]
+	r.Symbol = symbol
+	return r
+}
+
+// Rates returns every configured rate, the default included
+func Rates() []db.MarginRate {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]db.MarginRate, 0, len(rates))
+	for _, r := range rates {
+		list = append(list, r)
+	}
+	return list
+}
+
+// SetRate stores the rates of a symbol, 0 for the default
+func SetRate(r db.MarginRate) error {
+	if err := db.SetMarginRate(r); err != nil {
+		return err
+	}
+	mu.Lock()
+	rates[r.Symbol] = r
+	mu.Unlock()
+	return nil
+}
+
+// Enabled reports whether the user has a margin account
+func Enabled(userID uint64) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return accounts[userID]
+}
+
+// SetEnabled switches an account between margin and cash, false if there is
+// no such user
+func SetEnabled(userID uint64, enabled bool) (bool, error) {
+	found, err := db.SetMarginEnabled(userID, enabled)
+	if err != nil || !found {
+		return found, err
+	}
+	mu.Lock()
+	if enabled {
+		accounts[userID] = true
+	} else {
+		delete(accounts, userID)
+	}
+	mu.Unlock()
+	return true, nil
+}
+
+// Accounts returns the ids of every margin account
+func Accounts() []uint64 {
+	mu.RLock()
+	defer mu.RUnlock()
+	ids := make([]uint64, 0, len(accounts))
+	for id := range accounts {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Initial is the initial margin needed to open notional worth of symbol
+func Initial(notional decimal.Amount, symbol uint32) decimal.Amount {
+	return decimal.OfBps(notional, RateFor(symbol).Initial_bps)
+}
+
+// Summary is an account's margin position. short market value is positive,
+// equity is cash plus longs minus shorts.
+type Summary struct {
+	Cash        decimal.Amount
+	Long_value  decimal.Amount
+	Short_value decimal.Amount
+	Equity      decimal.Amount
+	Initial     decimal.Amount // requirement to hold the current positions
+	Maintenance decimal.Amount
+}
+
+// Excess is equity above the initial requirement, what new orders can use
+func (s Summary) Excess() decimal.Amount {
+	return s.Equity - s.Initial
+}
+
+// Deficit is how far equity is below maintenance, 0 when it isn't
+func (s Summary) Deficit() decimal.Amount {
+	if s.Equity >= s.Maintenance {
+		return 0
+	}
+	return s.Maintenance - s.Equity
+}
+
+// Compute values the account's positions at the last trade. false when the
+// holdings aren't known yet. a symbol that never traded can't be valued and
+// is left out.
+func Compute(userID uint64) (Summary, bool, error) {
+	held, ok := positions.Of(userID)
+	if !ok {
+		return Summary{}, false, nil
+	}
+	cash, err := db.UserBalance(userID)
+	if err != nil {
+		return Summary{}, false, err
+	}
+	s, err := summarize(cash, held, feeds.LastPrice)
+	return s, err == nil, err
+}
+
+// summarize values held at the prices from last
+func summarize(cash decimal.Amount, held []positions.Position, last func(uint32) (uint64, bool)) (Summary, error) {
+	s := Summary{Cash: cash}
+	for _, p := range held {
+		if p.Qty == 0 {
+			continue
+		}
+		price, ok := last(p.Symbol)
+		if !ok {
+			continue
+		}
+		qty := p.Qty
+		if qty < 0 {
+			qty = -qty
+		}
+		spec := symbols.Lookup(p.Symbol)
+		value, err := decimal.Notional(price, spec.PriceScale, uint64(qty), spec.QtyScale, false)
+		if err != nil {
+			return Summary{}, err
+		}
+		if p.Qty > 0 {
+			s.Long_value += value
+		} else {
+			s.Short_value += value
+		}
+		r := RateFor(p.Symbol)
+		s.Initial += decimal.OfBps(value, r.Initial_bps)
+		s.Maintenance += decimal.OfBps(value, r.Maintenance_bps)
+	}
+	s.Equity = s.Cash + s.Long_value - s.Short_value
+	return s, nil
+}
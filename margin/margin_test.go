@@ -0,0 +1,117 @@
+package margin
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/positions"
+)
+
+func TestSummarize(t *testing.T) {
+	rates = map[uint32]db.MarginRate{
+		0: {Symbol: 0, Initial_bps: 5000, Maintenance_bps: 2500},
+		2: {Symbol: 2, Initial_bps: 10000, Maintenance_bps: 5000},
+	}
+	// symbols use the default spec, prices in cents and whole shares
+	prices := map[uint32]uint64{1: 10000, 2: 5000, 4: math.MaxUint64}
+	last := func(symbol uint32) (uint64, bool) {
+		p, ok := prices[symbol]
+		return p, ok
+	}
+
+	tests := []struct {
+		name    string
+		cash    decimal.Amount
+		held    []positions.Position
+		want    Summary
+		excess  decimal.Amount
+		deficit decimal.Amount
+	}{
+		{
+			name:   "cash only",
+			cash:   100000,
+			want:   Summary{Cash: 100000, Equity: 100000},
+			excess: 100000,
+		},
+		{
+			name: "long at the initial requirement",
+			cash: -50000,
+			held: []positions.Position{{Symbol: 1, Qty: 10}},
+			want: Summary{Cash: -50000, Long_value: 100000, Equity: 50000, Initial: 50000, Maintenance: 25000},
+		},
+		{
+			name:    "long below maintenance",
+			cash:    -80000,
+			held:    []positions.Position{{Symbol: 1, Qty: 10}},
+			want:    Summary{Cash: -80000, Long_value: 100000, Equity: 20000, Initial: 50000, Maintenance: 25000},
+			excess:  -30000,
+			deficit: 5000,
+		},
+		{
+			name:    "short on its own rate",
+			cash:    140000,
+			held:    []positions.Position{{Symbol: 2, Qty: -20}},
+			want:    Summary{Cash: 140000, Short_value: 100000, Equity: 40000, Initial: 100000, Maintenance: 50000},
+			excess:  -60000,
+			deficit: 10000,
+		},
+		{
+			name:   "long and short",
+			held:   []positions.Position{{Symbol: 1, Qty: 10}, {Symbol: 2, Qty: -2}},
+			want:   Summary{Long_value: 100000, Short_value: 10000, Equity: 90000, Initial: 60000, Maintenance: 30000},
+			excess: 30000,
+		},
+		{
+			name:   "flat and untraded positions are left out",
+			cash:   1000,
+			held:   []positions.Position{{Symbol: 1, Qty: 0}, {Symbol: 3, Qty: 5}},
+			want:   Summary{Cash: 1000, Equity: 1000},
+			excess: 1000,
+		},
+	}
+	for _, tt := range tests {
+		s, err := summarize(tt.cash, tt.held, last)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if s != tt.want {
+			t.Errorf("%s: summary = %+v, want %+v", tt.name, s, tt.want)
+		}
+		if got := s.Excess(); got != tt.excess {
+			t.Errorf("%s: excess = %s, want %s", tt.name, got, tt.excess)
+		}
+		if got := s.Deficit(); got != tt.deficit {
+			t.Errorf("%s: deficit = %s, want %s", tt.name, got, tt.deficit)
+		}
+	}
+
+	if _, err := summarize(0, []positions.Position{{Symbol: 4, Qty: 2}}, last); !errors.Is(err, decimal.ErrRange) {
+		t.Errorf("position worth more than an amount: err = %v, want ErrRange", err)
+	}
+}
+
+func TestRateFor(t *testing.T) {
+	rates = map[uint32]db.MarginRate{
+		0: {Symbol: 0, Initial_bps: 5000, Maintenance_bps: 2500},
+		2: {Symbol: 2, Initial_bps: 10000, Maintenance_bps: 5000},
+	}
+	tests := []struct {
+		symbol uint32
+		want   db.MarginRate
+	}{
+		{2, db.MarginRate{Symbol: 2, Initial_bps: 10000, Maintenance_bps: 5000}},
+		{7, db.MarginRate{Symbol: 7, Initial_bps: 5000, Maintenance_bps: 2500}},
+	}
+	for _, tt := range tests {
+		if got := RateFor(tt.symbol); got != tt.want {
+			t.Errorf("RateFor(%d) = %+v, want %+v", tt.symbol, got, tt.want)
+		}
+	}
+	if got := Initial(12345, 7); got != 6173 { // 61.725 rounded up
+		t.Errorf("Initial(123.45) = %s, want 61.73", got)
+	}
+}
@@ -0,0 +1,257 @@
+package margincall
+
+import (
+	"log"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/feeds"
+	"jotacomputing/go-api/margin"
+	"jotacomputing/go-api/oms"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/positions"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+)
+
+// the monitor values every margin account on a timer. an account below
+// maintenance gets a margin call, if it is still in deficit after the grace
+// period its positions are closed out one market order at a time.
+
+const (
+	checkInterval = 5 * time.Second
+	// how long an account may stay below maintenance before liquidation
+	callGrace = 2 * time.Minute
+	// close this much more than the bare deficit so the account doesn't land
+	// right back on the line
+	bufferBps = 2000
+	// liquidation order ids are the call id and a counter under bit 60, apart
+	// from client ids, scheduled orders (61) and algo children (62)
+	orderIDBit = uint64(1) << 60
+	// how long to wait for the account's cancels before sending them again
+	cancelRetry = 30 * time.Second
+)
+
+type call struct {
+	rec       *db.MarginCall
+	pending   orders.Key // last liquidation order, zero before the first
+	cancelled time.Time  // when the account's open orders were last cancelled
+}
+
+// orderID is the id of the call's nth liquidation order
+func orderID(rec *db.MarginCall, n int) uint64 {
+	return orderIDBit | rec.ID<<20 | uint64(n)
+}
+
+var (
+	mu    sync.Mutex
+	calls = make(map[uint64]*call) // open calls by user
+)
+
+// Load picks up the calls that were open before a restart
+func Load() error {
+	open, err := db.LoadOpenMarginCalls()
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, rec := range open {
+		c := &call{rec: rec}
+		if rec.Liquidations > 0 {
+			// may still be working, restored by oms.Restore if so
+			c.pending = orders.Key{User_id: rec.User_id, Order_id: orderID(rec, rec.Liquidations)}
+		}
+		calls[rec.User_id] = c
+	}
+	return nil
+}
+
+// Current returns the user's open call, nil if the account is in good standing
+func Current(userID uint64) *db.MarginCall {
+	mu.Lock()
+	defer mu.Unlock()
+	if c, ok := calls[userID]; ok {
+		rec := *c.rec
+		return &rec
+	}
+	return nil
+}
+
+// StartMonitor checks every margin account in the background
+func StartMonitor() {
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			for _, userID := range margin.Accounts() {
+				summary, ok, err := margin.Compute(userID)
+				if err != nil {
+					log.Printf("margin: failed to value account %d: %v", userID, err)
+					continue
+				}
+				if ok {
+					review(userID, summary, now)
+				}
+			}
+		}
+	}()
+}
+
+func review(userID uint64, s margin.Summary, now time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	c := calls[userID]
+	deficit := s.Deficit()
+
+	if deficit == 0 {
+		if c != nil {
+			c.rec.Status, c.rec.Deficit = db.MarginCallResolved, 0
+			if err := db.UpdateMarginCall(c.rec); err != nil {
+				log.Printf("margin: failed to resolve call %d: %v", c.rec.ID, err)
+			}
+			delete(calls, userID)
+			log.Printf("margin: account %d back above maintenance", userID)
+		}
+		return
+	}
+
+	if c == nil {
+		c = &call{rec: &db.MarginCall{User_id: userID, Deficit: deficit}}
+		if err := db.OpenMarginCall(c.rec); err != nil {
+			log.Printf("margin: failed to open call for account %d: %v", userID, err)
+			return
+		}
+		calls[userID] = c
+		log.Printf("margin: call on account %d, equity %s below maintenance %s", userID, s.Equity, s.Maintenance)
+		return
+	}
+	if c.rec.Deficit != deficit {
+		c.rec.Deficit = deficit
+		if err := db.UpdateMarginCall(c.rec); err != nil {
+			log.Printf("margin: failed to update call %d: %v", c.rec.ID, err)
+		}
+	}
+
+	if now.Sub(time.Unix(0, c.rec.Opened_at)) < callGrace {
+		return
+	}
+	// one liquidation at a time, the next is sized on the result of the last
+	if c.pending != (orders.Key{}) {
+		if _, open := orders.Get(c.pending.User_id, c.pending.Order_id); open {
+			return
+		}
+	}
+	liquidate(c, s, now)
+}
+
+// liquidate closes enough of the account's largest position to cover the
+// deficit, all of it once equity is gone. c is locked by the caller.
+func liquidate(c *call, s margin.Summary, now time.Time) {
+	userID := c.rec.User_id
+	// open orders only add exposure or hold shares the liquidation needs. they
+	// go first and the liquidation waits until the engine confirmed.
+	if open := orders.Open(orders.Filter{User_id: userID}); len(open) > 0 {
+		if now.Sub(c.cancelled) >= cancelRetry {
+			c.cancelled = now
+			if _, _, err := oms.CancelAll(orders.Filter{User_id: userID}, "margin call liquidation"); err != nil {
+				log.Printf("margin: failed to cancel orders of account %d: %v", userID, err)
+			}
+		}
+		return
+	}
+
+	held, _ := positions.Of(userID)
+	var (
+		target positions.Position
+		price  uint64
+		best   decimal.Amount
+	)
+	for _, p := range held {
+		last, ok := feeds.LastPrice(p.Symbol)
+		if p.Qty == 0 || !ok {
+			continue
+		}
+		spec := symbols.Lookup(p.Symbol)
+		value, err := decimal.Notional(last, spec.PriceScale, abs(p.Qty), spec.QtyScale, false)
+		if err == nil && value > best {
+			target, price, best = p, last, value
+		}
+	}
+	if best == 0 {
+		log.Printf("margin: account %d in deficit %s with nothing to liquidate", userID, s.Deficit())
+		return
+	}
+
+	spec := symbols.Lookup(target.Symbol)
+	qty := abs(target.Qty)
+	if rate := margin.RateFor(target.Symbol).Maintenance_bps; s.Equity > 0 && rate > 0 {
+		// closing a position worth x frees x * maintenance of the requirement
+		need := new(big.Int).Mul(big.NewInt(int64(s.Deficit())), big.NewInt(10000+bufferBps))
+		need.Quo(need, new(big.Int).SetUint64(rate))
+		amount := decimal.MaxAmount
+		if need.IsInt64() {
+			amount = decimal.Amount(need.Int64())
+		}
+		if n, err := decimal.QtyFor(amount, price, spec.PriceScale, spec.QtyScale); err == nil {
+			// up to whole lots, at least one
+			if r := n % spec.LotSize; r != 0 || n == 0 {
+				n += spec.LotSize - r
+			}
+			if n < qty {
+				qty = n
+			}
+		}
+	}
+	if qty > math.MaxUint32 {
+		qty = math.MaxUint32 - math.MaxUint32%spec.LotSize
+	}
+
+	side := uint8(1)
+	if target.Qty < 0 {
+		side = 0
+	}
+	order := structs.Order{
+		Order_id:      orderID(c.rec, c.rec.Liquidations+1),
+		User_id:       userID,
+		Timestamp:     uint64(now.UnixNano()),
+		Shares_qty:    uint32(qty),
+		Symbol:        target.Symbol,
+		Side:          side,
+		Order_type:    structs.OrderTypeMarket,
+		Time_in_force: structs.TifIOC,
+	}
+	// a halt or a closed session holds it back without using up an id
+	if r := risk.CheckGates(order); r != nil {
+		log.Printf("margin: liquidation of account %d waits: %v", userID, r)
+		return
+	}
+
+	// the id is on record before the order goes out, a restart then knows
+	// it may be working
+	c.rec.Liquidations++
+	if err := db.UpdateMarginCall(c.rec); err != nil {
+		c.rec.Liquidations--
+		log.Printf("margin: failed to update call %d: %v", c.rec.ID, err)
+		return
+	}
+	if err := oms.Liquidate(order); err != nil {
+		log.Printf("margin: failed to send liquidation for account %d: %v", userID, err)
+		return
+	}
+	c.pending = orders.KeyOf(order)
+	log.Printf("margin: liquidating %s of symbol %d on account %d, deficit %s",
+		decimal.Format(qty, spec.QtyScale), target.Symbol, userID, s.Deficit())
+}
+
+func abs(q int64) uint64 {
+	if q < 0 {
+		return uint64(-q)
+	}
+	return uint64(q)
+}
@@ -0,0 +1,474 @@
+package oco
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/oms"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/positions"
+	"jotacomputing/go-api/stops"
+	"jotacomputing/go-api/structs"
+)
+
+// ErrInvalidGroup wraps every reason a group request is malformed
+var ErrInvalidGroup = errors.New("invalid order group")
+
+// groups with at least one leg that is not done, and the group of each of
+// their legs. a group stays here after it completed until its last working
+// leg is done so leg statuses keep following the engine.
+var (
+	mu     sync.Mutex
+	groups = make(map[uint64]*db.OrderGroup)
+	legs   = make(map[orders.Key]uint64)
+)
+
+// Load rebuilds the live groups from the database
+func Load() error {
+	live, err := db.LoadLiveOrderGroups()
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, g := range live {
+		track(g)
+	}
+	log.Printf("oco: loaded %d live order groups", len(live))
+	return nil
+}
+
+func track(g *db.OrderGroup) {
+	groups[g.ID] = g
+	for _, leg := range g.Legs {
+		legs[orders.KeyOf(leg.Order.Order)] = g.ID
+	}
+	// only one exit can execute, they hold the position once
+	positions.Share(exitKeys(g)...)
+}
+
+func untrack(g *db.OrderGroup) {
+	delete(groups, g.ID)
+	for _, leg := range g.Legs {
+		delete(legs, orders.KeyOf(leg.Order.Order))
+	}
+	positions.Unshare(exitKeys(g)...)
+}
+
+func exitKeys(g *db.OrderGroup) []orders.Key {
+	var keys []orders.Key
+	for _, leg := range g.Legs {
+		if leg.Role != db.LegEntry {
+			keys = append(keys, orders.KeyOf(leg.Order.Order))
+		}
+	}
+	return keys
+}
+
+// CreateOCO sends two orders where what executes on either one is taken off
+// the other, and filling one cancels the other
+func CreateOCO(first, second structs.StopOrder) (*db.OrderGroup, error) {
+	if first.Order.Symbol != second.Order.Symbol {
+		return nil, fmt.Errorf("%w: both legs must be on the same symbol", ErrInvalidGroup)
+	}
+	if first.Order.Order_id == second.Order.Order_id {
+		return nil, fmt.Errorf("%w: legs need distinct order ids", ErrInvalidGroup)
+	}
+
+	g := &db.OrderGroup{
+		User_id: first.Order.User_id,
+		Kind:    db.GroupOCO,
+		Status:  db.GroupActive,
+		Legs: []db.OrderGroupLeg{
+			{Role: db.LegOCO, Status: db.LegPending, Order: first},
+			{Role: db.LegOCO, Status: db.LegPending, Order: second},
+		},
+	}
+	if err := create(g, 0, 1); err != nil {
+		return g, err
+	}
+	return g, nil
+}
+
+// CreateBracket sends the entry order and holds take profit and stop loss
+// until it fills, they then go out as an OCO pair for the filled quantity
+func CreateBracket(entry, takeProfit, stopLoss structs.StopOrder) (*db.OrderGroup, error) {
+	if err := validateBracket(entry, takeProfit, stopLoss); err != nil {
+		return nil, err
+	}
+
+	g := &db.OrderGroup{
+		User_id: entry.Order.User_id,
+		Kind:    db.GroupBracket,
+		Status:  db.GroupPending,
+		Legs: []db.OrderGroupLeg{
+			{Role: db.LegEntry, Status: db.LegPending, Order: entry},
+			{Role: db.LegTakeProfit, Status: db.LegPending, Order: takeProfit},
+			{Role: db.LegStopLoss, Status: db.LegPending, Order: stopLoss},
+		},
+	}
+	if err := create(g, 0); err != nil {
+		return g, err
+	}
+	return g, nil
+}
+
+func validateBracket(entry, takeProfit, stopLoss structs.StopOrder) error {
+	e, tp, sl := entry.Order, takeProfit.Order, stopLoss.Order
+	if tp.Symbol != e.Symbol || sl.Symbol != e.Symbol {
+		return fmt.Errorf("%w: all legs must be on the same symbol", ErrInvalidGroup)
+	}
+	if tp.Side == e.Side || sl.Side == e.Side {
+		return fmt.Errorf("%w: take_profit and stop_loss must be on the opposite side of the entry", ErrInvalidGroup)
+	}
+	if tp.Order_type != structs.OrderTypeLimit {
+		return fmt.Errorf("%w: take_profit must be a limit order", ErrInvalidGroup)
+	}
+	if !isStop(sl) {
+		return fmt.Errorf("%w: stop_loss must be a stop or stop-limit order", ErrInvalidGroup)
+	}
+	if tp.Shares_qty != e.Shares_qty || sl.Shares_qty != e.Shares_qty {
+		return fmt.Errorf("%w: take_profit and stop_loss must have the entry's shares_qty", ErrInvalidGroup)
+	}
+	if e.Order_id == tp.Order_id || e.Order_id == sl.Order_id || tp.Order_id == sl.Order_id {
+		return fmt.Errorf("%w: legs need distinct order ids", ErrInvalidGroup)
+	}
+	return nil
+}
+
+// create persists g and sends the legs at the given indexes. if one of them
+// fails the ones already sent are cancelled and the group is rejected.
+func create(g *db.OrderGroup, send ...int) error {
+	if err := db.CreateOrderGroup(g); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// tracked before sending, the engine can answer before place returns
+	track(g)
+	for n, i := range send {
+		if err := place(&g.Legs[i]); err != nil {
+			g.Legs[i].Status = db.LegRejected
+			saveLeg(g.Legs[i])
+			for _, j := range send[:n] {
+				cancelLeg(&g.Legs[j], "order group rejected")
+			}
+			for j := range g.Legs {
+				if g.Legs[j].Status == db.LegPending {
+					g.Legs[j].Status = db.LegCancelled
+					saveLeg(g.Legs[j])
+				}
+			}
+			setStatus(g, db.GroupRejected)
+			if done(g) {
+				untrack(g)
+			}
+			return err
+		}
+		saveLeg(g.Legs[i])
+	}
+	return nil
+}
+
+func isStop(order structs.Order) bool {
+	return order.Order_type == structs.OrderTypeStop || order.Order_type == structs.OrderTypeStopLimit
+}
+
+// place sends a leg: stops are armed in the trigger book, anything else goes
+// to the engine
+func place(leg *db.OrderGroupLeg) error {
+	if isStop(leg.Order.Order) {
+		if r := stops.Check(leg.Order); r != nil {
+			return r
+		}
+		if err := stops.Add(leg.Order); err != nil {
+			return err
+		}
+	} else if err := oms.SubmitNow(leg.Order.Order); err != nil {
+		return err
+	}
+	leg.Status = db.LegWorking
+	return nil
+}
+
+// cancelLeg pulls a leg that is not done. legs never sent and stops still in
+// the trigger book are done right away, engine orders once the engine
+// confirms the cancel.
+func cancelLeg(leg *db.OrderGroupLeg, reason string) {
+	o := leg.Order.Order
+	switch leg.Status {
+	case db.LegPending:
+		leg.Status = db.LegCancelled
+		saveLeg(*leg)
+		return
+	case db.LegWorking:
+	default:
+		return
+	}
+
+	if isStop(o) {
+		_, err := stops.Cancel(o.User_id, o.Order_id)
+		if err == nil {
+			leg.Status = db.LegCancelled
+			saveLeg(*leg)
+			return
+		}
+		if !errors.Is(err, stops.ErrNotFound) {
+			log.Printf("oco: failed to cancel stop %d of user %d: %v", o.Order_id, o.User_id, err)
+			return
+		}
+		// already triggered, it is with the engine now
+	}
+
+	var cancelOrder structs.OrderToBeCancelled
+	cancelOrder.Order_id = o.Order_id
+	cancelOrder.User_id = o.User_id
+	cancelOrder.Symbol = o.Symbol
+	if err := oms.Cancel(cancelOrder, reason); err != nil {
+		log.Printf("oco: failed to cancel order %d of user %d: %v", o.Order_id, o.User_id, err)
+	}
+}
+
+// OnStatus follows the engine on every leg and does what the group implies,
+// registered with feeds.OnStatus
+func OnStatus(update structs.Order) {
+	key := orders.KeyOf(update)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	id, ok := legs[key]
+	if !ok {
+		return
+	}
+	g := groups[id]
+	var leg *db.OrderGroupLeg
+	for i := range g.Legs {
+		if orders.KeyOf(g.Legs[i].Order.Order) == key {
+			leg = &g.Legs[i]
+		}
+	}
+
+	switch update.Status {
+	case structs.StatusPartiallyFilled:
+		// still working
+	case structs.StatusFilled:
+		leg.Status = db.LegFilled
+	case structs.StatusCancelled:
+		leg.Status = db.LegCancelled
+	case structs.StatusRejected:
+		leg.Status = db.LegRejected
+	case structs.StatusReplaced:
+		// exits are sized off the entry and off each other, keep it current
+		leg.Order.Order.Price = update.Price
+		leg.Order.Order.Shares_qty = update.Shares_qty
+	default:
+		return
+	}
+	saveLeg(*leg)
+
+	if leg.Role == db.LegEntry {
+		onEntry(g, leg, update)
+	} else {
+		onExit(g, leg, update)
+	}
+
+	if done(g) {
+		untrack(g)
+	}
+}
+
+// OnStopExpired treats a stop leg that lapsed in the trigger book like a
+// cancelled one, registered with stops.OnExpire
+func OnStopExpired(stop structs.StopOrder) {
+	update := stop.Order
+	update.Status = structs.StatusCancelled
+	OnStatus(update)
+}
+
+func onEntry(g *db.OrderGroup, entry *db.OrderGroupLeg, update structs.Order) {
+	if g.Status != db.GroupPending {
+		return
+	}
+	switch update.Status {
+	case structs.StatusFilled:
+		activate(g, entry.Order.Order.Shares_qty)
+	case structs.StatusCancelled, structs.StatusRejected:
+		// protect whatever did execute before the entry went away
+		executed, err := db.ExecutedQty(entry.Order.Order.User_id, entry.Order.Order.Order_id)
+		if err != nil {
+			log.Printf("oco: failed to load executions of entry %d: %v", entry.Order.Order.Order_id, err)
+		}
+		if executed > 0 {
+			activate(g, uint32(executed))
+			return
+		}
+		cancelSiblings(g, entry, "bracket entry cancelled")
+		setStatus(g, db.GroupCancelled)
+	}
+}
+
+// onExit ends the group once an exit is done. partial fills are taken off
+// the siblings by OnExecution, they stay working for what is left.
+func onExit(g *db.OrderGroup, leg *db.OrderGroupLeg, update structs.Order) {
+	if g.Status != db.GroupActive {
+		return
+	}
+	switch update.Status {
+	case structs.StatusFilled:
+		cancelSiblings(g, leg, "oco sibling filled")
+		setStatus(g, db.GroupCompleted)
+	case structs.StatusCancelled, structs.StatusRejected:
+		cancelSiblings(g, leg, "oco sibling cancelled")
+		setStatus(g, db.GroupCancelled)
+	}
+}
+
+// OnExecution takes what an exit executed off every exit of its group, its
+// own quantity and the siblings' since they protect the same position.
+// registered with oms.OnExecution.
+func OnExecution(fill structs.Fill) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, key := range []orders.Key{
+		{User_id: fill.Buy_user_id, Order_id: fill.Buy_order_id},
+		{User_id: fill.Sell_user_id, Order_id: fill.Sell_order_id},
+	} {
+		id, ok := legs[key]
+		if !ok {
+			continue
+		}
+		g := groups[id]
+		if g.Status != db.GroupActive {
+			continue
+		}
+		for i := range g.Legs {
+			leg := &g.Legs[i]
+			if leg.Role == db.LegEntry {
+				continue
+			}
+			o := &leg.Order.Order
+			if o.Shares_qty > fill.Shares_qty {
+				o.Shares_qty -= fill.Shares_qty
+			} else {
+				o.Shares_qty = 0
+			}
+			saveLeg(*leg)
+			if orders.KeyOf(*o) != key {
+				shrinkLeg(leg)
+			}
+		}
+	}
+}
+
+// shrinkLeg brings a working leg down to its quantity, pulling it once
+// nothing is left. stops still armed are resized in the trigger book,
+// anything with the engine is amended.
+func shrinkLeg(leg *db.OrderGroupLeg) {
+	o := leg.Order.Order
+	if leg.Status != db.LegWorking {
+		return
+	}
+	if o.Shares_qty == 0 {
+		cancelLeg(leg, "oco sibling filled")
+		return
+	}
+
+	if isStop(o) {
+		err := stops.Resize(o.User_id, o.Order_id, o.Shares_qty)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, stops.ErrNotFound) {
+			log.Printf("oco: failed to resize stop %d of user %d: %v", o.Order_id, o.User_id, err)
+			return
+		}
+		// already triggered, it is with the engine now
+	}
+
+	replace := structs.OrderReplace{
+		Order_id:      o.Order_id,
+		User_id:       o.User_id,
+		Symbol:        o.Symbol,
+		New_qty:       o.Shares_qty,
+		Timestamp:     uint64(time.Now().UnixNano()),
+		Keep_priority: 1,
+	}
+	if err := oms.Amend(replace); err != nil {
+		log.Printf("oco: failed to amend order %d of user %d: %v", o.Order_id, o.User_id, err)
+	}
+}
+
+// activate sends the bracket exits for qty once the entry has executed
+func activate(g *db.OrderGroup, qty uint32) {
+	var exits []int
+	for i := range g.Legs {
+		leg := &g.Legs[i]
+		if leg.Role == db.LegEntry || leg.Status != db.LegPending {
+			continue
+		}
+		o := &leg.Order.Order
+		o.Shares_qty = qty
+		if o.Display_qty > qty {
+			o.Display_qty = 0
+		}
+		o.Timestamp = uint64(time.Now().UnixNano())
+		exits = append(exits, i)
+	}
+
+	setStatus(g, db.GroupActive)
+	for n, i := range exits {
+		if err := place(&g.Legs[i]); err != nil {
+			o := g.Legs[i].Order.Order
+			log.Printf("oco: bracket %d failed to send order %d of user %d: %v", g.ID, o.Order_id, o.User_id, err)
+			g.Legs[i].Status = db.LegRejected
+			saveLeg(g.Legs[i])
+			for _, j := range exits[:n] {
+				cancelLeg(&g.Legs[j], "bracket exit rejected")
+			}
+			for _, j := range exits[n+1:] {
+				cancelLeg(&g.Legs[j], "bracket exit rejected")
+			}
+			setStatus(g, db.GroupCancelled)
+			return
+		}
+		saveLeg(g.Legs[i])
+	}
+}
+
+func cancelSiblings(g *db.OrderGroup, leg *db.OrderGroupLeg, reason string) {
+	for i := range g.Legs {
+		if &g.Legs[i] != leg && g.Legs[i].Role != db.LegEntry {
+			cancelLeg(&g.Legs[i], reason)
+		}
+	}
+}
+
+func done(g *db.OrderGroup) bool {
+	for _, leg := range g.Legs {
+		if leg.Status == db.LegPending || leg.Status == db.LegWorking {
+			return false
+		}
+	}
+	return true
+}
+
+func setStatus(g *db.OrderGroup, status string) {
+	g.Status = status
+	if err := db.SetOrderGroupStatus(g.ID, status); err != nil {
+		log.Printf("oco: failed to store status %s of group %d: %v", status, g.ID, err)
+	}
+}
+
+func saveLeg(leg db.OrderGroupLeg) {
+	if err := db.UpdateOrderGroupLeg(leg); err != nil {
+		log.Printf("oco: failed to store leg %d of user %d: %v", leg.Order.Order.Order_id, leg.Order.Order.User_id, err)
+	}
+}
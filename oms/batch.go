@@ -0,0 +1,128 @@
+package oms
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/ledger"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/positions"
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+)
+
+// ErrBatchRejected means no order of a batch was sent, the per-leg errors
+// say which legs failed
+var ErrBatchRejected = errors.New("batch rejected")
+
+// MaxBatchSize is the most legs a basket may have
+const MaxBatchSize = 50
+
+// SubmitBatch is Submit for a basket: every leg goes through the same checks
+// and the legs are only sent, in one ring write, if all of them pass. each
+// accepted leg is tracked and its funds reserved before the next is checked,
+// so open order, position and buying power limits apply to the basket as a
+// whole.
+//
+// the returned errors line up with batch, a leg that passed but was held back
+// by another gets a BATCH_REJECTED rejection. a batch over MaxBatchSize or
+// bigger than the free space of the ring is refused before anything is stored.
+func SubmitBatch(batch []structs.Order) ([]error, error) {
+	if len(batch) > MaxBatchSize {
+		return nil, &risk.Rejection{
+			Code:    risk.ReasonBatchTooLarge,
+			Message: fmt.Sprintf("batch of %d orders, at most %d allowed", len(batch), MaxBatchSize),
+		}
+	}
+	if free := queue.IncomingOrderQueue.Free(); uint64(len(batch)) > free {
+		return nil, &risk.Rejection{
+			Code:    risk.ReasonBatchTooLarge,
+			Message: fmt.Sprintf("batch of %d orders, order queue has room for %d", len(batch), free),
+		}
+	}
+
+	if len(batch) == 0 {
+		return nil, nil
+	}
+	// the batch is one account's, no other order of it is checked in between
+	unlock := lockUser(batch[0].User_id)
+	defer unlock()
+
+	batch = append([]structs.Order(nil), batch...)
+	results := make([]error, len(batch))
+	stored := make([]bool, len(batch))
+	var accepted []int
+	for i := range batch {
+		stpRejection := resolveStpGroup(&batch[i])
+		order := batch[i]
+		if err := db.InsertOrder(order); err != nil {
+			if errors.Is(err, db.ErrDuplicateOrder) {
+				err = &risk.Rejection{Code: risk.ReasonDuplicateOrder, Message: "order id already used"}
+			}
+			results[i] = err
+			continue
+		}
+		stored[i] = true
+
+		if stpRejection != nil {
+			results[i] = stpRejection
+			continue
+		}
+		if r := risk.Evaluate(order); r != nil {
+			results[i] = r
+			continue
+		}
+		if err := positions.Reserve(&batch[i]); err != nil {
+			results[i] = err
+			continue
+		}
+		order = batch[i]
+		if err := ledger.Reserve(order); err != nil {
+			positions.Release(order)
+			results[i] = err
+			continue
+		}
+		orders.Track(order)
+		accepted = append(accepted, i)
+	}
+
+	if len(accepted) == len(batch) {
+		err := queue.IncomingOrderQueue.EnqueueBatch(batch)
+		if err == nil {
+			for _, order := range batch {
+				orders.ScheduleExpiry(order)
+				if err := db.MarkOrderSubmitted(order); err != nil {
+					log.Printf("oms: order %d of user %d submitted but not recorded: %v", order.Order_id, order.User_id, err)
+				}
+			}
+			return results, nil
+		}
+		for i := range results {
+			results[i] = err
+		}
+	}
+
+	for _, i := range accepted {
+		orders.Untrack(orders.KeyOf(batch[i]))
+		ledger.Release(batch[i])
+		positions.Release(batch[i])
+		if results[i] == nil {
+			results[i] = &risk.Rejection{Code: risk.ReasonBatchRejected, Message: "another order of the batch was rejected"}
+		}
+	}
+	for i, order := range batch {
+		if !stored[i] {
+			continue
+		}
+		var r *risk.Rejection
+		if errors.As(results[i], &r) {
+			reject(order, r.Code)
+		} else {
+			reject(order, "batch not sent")
+		}
+	}
+	return results, ErrBatchRejected
+}
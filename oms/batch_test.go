@@ -0,0 +1,94 @@
+package oms
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+)
+
+func TestSubmitBatch(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	db.InitDB()
+	q, err := queue.CreateQueue(filepath.Join(dir, "orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	queue.IncomingOrderQueue = q
+
+	// 1000.00, a buy of qty at 10.00 holds qty x 10.00
+	user, err := db.CreateUser("basket", 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buy := func(id uint64, qty uint32) structs.Order {
+		return structs.Order{User_id: user.ID, Order_id: id, Symbol: 1, Order_type: structs.OrderTypeLimit, Price: 1000, Shares_qty: qty, Timestamp: 1}
+	}
+
+	tests := []struct {
+		name  string
+		batch []structs.Order
+		codes []string // per leg rejection, all empty when the batch goes out
+	}{
+		{"one leg not covered", []structs.Order{buy(1, 10), buy(2, 20), buy(3, 90)},
+			[]string{risk.ReasonBatchRejected, risk.ReasonBatchRejected, risk.ReasonInsufficientFunds}},
+		{"the basket as a whole not covered", []structs.Order{buy(4, 60), buy(5, 60)},
+			[]string{risk.ReasonBatchRejected, risk.ReasonInsufficientFunds}},
+		{"order id already used", []structs.Order{buy(6, 10), buy(1, 10)},
+			[]string{risk.ReasonBatchRejected, risk.ReasonDuplicateOrder}},
+		{"sent", []structs.Order{buy(7, 10), buy(8, 20)}, []string{"", ""}},
+	}
+	for _, tt := range tests {
+		depth := q.Depth()
+		results, err := SubmitBatch(tt.batch)
+		sent := tt.codes[0] == ""
+		if sent != (err == nil) {
+			t.Fatalf("%s: err = %v", tt.name, err)
+		}
+		if !sent && !errors.Is(err, ErrBatchRejected) {
+			t.Errorf("%s: err = %v, want ErrBatchRejected", tt.name, err)
+		}
+		for i, code := range tt.codes {
+			var r *risk.Rejection
+			if code == "" && results[i] != nil || code != "" && (!errors.As(results[i], &r) || r.Code != code) {
+				t.Errorf("%s: leg %d = %v, want %q", tt.name, i, results[i], code)
+			}
+		}
+
+		var want uint64
+		if sent {
+			want = uint64(len(tt.batch))
+		}
+		if got := q.Depth() - depth; got != want {
+			t.Errorf("%s: %d orders enqueued, want %d", tt.name, got, want)
+		}
+		for i, order := range tt.batch {
+			if tt.codes[i] == risk.ReasonDuplicateOrder {
+				continue // the row is the earlier order's
+			}
+			_, tracked := orders.Get(order.User_id, order.Order_id)
+			reserved, _ := db.ReservedFor(order.User_id, order.Order_id)
+			record, err := db.GetOrder(order.User_id, order.Order_id)
+			if err != nil {
+				t.Fatalf("%s: leg %d not stored: %v", tt.name, i, err)
+			}
+			if sent {
+				if !tracked || reserved != decimal.Amount(order.Shares_qty)*1000 || record.Status != structs.StatusPending {
+					t.Errorf("%s: leg %d tracked %v reserved %s status %d", tt.name, i, tracked, reserved, record.Status)
+				}
+				continue
+			}
+			if tracked || reserved != 0 || record.Status != structs.StatusRejected || record.Reason != tt.codes[i] {
+				t.Errorf("%s: leg %d left tracked %v reserved %s status %d %q", tt.name, i, tracked, reserved, record.Status, record.Reason)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package oms
+
+import (
+	"log"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/structs"
+)
+
+// Cancel sends a single cancel request to the engine, reason ends up in the
+// order's history
+func Cancel(cancelOrder structs.OrderToBeCancelled, reason string) error {
+	if err := queue.CancelOrderQueue.Enqueue(cancelOrder); err != nil {
+		return err
+	}
+	if err := db.AddOrderEvent(cancelOrder.User_id, cancelOrder.Order_id, db.EventCancelRequested, 0, 0, reason); err != nil {
+		log.Printf("oms: cancel of order %d sent but not recorded: %v", cancelOrder.Order_id, err)
+	}
+	return nil
+}
+
+// CancelAll fans out one cancel per open order matching f. it stops at the
+// first enqueue failure and returns how many orders were targeted and how
+// many cancels actually went out.
+func CancelAll(f orders.Filter, reason string) (targeted, sent int, err error) {
+	open := orders.Open(f)
+	targeted = len(open)
+	for _, order := range open {
+		var cancelOrder structs.OrderToBeCancelled
+		cancelOrder.Order_id = order.Order_id
+		cancelOrder.User_id = order.User_id
+		cancelOrder.Symbol = order.Symbol
+		if err = Cancel(cancelOrder, reason); err != nil {
+			return targeted, sent, err
+		}
+		sent++
+	}
+	return targeted, sent, nil
+}
@@ -0,0 +1,53 @@
+package oms
+
+import (
+	"log"
+	"sync"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/fees"
+	"jotacomputing/go-api/ledger"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+)
+
+var (
+	executionMu       sync.RWMutex
+	executionHandlers []func(structs.Fill)
+)
+
+// OnExecution registers fn to be called once per fill after it was stored,
+// unlike feeds.OnFill it never sees a replayed fill twice
+func OnExecution(fn func(structs.Fill)) {
+	executionMu.Lock()
+	executionHandlers = append(executionHandlers, fn)
+	executionMu.Unlock()
+}
+
+// OnFill persists an execution with its fees and applies it everywhere that
+// keeps state from fills, registered with feeds.OnFill. a fill that was
+// already stored (replayed ring) is skipped entirely so nothing is booked
+// twice.
+func OnFill(fill structs.Fill) {
+	inserted, err := db.InsertFill(fill, fees.Charge)
+	if err != nil {
+		log.Printf("oms: failed to store fill %d: %v", fill.Trade_id, err)
+		return
+	}
+	if !inserted {
+		return
+	}
+
+	orders.ApplyFill(fill.Buy_user_id, fill.Buy_order_id, fill.Shares_qty)
+	orders.ApplyFill(fill.Sell_user_id, fill.Sell_order_id, fill.Shares_qty)
+	ledger.ApplyFill(fill)
+	risk.ApplyFill(fill)
+
+	executionMu.RLock()
+	handlers := executionHandlers
+	executionMu.RUnlock()
+	for _, fn := range handlers {
+		fn(fill)
+	}
+}
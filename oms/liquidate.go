@@ -0,0 +1,49 @@
+package oms
+
+import (
+	"errors"
+	"log"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/positions"
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+)
+
+// Liquidate sends an order the gateway generated to close out part of a
+// position of an account in margin deficit. the account can't pass the
+// pre-trade limits and the order only reduces exposure, so they are skipped.
+// halts and sessions still apply, a refused liquidation comes back as a
+// *risk.Rejection before anything is stored. a sell holds its shares like any
+// other so it can't overlap the account's own sells.
+func Liquidate(order structs.Order) error {
+	if r := risk.CheckGates(order); r != nil {
+		return r
+	}
+	if err := db.InsertOrder(order); err != nil {
+		return err
+	}
+	if err := positions.ReserveNow(&order); err != nil {
+		var r *risk.Rejection
+		if errors.As(err, &r) {
+			reject(order, r.Code)
+		} else {
+			reject(order, "reservation failed")
+		}
+		return err
+	}
+
+	orders.Track(order)
+	if err := queue.IncomingOrderQueue.Enqueue(order); err != nil {
+		orders.Untrack(orders.KeyOf(order))
+		positions.Release(order)
+		reject(order, "queue full")
+		return err
+	}
+	if err := db.MarkOrderSubmitted(order); err != nil {
+		log.Printf("oms: liquidation %d of user %d submitted but not recorded: %v", order.Order_id, order.User_id, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package oms
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/ledger"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/positions"
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+)
+
+var (
+	ErrReplaceTimeout = errors.New("no answer from the engine yet")
+	ErrNotOpen        = errors.New("order not found or no longer open")
+)
+
+// Amended is current as it would be after replace, a zero price or quantity
+// leaves that field as it is
+func Amended(current structs.Order, replace structs.OrderReplace) structs.Order {
+	amended := current
+	if replace.New_price != 0 {
+		amended.Price = replace.New_price
+	}
+	if replace.New_qty != 0 {
+		amended.Shares_qty = replace.New_qty
+	}
+	return amended
+}
+
+// Replace sends an amend and waits up to timeout for the engine to accept or
+// reject it. accepted is only meaningful when err is nil. the amended order
+// goes through the pre-trade checks with its new price and quantity, a sell
+// raised above the available position and a buy raised beyond buying power
+// fail too, all with a *risk.Rejection before anything is sent.
+func Replace(replace structs.OrderReplace, timeout time.Duration) (accepted bool, update structs.Order, err error) {
+	current, err := checkAmend(replace)
+	if err != nil {
+		return false, update, err
+	}
+
+	key := orders.Key{User_id: replace.User_id, Order_id: replace.Order_id}
+	updates, done := orders.Await(key)
+	defer done()
+
+	if err := sendAmend(current, replace); err != nil {
+		return false, update, err
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case update = <-updates:
+			switch {
+			case update.Status == structs.StatusReplaced:
+				return true, update, nil
+			case update.Status == structs.StatusReplaceRejected, update.IsTerminal():
+				// a fill or cancel that wins the race also means the amend is dead
+				return false, update, nil
+			}
+		case <-deadline.C:
+			return false, update, ErrReplaceTimeout
+		}
+	}
+}
+
+// Amend is Replace without waiting, for the feed handlers that would block
+// the answer they wait for. the engine's answer comes through the status feed.
+func Amend(replace structs.OrderReplace) error {
+	current, err := checkAmend(replace)
+	if err != nil {
+		return err
+	}
+	return sendAmend(current, replace)
+}
+
+// checkAmend runs the checks of an amend and reserves what it needs, it
+// returns the order as it is now
+func checkAmend(replace structs.OrderReplace) (structs.Order, error) {
+	// locked before reading the order, a concurrent amend reserves against
+	// what it sees
+	unlock := lockUser(replace.User_id)
+	defer unlock()
+	current, ok := orders.Get(replace.User_id, replace.Order_id)
+	if !ok {
+		return current, ErrNotOpen
+	}
+	amended := Amended(current, replace)
+	if r := risk.Evaluate(amended); r != nil {
+		return current, r
+	}
+	if r := positions.CheckAmend(replace); r != nil {
+		return current, r
+	}
+	// a buy raised in price or quantity needs the difference in buying power
+	if err := ledger.ReserveAmend(current, amended); err != nil {
+		return current, err
+	}
+	return current, nil
+}
+
+func sendAmend(current structs.Order, replace structs.OrderReplace) error {
+	if err := queue.ReplaceOrderQueue.Enqueue(replace); err != nil {
+		ledger.CancelAmend(current)
+		return err
+	}
+	if err := db.AddOrderEvent(replace.User_id, replace.Order_id, db.EventReplaceRequested, replace.New_price, replace.New_qty, ""); err != nil {
+		log.Printf("oms: amend of order %d sent but not recorded: %v", replace.Order_id, err)
+	}
+	return nil
+}
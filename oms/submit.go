@@ -0,0 +1,172 @@
+package oms
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/ledger"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/positions"
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+)
+
+// Submit runs the pre-trade checks, sends the order to the engine and starts
+// tracking it. every path that produces engine orders (API, stop triggers, ...)
+// goes through here or SubmitNow. a failed check comes back as a *risk.Rejection.
+//
+// the order is persisted first so a rejected order is on record too, and an
+// order id can only ever be used once per user.
+func Submit(order structs.Order) error {
+	return submit(order, db.InsertOrder, positions.Reserve, false)
+}
+
+// SubmitNow is Submit for the feed handlers and schedulers, it never waits
+// for an account's holdings to arrive
+func SubmitNow(order structs.Order) error {
+	return submit(order, db.InsertOrder, positions.ReserveNow, false)
+}
+
+// SubmitTriggered is SubmitNow for the order released by a stop. its row was
+// stored when the stop was placed, the stop is marked triggered in the same
+// write that turns the row into the released order, before anything is sent.
+// only a *risk.Rejection is recorded as a rejection, on any other error the
+// caller arms the stop again and the row has to stay open.
+func SubmitTriggered(order structs.Order) error {
+	return submit(order, db.TriggerStopOrder, positions.ReserveNow, true)
+}
+
+var (
+	userMu sync.Mutex
+	users  = make(map[uint64]*sync.Mutex)
+)
+
+// lockUser serializes one account's checks up to Track, the limits count the
+// tracked orders so two checked at once could both pass the last free slot
+func lockUser(userID uint64) func() {
+	userMu.Lock()
+	l, ok := users[userID]
+	if !ok {
+		l = new(sync.Mutex)
+		users[userID] = l
+	}
+	userMu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// with rearm set a failure that isn't a rejection is left unrecorded, the
+// stop the order came from is armed again
+func submit(order structs.Order, store func(structs.Order) error, reserve func(*structs.Order) error, rearm bool) error {
+	// resolved up front so the stored order carries the group sent to the engine
+	stpRejection := resolveStpGroup(&order)
+	if err := store(order); err != nil {
+		if errors.Is(err, db.ErrDuplicateOrder) {
+			return &risk.Rejection{Code: risk.ReasonDuplicateOrder, Message: "order id already used"}
+		}
+		return err
+	}
+
+	if stpRejection != nil {
+		reject(order, stpRejection.Code)
+		return stpRejection
+	}
+	unlock := lockUser(order.User_id)
+	defer unlock()
+	if r := risk.Evaluate(order); r != nil {
+		reject(order, r.Code)
+		return r
+	}
+	// shares first, a sell beyond the holdings is flagged short and a short on
+	// a margin account needs margin from the ledger
+	if err := reserve(&order); err != nil {
+		var r *risk.Rejection
+		if errors.As(err, &r) {
+			reject(order, r.Code)
+		} else if !rearm {
+			reject(order, "reservation failed")
+		}
+		return err
+	}
+	if err := ledger.Reserve(order); err != nil {
+		positions.Release(order)
+		var r *risk.Rejection
+		if errors.As(err, &r) {
+			reject(order, r.Code)
+		} else if !rearm {
+			reject(order, "reservation failed")
+		}
+		return err
+	}
+
+	// track first, the engine can answer before Enqueue even returns
+	orders.Track(order)
+	if err := queue.IncomingOrderQueue.Enqueue(order); err != nil {
+		orders.Untrack(orders.KeyOf(order))
+		ledger.Release(order)
+		positions.Release(order)
+		if !rearm {
+			reject(order, "queue full")
+		}
+		return err
+	}
+	orders.ScheduleExpiry(order)
+
+	if err := db.MarkOrderSubmitted(order); err != nil {
+		log.Printf("oms: order %d of user %d submitted but not recorded: %v", order.Order_id, order.User_id, err)
+	}
+	return nil
+}
+
+// resolveStpGroup defaults the STP group from the account. an explicit group
+// must be the account's own, so nobody can join another firm's group and have
+// the engine cancel their orders.
+func resolveStpGroup(order *structs.Order) *risk.Rejection {
+	if order.Stp_mode == structs.StpNone {
+		order.Stp_group = 0
+		return nil
+	}
+	group, err := db.UserStpGroup(order.User_id)
+	if err != nil {
+		log.Printf("oms: failed to load stp group of user %d: %v", order.User_id, err)
+		return &risk.Rejection{Code: risk.ReasonStpGroup, Message: "could not resolve stp group"}
+	}
+	if order.Stp_group == 0 {
+		order.Stp_group = group
+	} else if order.Stp_group != group && order.Stp_group != order.User_id {
+		return &risk.Rejection{Code: risk.ReasonStpGroup, Message: fmt.Sprintf("stp_group %d does not belong to this account", order.Stp_group)}
+	}
+	return nil
+}
+
+func reject(order structs.Order, reason string) {
+	if err := db.MarkOrderRejected(order, reason); err != nil {
+		log.Printf("oms: failed to record rejection of order %d of user %d: %v", order.Order_id, order.User_id, err)
+	}
+}
+
+// OnStatus persists every engine status update, registered with feeds.OnStatus
+func OnStatus(update structs.Order) {
+	if err := db.RecordStatusUpdate(update); err != nil {
+		log.Printf("oms: failed to record status %d of order %d: %v", update.Status, update.Order_id, err)
+	}
+}
+
+// Restore puts the orders the engine may still be working back into the
+// tracker after a restart, each with the quantity still working on the book
+func Restore() error {
+	open, err := db.LoadOpenOrders()
+	if err != nil {
+		return err
+	}
+	for _, order := range open {
+		orders.Track(order)
+		orders.ScheduleExpiry(order)
+	}
+	log.Printf("oms: restored %d open orders", len(open))
+	return nil
+}
@@ -0,0 +1,190 @@
+package orders
+
+import (
+	"container/heap"
+	"log"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/structs"
+)
+
+// DAY and GTD orders are cancelled by the gateway when they lapse.
+// DAY orders are swept when their calendar closes, GTD orders have their own
+// time: pending expiries sit in a min-heap ordered by expiry time, a single
+// goroutine sleeps until the earliest one is due.
+
+type expiry struct {
+	key      Key
+	symbol   uint32
+	expireAt int64
+	reason   string
+	index    int
+}
+
+type expiryHeap []*expiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expireAt < h[j].expireAt }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*expiry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+var (
+	expMu    sync.Mutex
+	expiries expiryHeap
+	byKey    = make(map[Key]*expiry)
+	wake     = make(chan struct{}, 1)
+)
+
+// how long an expiry whose cancel couldn't be sent waits before the next try
+const expiryRetry = time.Second
+
+// ScheduleExpiry registers a GTD order for cancellation, other orders are
+// ignored. DAY orders go at the session close, see ExpireDayOrders.
+func ScheduleExpiry(order structs.Order) {
+	if order.Time_in_force != structs.TifGTD {
+		return
+	}
+	expireAt := int64(order.Expire_at)
+
+	expMu.Lock()
+	key := KeyOf(order)
+	if old, ok := byKey[key]; ok {
+		heap.Remove(&expiries, old.index)
+	}
+	e := &expiry{key: key, symbol: order.Symbol, expireAt: expireAt, reason: "GTD expired"}
+	heap.Push(&expiries, e)
+	byKey[key] = e
+	expMu.Unlock()
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+func unscheduleExpiry(key Key) {
+	expMu.Lock()
+	if e, ok := byKey[key]; ok {
+		heap.Remove(&expiries, e.index)
+		delete(byKey, key)
+	}
+	expMu.Unlock()
+}
+
+// StartExpiryScheduler runs the expiry loop in the background
+func StartExpiryScheduler() {
+	go func() {
+		timer := time.NewTimer(time.Hour)
+		for {
+			for _, e := range popDue(time.Now().UnixNano()) {
+				if !expire(e) {
+					retry(e)
+				}
+			}
+
+			next := time.Hour
+			expMu.Lock()
+			if len(expiries) > 0 {
+				next = time.Until(time.Unix(0, expiries[0].expireAt))
+			}
+			expMu.Unlock()
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(next)
+			select {
+			case <-timer.C:
+			case <-wake:
+			}
+		}
+	}()
+}
+
+func popDue(now int64) []*expiry {
+	expMu.Lock()
+	defer expMu.Unlock()
+	var due []*expiry
+	for len(expiries) > 0 && expiries[0].expireAt <= now {
+		e := heap.Pop(&expiries).(*expiry)
+		delete(byKey, e.key)
+		due = append(due, e)
+	}
+	return due
+}
+
+// ExpireDayOrders cancels every open DAY order selected by match right away,
+// called at the end of the trading session
+func ExpireDayOrders(match func(structs.Order) bool) int {
+	n := 0
+	for _, order := range Open(Filter{}) {
+		if order.Time_in_force != structs.TifDAY || !match(order) {
+			continue
+		}
+		e := &expiry{key: KeyOf(order), symbol: order.Symbol, reason: "DAY order, session closed"}
+		if !expire(e) {
+			retry(e)
+		}
+		n++
+	}
+	return n
+}
+
+// expire sends the cancel of a lapsed order, false if it couldn't be sent
+func expire(e *expiry) bool {
+	if _, ok := Get(e.key.User_id, e.key.Order_id); !ok {
+		return true // filled or cancelled in the meantime
+	}
+
+	var cancelOrder structs.OrderToBeCancelled
+	cancelOrder.Order_id = e.key.Order_id
+	cancelOrder.User_id = e.key.User_id
+	cancelOrder.Symbol = e.symbol
+	if err := queue.CancelOrderQueue.Enqueue(cancelOrder); err != nil {
+		log.Printf("expiry: failed to cancel order %d of user %d, retrying: %v", e.key.Order_id, e.key.User_id, err)
+		return false
+	}
+	if err := db.AddOrderEvent(e.key.User_id, e.key.Order_id, db.EventCancelRequested, 0, 0, e.reason); err != nil {
+		log.Printf("expiry: cancel of order %d sent but not recorded: %v", e.key.Order_id, err)
+	}
+	return true
+}
+
+// retry puts an expiry back in the heap to be tried again after expiryRetry,
+// unless the order was scheduled again in the meantime
+func retry(e *expiry) {
+	expMu.Lock()
+	if _, ok := byKey[e.key]; !ok {
+		e.expireAt = time.Now().Add(expiryRetry).UnixNano()
+		heap.Push(&expiries, e)
+		byKey[e.key] = e
+	}
+	expMu.Unlock()
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
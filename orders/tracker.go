@@ -0,0 +1,129 @@
+package orders
+
+import (
+	"sync"
+
+	"jotacomputing/go-api/structs"
+)
+
+// Key identifies an order, order ids are only unique per user
+type Key struct {
+	User_id  uint64
+	Order_id uint64
+}
+
+func KeyOf(order structs.Order) Key {
+	return Key{User_id: order.User_id, Order_id: order.Order_id}
+}
+
+// the gateway's view of every order it sent to the engine that hasn't
+// reached a terminal status yet
+var (
+	mu   sync.RWMutex
+	open = make(map[Key]structs.Order)
+)
+
+// Track records an order that was just enqueued to the engine
+func Track(order structs.Order) {
+	mu.Lock()
+	open[KeyOf(order)] = order
+	mu.Unlock()
+}
+
+// Untrack forgets an order that never made it to the engine
+func Untrack(key Key) {
+	mu.Lock()
+	delete(open, key)
+	mu.Unlock()
+}
+
+// Get returns an open order
+func Get(userID, orderID uint64) (structs.Order, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	order, ok := open[Key{User_id: userID, Order_id: orderID}]
+	return order, ok
+}
+
+// Filter selects open orders, zero fields match everything
+type Filter struct {
+	User_id uint64
+	Symbol  uint32
+	Side    *uint8
+}
+
+// Matches reports whether order is selected by f
+func (f Filter) Matches(order structs.Order) bool {
+	if f.User_id != 0 && order.User_id != f.User_id {
+		return false
+	}
+	if f.Symbol != 0 && order.Symbol != f.Symbol {
+		return false
+	}
+	if f.Side != nil && order.Side != *f.Side {
+		return false
+	}
+	return true
+}
+
+// Open returns the open orders matching f
+func Open(f Filter) []structs.Order {
+	mu.RLock()
+	defer mu.RUnlock()
+	var result []structs.Order
+	for _, order := range open {
+		if f.Matches(order) {
+			result = append(result, order)
+		}
+	}
+	return result
+}
+
+// OnStatus keeps the tracker in line with the engine, registered with feeds.OnStatus
+func OnStatus(update structs.Order) {
+	key := KeyOf(update)
+
+	mu.Lock()
+	order, ok := open[key]
+	if ok {
+		switch {
+		case update.IsTerminal():
+			delete(open, key)
+		case update.Status == structs.StatusReplaced:
+			order.Price = update.Price
+			order.Shares_qty = update.Shares_qty
+			order.Status = structs.StatusPending
+			open[key] = order
+		case update.Status == structs.StatusReplaceRejected:
+			// the order itself is untouched
+		default:
+			order.Status = update.Status
+			open[key] = order
+		}
+	}
+	mu.Unlock()
+
+	notify(key, update)
+
+	if ok && update.IsTerminal() {
+		unscheduleExpiry(key)
+	}
+}
+
+// ApplyFill takes executed quantity off an open order so the tracker holds
+// what is still working on the book
+func ApplyFill(userID, orderID uint64, qty uint32) {
+	key := Key{User_id: userID, Order_id: orderID}
+	mu.Lock()
+	defer mu.Unlock()
+	order, ok := open[key]
+	if !ok {
+		return
+	}
+	if qty >= order.Shares_qty {
+		order.Shares_qty = 0
+	} else {
+		order.Shares_qty -= qty
+	}
+	open[key] = order
+}
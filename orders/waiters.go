@@ -0,0 +1,53 @@
+package orders
+
+import (
+	"sync"
+
+	"jotacomputing/go-api/structs"
+)
+
+// handlers that need the engine's answer synchronously (amends) register a
+// waiter before enqueueing and get every status update for that order.
+var (
+	waitMu  sync.Mutex
+	waiters = make(map[Key][]chan structs.Order)
+)
+
+// Await returns a channel receiving status updates for key. call the returned
+// func once done so the waiter is dropped.
+func Await(key Key) (<-chan structs.Order, func()) {
+	ch := make(chan structs.Order, 4)
+
+	waitMu.Lock()
+	waiters[key] = append(waiters[key], ch)
+	waitMu.Unlock()
+
+	return ch, func() {
+		waitMu.Lock()
+		defer waitMu.Unlock()
+		list := waiters[key]
+		for i, c := range list {
+			if c == ch {
+				list = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(waiters, key)
+		} else {
+			waiters[key] = list
+		}
+	}
+}
+
+func notify(key Key, update structs.Order) {
+	waitMu.Lock()
+	defer waitMu.Unlock()
+	for _, ch := range waiters[key] {
+		select {
+		case ch <- update:
+		default:
+			// a slow waiter only misses updates, it never blocks the feed
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package positions
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/queue"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+)
+
+// holdings per user and symbol, seeded from the engine's answers to holdings
+// queries and kept current from executions. sells hold shares from submission
+// until the engine is done with them, so the same holdings can't back two
+// orders. the legs of an order group (oco, bracket exits) only ever execute
+// one of them, they share a single hold sized to the largest leg.
+
+const (
+	// how long a sell waits for the first holdings snapshot of an account
+	snapshotWait = 2 * time.Second
+	// fills are kept this long so one taken before a snapshot but applied
+	// after it was requested isn't lost when the snapshot lands
+	replayWindow = time.Minute
+	// query ids of the gateway's own holdings queries, clients use small ids
+	queryIDBit = uint64(1) << 63
+)
+
+type delta struct {
+	ts     uint64 // engine time of the fill
+	symbol uint32
+	qty    int64
+}
+
+type account struct {
+	loaded bool
+	asOf   uint64           // engine time of the last snapshot
+	held   map[uint32]int64 // quantity ticks, negative when short
+	recent []delta
+	ready  chan struct{} // closed by the first snapshot
+}
+
+type holdKey struct {
+	user   uint64
+	symbol uint32
+}
+
+type queryKey struct {
+	user  uint64
+	query uint64
+}
+
+type hold struct {
+	symbol uint32
+	qty    uint64                // held, the largest of legs
+	legs   map[orders.Key]uint64 // what each order still needs
+	shared bool                  // linked by Share, kept until Unshare
+}
+
+var (
+	mu       sync.Mutex
+	accounts = make(map[uint64]*account)
+	holds    = make(map[orders.Key]*hold) // per open sell order, one for all legs of a group
+	held     = make(map[holdKey]uint64)   // sum of holds per user and symbol
+	// holdings answers being collected
+	partial  = make(map[queryKey]map[uint32]int64)
+	querySeq uint64
+)
+
+// Position is one line of an account's holdings
+type Position struct {
+	Symbol    uint32
+	Qty       int64  // held, negative when short
+	Reserved  uint64 // tied up in open sell orders
+	Available int64
+}
+
+// accountOf returns the user's account, mu must be held
+func accountOf(userID uint64) *account {
+	a, ok := accounts[userID]
+	if !ok {
+		a = &account{held: make(map[uint32]int64), ready: make(chan struct{})}
+		accounts[userID] = a
+	}
+	return a
+}
+
+// Request asks the engine for the user's holdings, the answer arrives through
+// OnQueryResponse
+func Request(userID uint64) error {
+	query := structs.Query{
+		Query_id:   queryIDBit | atomic.AddUint64(&querySeq, 1),
+		User_id:    userID,
+		Query_type: 1, // get holdings
+	}
+	return queue.QueriesQueue.Enqueue(query)
+}
+
+// Load asks for the holdings of every account so sells don't have to wait
+// for a snapshot, and puts the holds of restored sell orders back. called
+// after oms.Restore.
+func Load() error {
+	ids, err := db.ListUserIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := Request(id); err != nil {
+			return err
+		}
+	}
+
+	sell := uint8(1)
+	open := orders.Open(orders.Filter{Side: &sell})
+	mu.Lock()
+	defer mu.Unlock()
+	for _, order := range open {
+		// restored with what is still working, fills and amends included
+		if order.Shares_qty > 0 {
+			addHold(order, uint64(order.Shares_qty))
+		}
+	}
+	log.Printf("positions: requested holdings of %d accounts, %d open sells", len(ids), len(open))
+	return nil
+}
+
+// addHold reserves qty of the order's symbol, mu must be held
+func addHold(order structs.Order, qty uint64) {
+	key := orders.KeyOf(order)
+	h, ok := holds[key]
+	if !ok {
+		h = &hold{legs: make(map[orders.Key]uint64)}
+		holds[key] = h
+	}
+	h.symbol = order.Symbol
+	h.legs[key] = qty
+	resize(h, key.User_id)
+}
+
+// shrinkHold takes qty off an order's hold, everything if qty is 0. mu must be held
+func shrinkHold(key orders.Key, qty uint64) {
+	h, ok := holds[key]
+	if !ok {
+		return
+	}
+	leg, ok := h.legs[key]
+	if !ok {
+		return
+	}
+	if qty == 0 || qty > leg {
+		qty = leg
+	}
+	if leg -= qty; leg == 0 {
+		delete(h.legs, key)
+	} else {
+		h.legs[key] = leg
+	}
+	resize(h, key.User_id)
+	if leg == 0 && !h.shared {
+		delete(holds, key)
+	}
+}
+
+// resize brings a hold and the user's total up to date with its legs, mu
+// must be held
+func resize(h *hold, userID uint64) {
+	var qty uint64
+	for _, leg := range h.legs {
+		if leg > qty {
+			qty = leg
+		}
+	}
+	k := holdKey{userID, h.symbol}
+	held[k] += qty - h.qty
+	if held[k] == 0 {
+		delete(held, k)
+	}
+	h.qty = qty
+}
+
+// Share makes the orders use a single hold, for order groups where only one
+// of them can execute. orders already holding shares are merged in. called
+// before any of them is sent.
+func Share(keys ...orders.Key) {
+	mu.Lock()
+	defer mu.Unlock()
+	shared := &hold{legs: make(map[orders.Key]uint64), shared: true}
+	var userID uint64
+	for _, key := range keys {
+		userID = key.User_id
+		if h, ok := holds[key]; ok {
+			if leg, ok := h.legs[key]; ok {
+				shared.symbol = h.symbol
+				shared.legs[key] = leg
+				delete(h.legs, key)
+				resize(h, key.User_id)
+			}
+		}
+		holds[key] = shared
+	}
+	resize(shared, userID)
+}
+
+// Unshare forgets a group once all its orders are done
+func Unshare(keys ...orders.Key) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, key := range keys {
+		h, ok := holds[key]
+		if !ok || !h.shared {
+			continue
+		}
+		if _, working := h.legs[key]; !working {
+			delete(holds, key)
+			continue
+		}
+		// still held, goes away with its last fill or status
+		h.shared = false
+	}
+}
+
+// waitLoaded makes sure the user's holdings are known, requesting them if
+// this is the first time the account is seen. without wait an unknown
+// account only gets its snapshot requested for the next try.
+func waitLoaded(userID uint64, wait bool) bool {
+	mu.Lock()
+	a := accountOf(userID)
+	loaded, ready := a.loaded, a.ready
+	mu.Unlock()
+	if loaded {
+		return true
+	}
+	if err := Request(userID); err != nil {
+		log.Printf("positions: failed to request holdings of user %d: %v", userID, err)
+		return false
+	}
+	if !wait {
+		return false
+	}
+	select {
+	case <-ready:
+		return true
+	case <-time.After(snapshotWait):
+		return false
+	}
+}
+
+// Reserve holds the shares a sell order needs, buys pass straight through. a
+// sell above what is available is rejected, or flagged as a short sale on
+// accounts allowed to short. a refused order comes back as a *risk.Rejection.
+func Reserve(order *structs.Order) error {
+	return reserve(order, true)
+}
+
+// ReserveNow is Reserve for callers that must not block, like the feed
+// handlers: an account whose holdings aren't known yet is refused right away
+func ReserveNow(order *structs.Order) error {
+	return reserve(order, false)
+}
+
+func reserve(order *structs.Order, wait bool) error {
+	if order.Side != 1 {
+		return nil
+	}
+	if !waitLoaded(order.User_id, wait) {
+		return &risk.Rejection{Code: risk.ReasonPositionUnknown, Message: "holdings not available yet, try again"}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	a := accountOf(order.User_id)
+	available := a.held[order.Symbol] - int64(held[holdKey{order.User_id, order.Symbol}])
+	// a group's hold already covers its other legs
+	need := uint64(order.Shares_qty)
+	if h, ok := holds[orders.KeyOf(*order)]; ok {
+		if need > h.qty {
+			need -= h.qty
+		} else {
+			need = 0
+		}
+	}
+	if int64(need) > available {
+		canShort, err := db.UserCanShort(order.User_id)
+		if err != nil {
+			return err
+		}
+		if !canShort {
+			spec := symbols.Lookup(order.Symbol)
+			if available < 0 {
+				available = 0
+			}
+			return &risk.Rejection{
+				Code: risk.ReasonInsufficientPosition,
+				Message: "sell of " + decimal.Format(uint64(order.Shares_qty), spec.QtyScale) +
+					", available " + decimal.Format(uint64(available), spec.QtyScale),
+			}
+		}
+		order.Short_sale = 1
+	}
+	addHold(*order, uint64(order.Shares_qty))
+	return nil
+}
+
+// Release drops the hold of a sell that never reached the engine
+func Release(order structs.Order) {
+	mu.Lock()
+	shrinkHold(orders.KeyOf(order), 0)
+	mu.Unlock()
+}
+
+// OnStatus frees the rest of a hold once the engine is done with the order
+// and follows amends, registered with feeds.OnStatus
+func OnStatus(update structs.Order) {
+	key := orders.KeyOf(update)
+	mu.Lock()
+	defer mu.Unlock()
+	switch {
+	case update.IsTerminal():
+		shrinkHold(key, 0)
+	case update.Status == structs.StatusReplaced:
+		h, ok := holds[key]
+		if !ok {
+			return
+		}
+		// an amend only goes up after CheckAmend let it through
+		if _, ok := h.legs[key]; ok {
+			h.legs[key] = uint64(update.Shares_qty)
+			resize(h, key.User_id)
+		}
+	}
+}
+
+// CheckAmend refuses an amend that would raise a sell above what is
+// available, unless the account may short
+func CheckAmend(replace structs.OrderReplace) *risk.Rejection {
+	mu.Lock()
+	h, ok := holds[orders.Key{User_id: replace.User_id, Order_id: replace.Order_id}]
+	if !ok || uint64(replace.New_qty) <= h.qty {
+		mu.Unlock()
+		return nil
+	}
+	available := accountOf(replace.User_id).held[h.symbol] - int64(held[holdKey{replace.User_id, h.symbol}])
+	extra := uint64(replace.New_qty) - h.qty
+	mu.Unlock()
+
+	if int64(extra) <= available {
+		return nil
+	}
+	if canShort, err := db.UserCanShort(replace.User_id); err == nil && canShort {
+		return nil
+	}
+	return &risk.Rejection{Code: risk.ReasonInsufficientPosition, Message: "amended quantity exceeds the available position"}
+}
+
+// OnExecution moves holdings for both sides of a fill, registered with
+// oms.OnExecution so a replayed fill is never counted twice
+func OnExecution(fill structs.Fill) {
+	qty := uint64(fill.Shares_qty)
+	mu.Lock()
+	defer mu.Unlock()
+	apply(fill.Buy_user_id, delta{ts: fill.Timestamp, symbol: fill.Symbol, qty: int64(qty)})
+	apply(fill.Sell_user_id, delta{ts: fill.Timestamp, symbol: fill.Symbol, qty: -int64(qty)})
+	shrinkHold(orders.Key{User_id: fill.Sell_user_id, Order_id: fill.Sell_order_id}, qty)
+}
+
+// apply books a fill on an account, mu must be held
+func apply(userID uint64, d delta) {
+	a := accountOf(userID)
+	if a.loaded && d.ts > a.asOf {
+		a.held[d.symbol] += d.qty
+	}
+	a.recent = append(a.recent, d)
+	if d.ts < uint64(replayWindow) {
+		return
+	}
+	i := 0
+	for i < len(a.recent) && a.recent[i].ts < d.ts-uint64(replayWindow) {
+		i++
+	}
+	a.recent = a.recent[i:]
+}
+
+// OnQueryResponse collects the answers to holdings queries and replaces the
+// account's holdings once the last one is in, registered with
+// feeds.OnQueryResponse
+func OnQueryResponse(response structs.QueryResponse) {
+	if response.Query_type != 1 {
+		return
+	}
+	k := queryKey{response.User_id, response.Query_id}
+	mu.Lock()
+	defer mu.Unlock()
+
+	snapshot, ok := partial[k]
+	if !ok {
+		snapshot = make(map[uint32]int64)
+		partial[k] = snapshot
+	}
+	if response.Symbol != 0 {
+		snapshot[response.Symbol] = int64(response.Amount)
+	}
+	if response.Last == 0 {
+		return
+	}
+	delete(partial, k)
+
+	a := accountOf(response.User_id)
+	if a.loaded && response.Timestamp < a.asOf {
+		return // an older answer overtaken by a newer one
+	}
+	// fills after the snapshot was taken may already have been seen
+	for _, d := range a.recent {
+		if d.ts > response.Timestamp {
+			snapshot[d.symbol] += d.qty
+		}
+	}
+	a.held = snapshot
+	a.asOf = response.Timestamp
+	if !a.loaded {
+		a.loaded = true
+		close(a.ready)
+	}
+}
+
+// Of returns the user's cached holdings, false if no snapshot arrived yet
+func Of(userID uint64) ([]Position, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	a, ok := accounts[userID]
+	if !ok || !a.loaded {
+		return nil, false
+	}
+
+	seen := make(map[uint32]bool)
+	var list []Position
+	add := func(symbol uint32) {
+		if seen[symbol] {
+			return
+		}
+		seen[symbol] = true
+		reserved := held[holdKey{userID, symbol}]
+		qty := a.held[symbol]
+		list = append(list, Position{Symbol: symbol, Qty: qty, Reserved: reserved, Available: qty - int64(reserved)})
+	}
+	for symbol, qty := range a.held {
+		if qty != 0 {
+			add(symbol)
+		}
+	}
+	for k := range held {
+		if k.user == userID {
+			add(k.symbol)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Symbol < list[j].Symbol })
+	return list, true
+}
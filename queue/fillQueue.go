@@ -0,0 +1,230 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"unsafe"
+	"github.com/edsrzf/mmap-go"
+	"jotacomputing/go-api/structs"
+	
+	"log"
+)
+
+type FillQueueHeader struct {
+	ProducerHead uint64   // Offset 0 4 byte interger 
+	_pad1        [56]byte // Padding to cache line
+	ConsumerTail uint64   // Offset 64
+	_pad2        [56]byte // Padding
+	Magic        uint32   // Offset 128
+	Capacity     uint32   // Offset 132
+}
+
+const FillSize = unsafe.Sizeof(structs.Fill{})
+const FillHeaderSize = unsafe.Sizeof(FillQueueHeader{})
+const TotalFillSize = FillHeaderSize + (QueueCapacity * FillSize)
+
+type FillQueue struct {
+	file   *os.File
+	mmap   mmap.MMap   // this is the array of bytes wich we will use to read and write 
+	header *FillQueueHeader
+	fills []structs.Fill
+}
+
+
+// the engine publishes every execution here with both sides, we only ever consume
+func InitFillQueue(filePath string) {
+	fmt.Println("[INIT] Initializing fills queue...")
+
+	q, err := CreateFillQueue(filePath)
+	if err != nil {
+		log.Fatalf("Failed to create fills queue: %v", err)
+	}
+	defer q.Close()
+
+	fmt.Printf("[INIT] Fills queue initialized successfully\n")
+	fmt.Printf("[INIT] Capacity: %d fills\n", q.Capacity())
+	fmt.Printf("[INIT] File: %s (size: ~4.5 MB)\n", filePath)
+}
+
+func CreateFillQueue(filePath string) (*FillQueue, error) {
+	_ = os.Remove(filePath)
+
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	// set the size of the file
+	if err := file.Truncate(int64(TotalFillSize)); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to truncate file: %w", err)
+	}
+
+	// sync to disk before mmap
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to sync file: %w", err)
+	}
+	// m is just a byte array that is mapped to the real file on the Ram 
+	m, err := mmap.Map(file, mmap.RDWR, 0)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to mmap: %w", err)
+	}
+
+	// try to lock in RAM
+	if err := m.Lock(); err != nil {
+		// proceed without locking;
+		// caller may tune ulimit -l / CAP_IPC_LOCK
+	}
+
+	// initialize header
+	header := (*FillQueueHeader)(unsafe.Pointer(&m[0]))
+	atomic.StoreUint64(&header.ProducerHead, 0)
+	atomic.StoreUint64(&header.ConsumerTail, 0)
+	atomic.StoreUint32(&header.Magic, QueueMagic)
+	atomic.StoreUint32(&header.Capacity, QueueCapacity)
+
+	// flush to disk
+	if err := m.Flush(); err != nil {
+		m.Unlock()
+		m.Unmap()
+		file.Close()
+		return nil, fmt.Errorf("failed to flush mmap: %w", err)
+	}
+
+	fillsData := m[int(FillHeaderSize):int(TotalFillSize)]
+	if len(fillsData) == 0 {
+		m.Unlock()
+		m.Unmap()
+		file.Close()
+		return nil, fmt.Errorf("fills region empty")
+	}
+	fills := unsafe.Slice((*structs.Fill)(unsafe.Pointer(&fillsData[0])), QueueCapacity)
+
+	return &FillQueue{
+		file:   file,
+		mmap:   m,
+		header: header,
+		fills: fills,
+	}, nil
+}
+
+func OpenFillQueue(filePath string) (*FillQueue, error) {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	// verify file size
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if stat.Size() != int64(TotalFillSize) {
+		file.Close()
+		return nil, fmt.Errorf("invalid file size: got %d, expected %d", stat.Size(), int64(TotalFillSize))
+	}
+
+	m, err := mmap.Map(file, mmap.RDWR, 0)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to mmap: %w", err)
+	}
+
+	if err := m.Lock(); err != nil {
+		// non-fatal; continue without lock
+	}
+
+	// validate header
+	header := (*FillQueueHeader)(unsafe.Pointer(&m[0]))
+	if atomic.LoadUint32(&header.Magic) != QueueMagic {
+		m.Unlock()
+		m.Unmap()
+		file.Close()
+		return nil, fmt.Errorf("invalid queue magic number")
+	}
+	if atomic.LoadUint32(&header.Capacity) != QueueCapacity {
+		m.Unlock()
+		m.Unmap()
+		file.Close()
+		return nil, fmt.Errorf("capacity mismatch: file=%d code=%d", header.Capacity, QueueCapacity)
+	}
+
+	fillsData := m[int(FillHeaderSize):int(TotalFillSize)]
+	if len(fillsData) == 0 {
+		m.Unlock()
+		m.Unmap()
+		file.Close()
+		return nil, fmt.Errorf("fills region empty")
+	}
+	fills := unsafe.Slice((*structs.Fill)(unsafe.Pointer(&fillsData[0])), QueueCapacity)
+
+	return &FillQueue{
+		file:   file,
+		mmap:   m,
+		header: header,
+		fills: fills,
+	}, nil
+}
+
+func (q *FillQueue) Enqueue(fill structs.Fill) error {
+	consumerTail := atomic.LoadUint64(&q.header.ConsumerTail)
+	producerHead := atomic.LoadUint64(&q.header.ProducerHead)
+
+	nextHead := producerHead + 1
+	if nextHead-consumerTail > QueueCapacity {
+		return fmt.Errorf("queue full - consumer too slow, backpressure at depth %d/%d",
+			nextHead-consumerTail, QueueCapacity)
+	}
+
+	pos := producerHead % QueueCapacity
+	q.fills[pos] = fill
+
+	// Publish after write; seq-cst store is sufficient
+	atomic.StoreUint64(&q.header.ProducerHead, nextHead)
+	return nil
+}
+func (q *FillQueue) Dequeue() (*structs.Fill, error) {
+	producerHead := atomic.LoadUint64(&q.header.ProducerHead)
+	consumerTail := atomic.LoadUint64(&q.header.ConsumerTail)
+
+	if consumerTail == producerHead {
+		return nil, nil
+	}
+
+	pos := consumerTail % QueueCapacity
+	fill := q.fills[pos]
+
+	// Mark consumed; seq-cst store is sufficient
+	atomic.StoreUint64(&q.header.ConsumerTail, consumerTail+1)
+	return &fill, nil
+}
+
+func (q *FillQueue) Depth() uint64 {
+	producerHead := atomic.LoadUint64(&q.header.ProducerHead)
+	consumerTail := atomic.LoadUint64(&q.header.ConsumerTail)
+	return producerHead - consumerTail
+}
+
+func (q *FillQueue) Capacity() uint64 {
+	return QueueCapacity
+}
+
+func (q *FillQueue) Flush() error {
+	return q.mmap.Flush()
+}
+
+func (q *FillQueue) Close() error {
+	_ = q.mmap.Flush()
+	_ = q.mmap.Unlock()
+	if err := q.mmap.Unmap(); err != nil {
+		_ = q.file.Close()
+		return fmt.Errorf("failed to unmap: %w", err)
+	}
+	return q.file.Close()
+}
+
+
@@ -10,7 +10,12 @@ var (
 	// Global queues - opened once at startup
 	IncomingOrderQueue *Queue
 	CancelOrderQueue   *CancelQueue
+	ReplaceOrderQueue  *ReplaceQueue
 	QueriesQueue       *QueryQueue
+	TradeFeedQueue     *TradeQueue
+	OrderStatusQueue   *Queue
+	FillsQueue         *FillQueue
+	QueryResponses     *QueryResponseQueue
 )
 
 // Initialize ALL queues at startup
@@ -28,11 +33,38 @@ func InitQueues() error {
 	if err != nil {
 		return fmt.Errorf("failed to open cancel order queue: %v", err)
 	}
+	// Open replace order queue ONCE
+	ReplaceOrderQueue, err = OpenReplaceQueue(utils.ReplaceOrderQueuePath)
+	if err != nil {
+		return fmt.Errorf("failed to open replace order queue: %v", err)
+	}
 	// Open queries queue ONCE
 	QueriesQueue, err = OpenQueryQueue(utils.QueryQueuePath)
 	if err != nil {
 		return fmt.Errorf("failed to open query queue: %v", err)
 	}
+	// Open order status queue ONCE
+	OrderStatusQueue, err = OpenQueue(utils.OrderStatusQueuePath)
+	if err != nil {
+		return fmt.Errorf("failed to open order status queue: %v", err)
+	}
+	// Open trade feed queue ONCE
+	TradeFeedQueue, err = OpenTradeQueue(utils.TradeFeedQueuePath)
+	if err != nil {
+		return fmt.Errorf("failed to open trade feed queue: %v", err)
+	}
+
+	// Open fills queue ONCE
+	FillsQueue, err = OpenFillQueue(utils.FillQueuePath)
+	if err != nil {
+		return fmt.Errorf("failed to open fills queue: %v", err)
+	}
+
+	// Open query response queue ONCE
+	QueryResponses, err = OpenQueryResponseQueue(utils.QueryResQueuePath)
+	if err != nil {
+		return fmt.Errorf("failed to open query response queue: %v", err)
+	}
 
 	log.Println("✅ All queues initialized successfully")
 	return nil
@@ -46,8 +78,23 @@ func CloseQueues() {
 	if CancelOrderQueue != nil {
 		CancelOrderQueue.Close()
 	}
+	if ReplaceOrderQueue != nil {
+		ReplaceOrderQueue.Close()
+	}
 	if QueriesQueue != nil {
 		QueriesQueue.Close()
 	}
+	if OrderStatusQueue != nil {
+		OrderStatusQueue.Close()
+	}
+	if TradeFeedQueue != nil {
+		TradeFeedQueue.Close()
+	}
+	if FillsQueue != nil {
+		FillsQueue.Close()
+	}
+	if QueryResponses != nil {
+		QueryResponses.Close()
+	}
 	
 }
\ No newline at end of file
@@ -3,6 +3,7 @@ package queue
 import (
 	"fmt"
 	"os"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 	"github.com/edsrzf/mmap-go"
@@ -34,6 +35,8 @@ type Queue struct {
 	mmap   mmap.MMap   // this is the array of bytes wich we will use to read and write 
 	header *QueueHeader
 	orders []structs.Order
+	// the ring has a single producer slot, handlers enqueue from many goroutines
+	mu     sync.Mutex
 }
 
 
@@ -193,6 +196,8 @@ func OpenQueue(filePath string) (*Queue, error) {
 }
 
 func (q *Queue) Enqueue(order structs.Order) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 	consumerTail := atomic.LoadUint64(&q.header.ConsumerTail)
 	producerHead := atomic.LoadUint64(&q.header.ProducerHead)
 
@@ -210,6 +215,29 @@ func (q *Queue) Enqueue(order structs.Order) error {
 	return nil
 }
 
+// EnqueueBatch writes all orders and publishes them with a single head update,
+// the consumer sees either none or all of them. fails without writing anything
+// if the ring doesn't have room for the whole batch.
+func (q *Queue) EnqueueBatch(orders []structs.Order) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	consumerTail := atomic.LoadUint64(&q.header.ConsumerTail)
+	producerHead := atomic.LoadUint64(&q.header.ProducerHead)
+
+	nextHead := producerHead + uint64(len(orders))
+	if nextHead-consumerTail > QueueCapacity {
+		return fmt.Errorf("queue full - batch of %d needs room, depth %d/%d",
+			len(orders), producerHead-consumerTail, QueueCapacity)
+	}
+
+	for i, order := range orders {
+		q.orders[(producerHead+uint64(i))%QueueCapacity] = order
+	}
+
+	atomic.StoreUint64(&q.header.ProducerHead, nextHead)
+	return nil
+}
+
 func (q *Queue) Dequeue() (*structs.Order, error) {
 	producerHead := atomic.LoadUint64(&q.header.ProducerHead)
 	consumerTail := atomic.LoadUint64(&q.header.ConsumerTail)
@@ -232,6 +260,11 @@ func (q *Queue) Depth() uint64 {
 	return producerHead - consumerTail
 }
 
+// Free is how many more orders fit in the ring right now
+func (q *Queue) Free() uint64 {
+	return QueueCapacity - q.Depth()
+}
+
 func (q *Queue) Capacity() uint64 {
 	return QueueCapacity
 }
@@ -0,0 +1,230 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"unsafe"
+	"github.com/edsrzf/mmap-go"
+	"jotacomputing/go-api/structs"
+	
+	"log"
+)
+
+type QueryResponseQueueHeader struct {
+	ProducerHead uint64   // Offset 0 4 byte interger 
+	_pad1        [56]byte // Padding to cache line
+	ConsumerTail uint64   // Offset 64
+	_pad2        [56]byte // Padding
+	Magic        uint32   // Offset 128
+	Capacity     uint32   // Offset 132
+}
+
+const QueryResponseSize = unsafe.Sizeof(structs.QueryResponse{})
+const QueryResponseHeaderSize = unsafe.Sizeof(QueryResponseQueueHeader{})
+const TotalQueryResponseSize = QueryResponseHeaderSize + (QueueCapacity * QueryResponseSize)
+
+type QueryResponseQueue struct {
+	file   *os.File
+	mmap   mmap.MMap   // this is the array of bytes wich we will use to read and write 
+	header *QueryResponseQueueHeader
+	responses []structs.QueryResponse
+}
+
+
+// the engine answers balance and holdings queries here, we only ever consume
+func InitQueryResponseQueue(filePath string) {
+	fmt.Println("[INIT] Initializing query response queue...")
+
+	q, err := CreateQueryResponseQueue(filePath)
+	if err != nil {
+		log.Fatalf("Failed to create query response queue: %v", err)
+	}
+	defer q.Close()
+
+	fmt.Printf("[INIT] Query response queue initialized successfully\n")
+	fmt.Printf("[INIT] Capacity: %d responses\n", q.Capacity())
+	fmt.Printf("[INIT] File: %s (size: ~2.6 MB)\n", filePath)
+}
+
+func CreateQueryResponseQueue(filePath string) (*QueryResponseQueue, error) {
+	_ = os.Remove(filePath)
+
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	// set the size of the file
+	if err := file.Truncate(int64(TotalQueryResponseSize)); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to truncate file: %w", err)
+	}
+
+	// sync to disk before mmap
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to sync file: %w", err)
+	}
+	// m is just a byte array that is mapped to the real file on the Ram 
+	m, err := mmap.Map(file, mmap.RDWR, 0)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to mmap: %w", err)
+	}
+
+	// try to lock in RAM
+	if err := m.Lock(); err != nil {
+		// proceed without locking;
+		// caller may tune ulimit -l / CAP_IPC_LOCK
+	}
+
+	// initialize header
+	header := (*QueryResponseQueueHeader)(unsafe.Pointer(&m[0]))
+	atomic.StoreUint64(&header.ProducerHead, 0)
+	atomic.StoreUint64(&header.ConsumerTail, 0)
+	atomic.StoreUint32(&header.Magic, QueueMagic)
+	atomic.StoreUint32(&header.Capacity, QueueCapacity)
+
+	// flush to disk
+	if err := m.Flush(); err != nil {
+		m.Unlock()
+		m.Unmap()
+		file.Close()
+		return nil, fmt.Errorf("failed to flush mmap: %w", err)
+	}
+
+	responsesData := m[int(QueryResponseHeaderSize):int(TotalQueryResponseSize)]
+	if len(responsesData) == 0 {
+		m.Unlock()
+		m.Unmap()
+		file.Close()
+		return nil, fmt.Errorf("responses region empty")
+	}
+	responses := unsafe.Slice((*structs.QueryResponse)(unsafe.Pointer(&responsesData[0])), QueueCapacity)
+
+	return &QueryResponseQueue{
+		file:   file,
+		mmap:   m,
+		header: header,
+		responses: responses,
+	}, nil
+}
+
+func OpenQueryResponseQueue(filePath string) (*QueryResponseQueue, error) {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	// verify file size
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if stat.Size() != int64(TotalQueryResponseSize) {
+		file.Close()
+		return nil, fmt.Errorf("invalid file size: got %d, expected %d", stat.Size(), int64(TotalQueryResponseSize))
+	}
+
+	m, err := mmap.Map(file, mmap.RDWR, 0)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to mmap: %w", err)
+	}
+
+	if err := m.Lock(); err != nil {
+		// non-fatal; continue without lock
+	}
+
+	// validate header
+	header := (*QueryResponseQueueHeader)(unsafe.Pointer(&m[0]))
+	if atomic.LoadUint32(&header.Magic) != QueueMagic {
+		m.Unlock()
+		m.Unmap()
+		file.Close()
+		return nil, fmt.Errorf("invalid queue magic number")
+	}
+	if atomic.LoadUint32(&header.Capacity) != QueueCapacity {
+		m.Unlock()
+		m.Unmap()
+		file.Close()
+		return nil, fmt.Errorf("capacity mismatch: file=%d code=%d", header.Capacity, QueueCapacity)
+	}
+
+	responsesData := m[int(QueryResponseHeaderSize):int(TotalQueryResponseSize)]
+	if len(responsesData) == 0 {
+		m.Unlock()
+		m.Unmap()
+		file.Close()
+		return nil, fmt.Errorf("responses region empty")
+	}
+	responses := unsafe.Slice((*structs.QueryResponse)(unsafe.Pointer(&responsesData[0])), QueueCapacity)
+
+	return &QueryResponseQueue{
+		file:   file,
+		mmap:   m,
+		header: header,
+		responses: responses,
+	}, nil
+}
+
+func (q *QueryResponseQueue) Enqueue(response structs.QueryResponse) error {
+	consumerTail := atomic.LoadUint64(&q.header.ConsumerTail)
+	producerHead := atomic.LoadUint64(&q.header.ProducerHead)
+
+	nextHead := producerHead + 1
+	if nextHead-consumerTail > QueueCapacity {
+		return fmt.Errorf("queue full - consumer too slow, backpressure at depth %d/%d",
+			nextHead-consumerTail, QueueCapacity)
+	}
+
+	pos := producerHead % QueueCapacity
+	q.responses[pos] = response
+
+	// Publish after write; seq-cst store is sufficient
+	atomic.StoreUint64(&q.header.ProducerHead, nextHead)
+	return nil
+}
+func (q *QueryResponseQueue) Dequeue() (*structs.QueryResponse, error) {
+	producerHead := atomic.LoadUint64(&q.header.ProducerHead)
+	consumerTail := atomic.LoadUint64(&q.header.ConsumerTail)
+
+	if consumerTail == producerHead {
+		return nil, nil
+	}
+
+	pos := consumerTail % QueueCapacity
+	response := q.responses[pos]
+
+	// Mark consumed; seq-cst store is sufficient
+	atomic.StoreUint64(&q.header.ConsumerTail, consumerTail+1)
+	return &response, nil
+}
+
+func (q *QueryResponseQueue) Depth() uint64 {
+	producerHead := atomic.LoadUint64(&q.header.ProducerHead)
+	consumerTail := atomic.LoadUint64(&q.header.ConsumerTail)
+	return producerHead - consumerTail
+}
+
+func (q *QueryResponseQueue) Capacity() uint64 {
+	return QueueCapacity
+}
+
+func (q *QueryResponseQueue) Flush() error {
+	return q.mmap.Flush()
+}
+
+func (q *QueryResponseQueue) Close() error {
+	_ = q.mmap.Flush()
+	_ = q.mmap.Unlock()
+	if err := q.mmap.Unmap(); err != nil {
+		_ = q.file.Close()
+		return fmt.Errorf("failed to unmap: %w", err)
+	}
+	return q.file.Close()
+}
+
+
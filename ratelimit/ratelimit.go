@@ -0,0 +1,311 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/db"
+)
+
+// Class groups endpoints that share a throttle
+type Class int
+
+const (
+	ClassOrder Class = iota
+	ClassCancel
+	ClassQuery
+)
+
+// the order-to-cancel ratio is measured over fixed windows of this length,
+// and a few cancels are always allowed before the ratio kicks in
+const (
+	ratioWindow = time.Minute
+	ratioGrace  = 10
+	tierTTL     = time.Minute
+)
+
+// the endpoints without a token (login, registration) have no account to take
+// a tier from, they are throttled per client ip at one fixed rate, slow enough
+// to make guessing passwords pointless
+const (
+	ipPerSec = 0.2
+	ipBurst  = 10
+)
+
+type rate struct {
+	perSec float64
+	burst  float64
+}
+
+type tier struct {
+	rates          [3]rate
+	maxCancelRatio int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type ratio struct {
+	windowStart time.Time
+	orders      int
+	cancels     int
+}
+
+type cachedTier struct {
+	name    string
+	fetched time.Time
+}
+
+var (
+	mu        sync.Mutex
+	tiers     = make(map[string]tier)
+	buckets   = make(map[uint64]*[3]bucket)
+	ratios    = make(map[uint64]*ratio)
+	ipBuckets = make(map[string]*bucket)
+	lastPrune time.Time
+
+	// cached tier names, under their own lock since they are filled from the
+	// database. taken after mu when both are needed.
+	tierMu    sync.Mutex
+	userTiers = make(map[uint64]cachedTier)
+)
+
+// Decision is the outcome of Allow, enough to fill the X-RateLimit-* headers
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request can pass, when refused
+	Reason     string
+}
+
+// Load reads the tier table into memory
+func Load() error {
+	rows, err := db.ListRateTiers()
+	if err != nil {
+		return err
+	}
+	next := make(map[string]tier)
+	for _, t := range rows {
+		next[t.Tier] = tier{
+			rates: [3]rate{
+				ClassOrder:  {float64(t.OrderPerSec), float64(t.OrderBurst)},
+				ClassCancel: {float64(t.CancelPerSec), float64(t.CancelBurst)},
+				ClassQuery:  {float64(t.QueryPerSec), float64(t.QueryBurst)},
+			},
+			maxCancelRatio: t.MaxCancelRatio,
+		}
+	}
+	mu.Lock()
+	tiers = next
+	mu.Unlock()
+	return nil
+}
+
+// tierName resolves a user's tier. the name is cached for a minute so a tier
+// change applies without a restart, the lookup runs without mu so a slow
+// database doesn't stall every other request.
+func tierName(userID uint64, now time.Time) string {
+	tierMu.Lock()
+	cached, ok := userTiers[userID]
+	tierMu.Unlock()
+	if ok && now.Sub(cached.fetched) <= tierTTL {
+		return cached.name
+	}
+
+	name, err := db.UserTier(userID)
+	if err != nil {
+		log.Printf("ratelimit: tier lookup for user %d: %v", userID, err)
+		name = db.DefaultTier
+	}
+	tierMu.Lock()
+	userTiers[userID] = cachedTier{name: name, fetched: now}
+	tierMu.Unlock()
+	return name
+}
+
+// tierOf returns the limits of a tier, the default one for unknown names.
+// mu must be held.
+func tierOf(name string) tier {
+	if t, ok := tiers[name]; ok {
+		return t
+	}
+	return tiers[db.DefaultTier]
+}
+
+// Allow takes one token from the user's bucket for class
+func Allow(userID uint64, class Class) Decision {
+	return AllowN(userID, class, 1)
+}
+
+// AllowN takes n tokens at once, for requests that stand for several orders.
+// either all n are taken or none.
+func AllowN(userID uint64, class Class, n int) Decision {
+	now := time.Now()
+	name := tierName(userID, now)
+
+	mu.Lock()
+	defer mu.Unlock()
+	prune(now)
+
+	t := tierOf(name)
+	r := t.rates[class]
+	d := Decision{Limit: int(r.burst)}
+	if r.perSec <= 0 || r.burst <= 0 {
+		// unconfigured tier, don't lock the user out
+		d.Allowed = true
+		return d
+	}
+
+	userBuckets := buckets[userID]
+	if userBuckets == nil {
+		userBuckets = &[3]bucket{}
+		for i := range userBuckets {
+			userBuckets[i] = bucket{tokens: t.rates[i].burst, last: now}
+		}
+		buckets[userID] = userBuckets
+	}
+	b := &userBuckets[class]
+	b.tokens = math.Min(r.burst, b.tokens+now.Sub(b.last).Seconds()*r.perSec)
+	b.last = now
+
+	if float64(n) > r.burst {
+		d.Remaining = int(b.tokens)
+		d.Reset = seconds((r.burst - b.tokens) / r.perSec)
+		d.Reason = "request needs more than the burst allows"
+		return d
+	}
+	if b.tokens < float64(n) {
+		d.RetryAfter = seconds((float64(n) - b.tokens) / r.perSec)
+		d.Reset = seconds((r.burst - b.tokens) / r.perSec)
+		d.Reason = "rate limit exceeded"
+		return d
+	}
+
+	if ok, retry := checkRatio(userID, class, n, t, now); !ok {
+		d.Remaining = int(b.tokens)
+		d.RetryAfter = retry
+		d.Reason = "cancel to order ratio exceeded"
+		return d
+	}
+
+	b.tokens -= float64(n)
+	d.Allowed = true
+	d.Remaining = int(b.tokens)
+	d.Reset = seconds((r.burst - b.tokens) / r.perSec)
+	return d
+}
+
+// AllowIP takes one token from the bucket of a client ip, for the endpoints
+// that run before there is a user
+func AllowIP(ip string) Decision {
+	now := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	prune(now)
+
+	b := ipBuckets[ip]
+	if b == nil {
+		b = &bucket{tokens: ipBurst, last: now}
+		ipBuckets[ip] = b
+	}
+	b.tokens = math.Min(ipBurst, b.tokens+now.Sub(b.last).Seconds()*ipPerSec)
+	b.last = now
+
+	d := Decision{Limit: ipBurst}
+	if b.tokens < 1 {
+		d.RetryAfter = seconds((1 - b.tokens) / ipPerSec)
+		d.Reset = seconds((ipBurst - b.tokens) / ipPerSec)
+		d.Reason = "rate limit exceeded"
+		return d
+	}
+	b.tokens--
+	d.Allowed = true
+	d.Remaining = int(b.tokens)
+	d.Reset = seconds((ipBurst - b.tokens) / ipPerSec)
+	return d
+}
+
+// checkRatio counts orders and cancels per window. once cancels run past
+// maxCancelRatio per order, new orders are throttled until the window ends.
+// cancels always pass, nobody should be stuck with orders they want out of.
+// mu must be held.
+func checkRatio(userID uint64, class Class, n int, t tier, now time.Time) (bool, time.Duration) {
+	if class == ClassQuery || t.maxCancelRatio <= 0 {
+		return true, 0
+	}
+	rt := ratios[userID]
+	if rt == nil || now.Sub(rt.windowStart) >= ratioWindow {
+		rt = &ratio{windowStart: now}
+		ratios[userID] = rt
+	}
+
+	if class == ClassCancel {
+		rt.cancels += n
+		return true, 0
+	}
+	if rt.cancels >= ratioGrace && rt.cancels > rt.orders*t.maxCancelRatio {
+		return false, rt.windowStart.Add(ratioWindow).Sub(now)
+	}
+	rt.orders += n
+	return true, 0
+}
+
+// prune drops the state of users that went quiet: ratio windows that are over,
+// user and ip buckets that filled up again and expired tier names. it sweeps
+// at most once per ratio window, mu must be held.
+func prune(now time.Time) {
+	if now.Sub(lastPrune) < ratioWindow {
+		return
+	}
+	lastPrune = now
+
+	for userID, rt := range ratios {
+		if now.Sub(rt.windowStart) >= ratioWindow {
+			delete(ratios, userID)
+		}
+	}
+	for ip, b := range ipBuckets {
+		if b.tokens+now.Sub(b.last).Seconds()*ipPerSec >= ipBurst {
+			delete(ipBuckets, ip)
+		}
+	}
+
+	tierMu.Lock()
+	defer tierMu.Unlock()
+	for userID, userBuckets := range buckets {
+		t := tiers[db.DefaultTier]
+		if cached, ok := userTiers[userID]; ok {
+			t = tierOf(cached.name)
+		}
+		if refilled(userBuckets, t, now) {
+			delete(buckets, userID)
+		}
+	}
+	for userID, cached := range userTiers {
+		if now.Sub(cached.fetched) > tierTTL {
+			delete(userTiers, userID)
+		}
+	}
+}
+
+// refilled reports whether every bucket would be full by now, a new set
+// would be the same
+func refilled(userBuckets *[3]bucket, t tier, now time.Time) bool {
+	for i, b := range userBuckets {
+		r := t.rates[i]
+		if r.perSec > 0 && b.tokens+now.Sub(b.last).Seconds()*r.perSec < r.burst {
+			return false
+		}
+	}
+	return true
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
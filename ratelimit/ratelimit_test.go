@@ -0,0 +1,152 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"jotacomputing/go-api/db"
+)
+
+// reset gives every test a fresh limiter with user 1 on tier "test", the tier
+// name is cached so no database is needed
+func reset(t tier) {
+	mu.Lock()
+	tiers = map[string]tier{db.DefaultTier: {}, "test": t}
+	buckets = make(map[uint64]*[3]bucket)
+	ratios = make(map[uint64]*ratio)
+	ipBuckets = make(map[string]*bucket)
+	mu.Unlock()
+	tierMu.Lock()
+	userTiers = map[uint64]cachedTier{1: {name: "test", fetched: time.Now().Add(time.Hour)}}
+	tierMu.Unlock()
+}
+
+// a slow refill so nothing comes back while a test runs
+var testTier = tier{
+	rates: [3]rate{
+		ClassOrder:  {perSec: 0.001, burst: 5},
+		ClassCancel: {perSec: 0.001, burst: 20},
+		ClassQuery:  {perSec: 0.001, burst: 2},
+	},
+	maxCancelRatio: 2,
+}
+
+func TestAllowN(t *testing.T) {
+	tests := []struct {
+		name  string
+		class Class
+		takes []int  // AllowN calls in order
+		want  []bool // whether each passed
+		last  string // Reason of the last call
+	}{
+		{"burst then refused", ClassOrder, []int{1, 1, 1, 1, 1, 1}, []bool{true, true, true, true, true, false}, "rate limit exceeded"},
+		{"all or nothing", ClassOrder, []int{3, 3, 2}, []bool{true, false, true}, ""},
+		{"more than the burst", ClassOrder, []int{6, 5}, []bool{false, true}, ""},
+		{"classes have their own buckets", ClassQuery, []int{2, 1}, []bool{true, false}, "rate limit exceeded"},
+	}
+	for _, tt := range tests {
+		reset(testTier)
+		var d Decision
+		for i, n := range tt.takes {
+			d = AllowN(1, tt.class, n)
+			if d.Allowed != tt.want[i] {
+				t.Errorf("%s: call %d (n=%d) allowed = %v, want %v (%s)", tt.name, i, n, d.Allowed, tt.want[i], d.Reason)
+			}
+		}
+		if d.Reason != tt.last {
+			t.Errorf("%s: last reason = %q, want %q", tt.name, d.Reason, tt.last)
+		}
+	}
+
+	reset(testTier)
+	d := AllowN(1, ClassOrder, 6)
+	if d.Reason != "request needs more than the burst allows" || d.Remaining != 5 {
+		t.Errorf("oversized request: %+v", d)
+	}
+	AllowN(1, ClassOrder, 5)
+	if d := Allow(1, ClassOrder); d.RetryAfter <= 0 || d.Limit != 5 {
+		t.Errorf("empty bucket: %+v, want a retry time and the burst as limit", d)
+	}
+}
+
+func TestUnconfiguredTier(t *testing.T) {
+	reset(tier{})
+	for i := 0; i < 100; i++ {
+		if d := Allow(1, ClassOrder); !d.Allowed {
+			t.Fatalf("call %d refused on a tier without rates: %+v", i, d)
+		}
+	}
+}
+
+func TestCancelRatio(t *testing.T) {
+	tests := []struct {
+		name    string
+		orders  int
+		cancels int
+		allowed bool
+	}{
+		{"within the grace", 0, ratioGrace - 1, true},
+		{"past the grace without orders", 0, ratioGrace, false},
+		{"at the ratio", 5, 10, true},
+		{"above the ratio", 4, 10, false},
+	}
+	for _, tt := range tests {
+		reset(testTier)
+		// orders first so the bucket isn't what refuses
+		for i := 0; i < tt.orders; i++ {
+			Allow(1, ClassOrder)
+		}
+		mu.Lock()
+		if b := buckets[1]; b != nil {
+			b[ClassOrder].tokens = 5
+		}
+		mu.Unlock()
+		for i := 0; i < tt.cancels; i++ {
+			if d := Allow(1, ClassCancel); !d.Allowed {
+				t.Fatalf("%s: cancel %d refused: %s", tt.name, i, d.Reason)
+			}
+		}
+		d := Allow(1, ClassOrder)
+		if d.Allowed != tt.allowed {
+			t.Errorf("%s: order allowed = %v, want %v (%s)", tt.name, d.Allowed, tt.allowed, d.Reason)
+		}
+		if !tt.allowed && (d.Reason != "cancel to order ratio exceeded" || d.RetryAfter <= 0 || d.RetryAfter > ratioWindow) {
+			t.Errorf("%s: refusal %+v, want the ratio and the rest of the window", tt.name, d)
+		}
+	}
+}
+
+func TestRefilled(t *testing.T) {
+	now := time.Now()
+	r := tier{rates: [3]rate{{perSec: 1, burst: 10}, {perSec: 1, burst: 10}, {}}}
+	tests := []struct {
+		name    string
+		buckets [3]bucket
+		want    bool
+	}{
+		{"full, unconfigured class ignored", [3]bucket{{10, now}, {10, now}, {0, now}}, true},
+		{"refilled since", [3]bucket{{0, now.Add(-10 * time.Second)}, {10, now}, {0, now}}, true},
+		{"still refilling", [3]bucket{{0, now.Add(-9 * time.Second)}, {10, now}, {0, now}}, false},
+	}
+	for _, tt := range tests {
+		if got := refilled(&tt.buckets, r, now); got != tt.want {
+			t.Errorf("%s: refilled = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAllowIP(t *testing.T) {
+	reset(testTier)
+	for i := 0; i < ipBurst; i++ {
+		if d := AllowIP("192.0.2.1"); !d.Allowed {
+			t.Fatalf("request %d refused: %+v", i, d)
+		}
+	}
+	d := AllowIP("192.0.2.1")
+	if d.Allowed || d.RetryAfter <= 0 {
+		t.Errorf("past the burst: %+v, want refused with a retry time", d)
+	}
+	if d := AllowIP("192.0.2.2"); !d.Allowed || d.Remaining != ipBurst-1 {
+		t.Errorf("another ip: %+v, want its own bucket", d)
+	}
+}
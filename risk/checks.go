@@ -0,0 +1,117 @@
+package risk
+
+import (
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/feeds"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+)
+
+// ReferencePrice is the price an order is valued at: its limit price, or the
+// last trade for market orders.
+func ReferencePrice(order structs.Order) (uint64, bool) {
+	if order.Price != 0 {
+		return order.Price, true
+	}
+	return feeds.LastPrice(order.Symbol)
+}
+
+type maxOrderQty struct{}
+
+func (maxOrderQty) Name() string { return LimitMaxOrderQty }
+
+func (maxOrderQty) Check(order structs.Order, limits Limits) *Rejection {
+	if limits.MaxOrderQty == 0 || uint64(order.Shares_qty) <= limits.MaxOrderQty {
+		return nil
+	}
+	spec := symbols.Lookup(order.Symbol)
+	return reject(ReasonMaxOrderQty, "order quantity %s above limit %s",
+		decimal.Format(uint64(order.Shares_qty), spec.QtyScale), decimal.Format(limits.MaxOrderQty, spec.QtyScale))
+}
+
+type maxNotional struct{}
+
+func (maxNotional) Name() string { return LimitMaxNotional }
+
+func (maxNotional) Check(order structs.Order, limits Limits) *Rejection {
+	if limits.MaxNotional == 0 {
+		return nil
+	}
+	price, ok := ReferencePrice(order)
+	if !ok {
+		return reject(ReasonNoReferencePrice, "no last trade price to value market order on symbol %d", order.Symbol)
+	}
+	spec := symbols.Lookup(order.Symbol)
+	notional, err := decimal.Notional(price, spec.PriceScale, uint64(order.Shares_qty), spec.QtyScale, true)
+	if err != nil || notional > limits.MaxNotional {
+		return reject(ReasonMaxNotional, "order notional %s above limit %s", notional, limits.MaxNotional)
+	}
+	return nil
+}
+
+type maxOpenOrders struct{}
+
+func (maxOpenOrders) Name() string { return LimitMaxOpenOrders }
+
+func (maxOpenOrders) Check(order structs.Order, limits Limits) *Rejection {
+	if limits.MaxOpenOrders == 0 {
+		return nil
+	}
+	// an amend replaces its own open order, it doesn't add one
+	n := 0
+	for _, open := range orders.Open(orders.Filter{User_id: order.User_id}) {
+		if orders.KeyOf(open) != orders.KeyOf(order) {
+			n++
+		}
+	}
+	if n >= limits.MaxOpenOrders {
+		return reject(ReasonMaxOpenOrders, "%d open orders, limit is %d", n, limits.MaxOpenOrders)
+	}
+	return nil
+}
+
+type positionLimit struct{}
+
+func (positionLimit) Name() string { return LimitMaxPosition }
+
+// the position the user would end up with if this order and every open order
+// on the same side filled. an amended order counts with its new quantity only.
+func (positionLimit) Check(order structs.Order, limits Limits) *Rejection {
+	if limits.MaxPosition == 0 {
+		return nil
+	}
+	side := order.Side
+	pending := int64(order.Shares_qty)
+	for _, open := range orders.Open(orders.Filter{User_id: order.User_id, Symbol: order.Symbol, Side: &side}) {
+		if orders.KeyOf(open) != orders.KeyOf(order) {
+			pending += int64(open.Shares_qty)
+		}
+	}
+	projected := Position(order.User_id, order.Symbol)
+	if side == 0 {
+		projected += pending
+	} else {
+		projected -= pending
+	}
+	if abs(projected) > limits.MaxPosition {
+		spec := symbols.Lookup(order.Symbol)
+		return reject(ReasonPositionLimit, "projected position %s on symbol %d above limit %s",
+			decimal.FormatSigned(projected, spec.QtyScale), order.Symbol, decimal.Format(limits.MaxPosition, spec.QtyScale))
+	}
+	return nil
+}
+
+type dailyLoss struct{}
+
+func (dailyLoss) Name() string { return LimitMaxDailyLoss }
+
+func (dailyLoss) Check(order structs.Order, limits Limits) *Rejection {
+	if limits.MaxDailyLoss == 0 {
+		return nil
+	}
+	if pnl := DailyPnL(order.User_id); pnl < 0 && -pnl >= limits.MaxDailyLoss {
+		return reject(ReasonDailyLossLimit, "daily loss %s reached limit %s", -pnl, limits.MaxDailyLoss)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package risk
+
+import (
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/feeds"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+)
+
+// exposure keeps a net position and pnl per user and symbol from executions
+// seen by the gateway, this is what the position and daily loss checks use.
+
+type posKey struct {
+	user   uint64
+	symbol uint32
+}
+
+type position struct {
+	qty      int64          // signed quantity ticks, short is negative
+	cost     decimal.Amount // signed cost basis of qty
+	realized decimal.Amount // realized pnl since the start of the day
+}
+
+var (
+	posMu     sync.Mutex
+	positions = make(map[posKey]*position)
+	pnlDay    = dayOf(time.Now())
+)
+
+func dayOf(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// rollDay resets realized pnl at the first access of a new day, posMu must be held
+func rollDay() {
+	today := dayOf(time.Now())
+	if today == pnlDay {
+		return
+	}
+	pnlDay = today
+	for _, p := range positions {
+		p.realized = 0
+	}
+}
+
+// ApplyFill books both sides of an execution
+func ApplyFill(fill structs.Fill) {
+	RecordFill(fill.Buy_user_id, fill.Symbol, 0, fill.Price, uint64(fill.Shares_qty))
+	RecordFill(fill.Sell_user_id, fill.Symbol, 1, fill.Price, uint64(fill.Shares_qty))
+}
+
+// loadExposure rebuilds positions and today's realized pnl from the stored
+// executions, so a restart doesn't forget what was traded
+func loadExposure() error {
+	execs, err := db.ListAllExecutions()
+	if err != nil {
+		return err
+	}
+
+	posMu.Lock()
+	defer posMu.Unlock()
+	positions = make(map[posKey]*position)
+	pnlDay = dayOf(time.Now())
+	for _, e := range execs {
+		today := dayOf(time.Unix(0, e.Created_at)) == pnlDay
+		record(e.User_id, e.Symbol, e.Side, e.Price, uint64(e.Shares_qty), today)
+	}
+	log.Printf("risk: rebuilt exposure from %d executions", len(execs))
+	return nil
+}
+
+// RecordFill applies one execution to the user's position
+func RecordFill(userID uint64, symbol uint32, side uint8, price, qty uint64) {
+	posMu.Lock()
+	defer posMu.Unlock()
+	rollDay()
+	record(userID, symbol, side, price, qty, true)
+}
+
+// record applies an execution, realized pnl only counts when today is set.
+// posMu must be held.
+func record(userID uint64, symbol uint32, side uint8, price, qty uint64, today bool) {
+	spec := symbols.Lookup(symbol)
+	notional := func(q uint64) decimal.Amount {
+		n, err := decimal.Notional(price, spec.PriceScale, q, spec.QtyScale, false)
+		if err != nil {
+			log.Printf("risk: fill notional out of range for user %d symbol %d: %v", userID, symbol, err)
+		}
+		return n
+	}
+
+	key := posKey{userID, symbol}
+	p := positions[key]
+	if p == nil {
+		p = &position{}
+		positions[key] = p
+	}
+
+	dir := int64(1)
+	if side == 1 {
+		dir = -1
+	}
+	remaining := qty
+
+	// closing part: realize pnl against the average cost
+	if p.qty != 0 && (p.qty > 0) != (dir > 0) {
+		open := abs(p.qty)
+		closeQty := remaining
+		if closeQty > open {
+			closeQty = open
+		}
+		portion := decimal.Amount(mulDiv(int64(p.cost), int64(closeQty), int64(open)))
+		held := int64(1)
+		if p.qty < 0 {
+			held = -1
+		}
+		if today {
+			p.realized += decimal.Amount(held)*notional(closeQty) - portion
+		}
+		p.cost -= portion
+		p.qty += dir * int64(closeQty)
+		remaining -= closeQty
+	}
+
+	// opening part
+	if remaining > 0 {
+		p.qty += dir * int64(remaining)
+		p.cost += decimal.Amount(dir) * notional(remaining)
+	}
+}
+
+// Position returns the net position (quantity ticks) of a user in a symbol
+func Position(userID uint64, symbol uint32) int64 {
+	posMu.Lock()
+	defer posMu.Unlock()
+	if p := positions[posKey{userID, symbol}]; p != nil {
+		return p.qty
+	}
+	return 0
+}
+
+// DailyPnL is today's realized pnl plus open positions marked to the last trade
+func DailyPnL(userID uint64) decimal.Amount {
+	posMu.Lock()
+	defer posMu.Unlock()
+	rollDay()
+
+	var pnl decimal.Amount
+	for key, p := range positions {
+		if key.user != userID {
+			continue
+		}
+		pnl += p.realized
+		if p.qty == 0 {
+			continue
+		}
+		last, ok := feeds.LastPrice(key.symbol)
+		if !ok {
+			continue
+		}
+		spec := symbols.Lookup(key.symbol)
+		mv, err := decimal.Notional(last, spec.PriceScale, abs(p.qty), spec.QtyScale, false)
+		if err != nil {
+			continue
+		}
+		if p.qty < 0 {
+			mv = -mv
+		}
+		pnl += mv - p.cost
+	}
+	return pnl
+}
+
+func abs(v int64) uint64 {
+	if v < 0 {
+		return uint64(-v)
+	}
+	return uint64(v)
+}
+
+// mulDiv computes a*b/c without overflowing the intermediate product
+func mulDiv(a, b, c int64) int64 {
+	r := new(big.Int).Mul(big.NewInt(a), big.NewInt(b))
+	r.Quo(r, big.NewInt(c))
+	return r.Int64()
+}
@@ -0,0 +1,130 @@
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+)
+
+// names of the limits as stored in risk_limits.name
+const (
+	LimitMaxOrderQty   = "max_order_qty"   // quantity ticks
+	LimitMaxNotional   = "max_notional"    // cash minor units
+	LimitMaxOpenOrders = "max_open_orders" // count
+	LimitMaxPosition   = "max_position"    // quantity ticks, absolute net position
+	LimitMaxDailyLoss  = "max_daily_loss"  // cash minor units
+)
+
+// Limits is the effective set of limits for one user and symbol, zero means unlimited
+type Limits struct {
+	MaxOrderQty   uint64
+	MaxNotional   decimal.Amount
+	MaxOpenOrders int
+	MaxPosition   uint64
+	MaxDailyLoss  decimal.Amount
+}
+
+// ErrInvalidLimit wraps every reason a limit override is refused
+var ErrInvalidLimit = errors.New("invalid risk limit")
+
+type scope struct {
+	user   uint64
+	symbol uint32
+}
+
+var (
+	limitsMu  sync.RWMutex
+	overrides = make(map[scope]map[string]int64)
+)
+
+// Load reads the risk_limits table into memory and rebuilds the exposure
+func Load() error {
+	if err := loadLimits(); err != nil {
+		return err
+	}
+	return loadExposure()
+}
+
+func loadLimits() error {
+	rows, err := db.ListRiskLimits()
+	if err != nil {
+		return err
+	}
+	next := make(map[scope]map[string]int64)
+	for _, l := range rows {
+		if !validName(l.Name) {
+			return fmt.Errorf("risk: unknown limit %q in risk_limits", l.Name)
+		}
+		s := scope{user: l.User_id, symbol: l.Symbol}
+		if next[s] == nil {
+			next[s] = make(map[string]int64)
+		}
+		next[s][l.Name] = l.Value
+	}
+
+	limitsMu.Lock()
+	overrides = next
+	limitsMu.Unlock()
+	return nil
+}
+
+// SetLimit stores an override and applies it right away.
+// user 0 / symbol 0 make it apply to every user / symbol.
+func SetLimit(userID uint64, symbol uint32, name string, value int64) error {
+	if !validName(name) {
+		return fmt.Errorf("%w: unknown limit %q", ErrInvalidLimit, name)
+	}
+	if value < 0 {
+		return fmt.Errorf("%w: limit %q must be >= 0", ErrInvalidLimit, name)
+	}
+	if err := db.SetRiskLimit(db.RiskLimit{User_id: userID, Symbol: symbol, Name: name, Value: value}); err != nil {
+		return err
+	}
+	return loadLimits()
+}
+
+// DeleteLimit drops an override so the next broader scope applies again
+func DeleteLimit(userID uint64, symbol uint32, name string) error {
+	if !validName(name) {
+		return fmt.Errorf("%w: unknown limit %q", ErrInvalidLimit, name)
+	}
+	if err := db.DeleteRiskLimit(userID, symbol, name); err != nil {
+		return err
+	}
+	return loadLimits()
+}
+
+func validName(name string) bool {
+	switch name {
+	case LimitMaxOrderQty, LimitMaxNotional, LimitMaxOpenOrders, LimitMaxPosition, LimitMaxDailyLoss:
+		return true
+	}
+	return false
+}
+
+// LimitsFor resolves the limits for one user and symbol. the most specific
+// scope wins: user+symbol, then user, then symbol, then the global row.
+func LimitsFor(userID uint64, symbol uint32) Limits {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+
+	lookup := func(name string) int64 {
+		for _, s := range []scope{{userID, symbol}, {userID, 0}, {0, symbol}, {0, 0}} {
+			if v, ok := overrides[s][name]; ok {
+				return v
+			}
+		}
+		return 0
+	}
+
+	return Limits{
+		MaxOrderQty:   uint64(lookup(LimitMaxOrderQty)),
+		MaxNotional:   decimal.Amount(lookup(LimitMaxNotional)),
+		MaxOpenOrders: int(lookup(LimitMaxOpenOrders)),
+		MaxPosition:   uint64(lookup(LimitMaxPosition)),
+		MaxDailyLoss:  decimal.Amount(lookup(LimitMaxDailyLoss)),
+	}
+}
@@ -0,0 +1,124 @@
+package risk
+
+import (
+	"fmt"
+	"sync"
+
+	"jotacomputing/go-api/structs"
+)
+
+// reason codes returned to clients when a check rejects an order
+const (
+	ReasonMaxOrderQty          = "MAX_ORDER_QTY"
+	ReasonMaxNotional          = "MAX_NOTIONAL"
+	ReasonMaxOpenOrders        = "MAX_OPEN_ORDERS"
+	ReasonPositionLimit        = "POSITION_LIMIT"
+	ReasonDailyLossLimit       = "DAILY_LOSS_LIMIT"
+	ReasonNoReferencePrice     = "NO_REFERENCE_PRICE"
+	ReasonInsufficientFunds    = "INSUFFICIENT_BUYING_POWER"
+	ReasonDuplicateOrder       = "DUPLICATE_ORDER_ID"
+	ReasonHalted               = "TRADING_HALTED"
+	ReasonMarketClosed         = "MARKET_CLOSED"
+	ReasonSessionOrderType     = "ORDER_TYPE_NOT_ALLOWED_IN_SESSION"
+	ReasonStpGroup             = "INVALID_STP_GROUP"
+	ReasonBatchRejected        = "BATCH_REJECTED"
+	ReasonBatchTooLarge        = "BATCH_TOO_LARGE"
+	ReasonInsufficientPosition = "INSUFFICIENT_POSITION"
+	ReasonPositionUnknown      = "POSITION_UNKNOWN"
+	ReasonAmendPending         = "AMEND_PENDING"
+)
+
+// Rejection is returned by a check that refuses an order
+type Rejection struct {
+	Code    string `json:"reason_code"`
+	Message string `json:"error"`
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("%s: %s", r.Code, r.Message)
+}
+
+func reject(code, format string, args ...interface{}) *Rejection {
+	return &Rejection{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Check is one pre-trade check, limits are already resolved for the
+// order's user and symbol. return nil to let the order through.
+type Check interface {
+	Name() string
+	Check(order structs.Order, limits Limits) *Rejection
+}
+
+var (
+	chainMu sync.RWMutex
+	chain   = []Check{
+		maxOrderQty{},
+		maxNotional{},
+		maxOpenOrders{},
+		positionLimit{},
+		dailyLoss{},
+	}
+	// prepended gates, they run ahead of chain
+	gates []Check
+)
+
+// Register appends a check to the end of the chain
+func Register(check Check) {
+	chainMu.Lock()
+	chain = append(chain, check)
+	chainMu.Unlock()
+}
+
+// Prepend puts a check in front of the chain, for gates like the kill switch
+// whose reason should win over everything else
+func Prepend(check Check) {
+	chainMu.Lock()
+	gates = append([]Check{check}, gates...)
+	chainMu.Unlock()
+}
+
+// Evaluate runs the order through every check and stops at the first rejection
+func Evaluate(order structs.Order) *Rejection {
+	chainMu.RLock()
+	checks := append(append([]Check(nil), gates...), chain...)
+	chainMu.RUnlock()
+	return run(order, checks)
+}
+
+// EvaluateExcept is Evaluate without the named checks
+func EvaluateExcept(order structs.Order, skip ...string) *Rejection {
+	chainMu.RLock()
+	var checks []Check
+	for _, check := range append(append([]Check(nil), gates...), chain...) {
+		skipped := false
+		for _, name := range skip {
+			if check.Name() == name {
+				skipped = true
+			}
+		}
+		if !skipped {
+			checks = append(checks, check)
+		}
+	}
+	chainMu.RUnlock()
+	return run(order, checks)
+}
+
+// CheckGates runs only the prepended gates, for orders that wait for a halt
+// or a session to end instead of being refused, like armed stops
+func CheckGates(order structs.Order) *Rejection {
+	chainMu.RLock()
+	checks := gates
+	chainMu.RUnlock()
+	return run(order, checks)
+}
+
+func run(order structs.Order, checks []Check) *Rejection {
+	limits := LimitsFor(order.User_id, order.Symbol)
+	for _, check := range checks {
+		if r := check.Check(order, limits); r != nil {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package schedules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five field cron expression: minute hour day-of-month month
+// day-of-week. fields take *, numbers, ranges (1-5), lists (1,3) and steps
+// (*/15, 9-17/2). day-of-week runs 0-6 from Sunday, 7 is Sunday too.
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // bit n set when value n matches
+	domAny, dowAny                bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseCron parses a cron expression
+func ParseCron(expr string) (Cron, error) {
+	var c Cron
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return c, fmt.Errorf("cron %q: want %d fields, got %d", expr, len(cronFields), len(parts))
+	}
+
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return c, fmt.Errorf("cron %q: %w", expr, err)
+		}
+		sets[i] = set
+	}
+	c.minute, c.hour, c.dom, c.month, c.dow = sets[0], sets[1], sets[2], sets[3], sets[4]
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = parts[2] == "*"
+	c.dowAny = parts[4] == "*"
+	return c, nil
+}
+
+func parseCronField(s string, f cronField) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("%s: bad step %q", f.name, stepStr)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("%s: bad value %q", f.name, a)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("%s: bad value %q", f.name, b)
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%s: %q out of range %d-%d", f.name, item, f.min, f.max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (c Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	// like cron, when both day fields are restricted either one is enough
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after after that matches, evaluated in loc.
+// the zero time means the expression never matches (Feb 30).
+func (c Cron) Next(after time.Time, loc *time.Location) time.Time {
+	t := after.In(loc)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+
+	// five years covers every satisfiable expression including Feb 29
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
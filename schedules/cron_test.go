@@ -0,0 +1,75 @@
+package schedules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"* * * * *", true},
+		{"*/15 9-17 * * 1-5", true},
+		{"0,30 9-17/2 1,15 1-12 0-7", true},
+		{"5/15 * * * *", true},
+		{"0 0 29 2 *", true},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"a * * * *", false},
+		{"1- * * * *", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		_, err := ParseCron(tt.expr)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseCron(%q) error = %v, want ok %v", tt.expr, err, tt.ok)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tz database: %v", err)
+	}
+	// a Thursday
+	after := time.Date(2026, 1, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		expr  string
+		after time.Time
+		loc   *time.Location
+		want  time.Time
+	}{
+		{"every quarter hour", "*/15 * * * *", after, time.UTC, time.Date(2026, 1, 15, 10, 15, 0, 0, time.UTC)},
+		{"strictly after", "*/15 * * * *", time.Date(2026, 1, 15, 10, 15, 0, 0, time.UTC), time.UTC, time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC)},
+		{"step from an offset", "5/15 * * * *", after, time.UTC, time.Date(2026, 1, 15, 10, 20, 0, 0, time.UTC)},
+		{"weekdays, next day", "0 9 * * 1-5", after, time.UTC, time.Date(2026, 1, 16, 9, 0, 0, 0, time.UTC)},
+		{"weekdays, over the weekend", "0 9 * * 1-5", time.Date(2026, 1, 16, 10, 0, 0, 0, time.UTC), time.UTC, time.Date(2026, 1, 19, 9, 0, 0, 0, time.UTC)},
+		{"first of the month", "30 16 1 * *", after, time.UTC, time.Date(2026, 2, 1, 16, 30, 0, 0, time.UTC)},
+		{"either day field", "0 12 13 * 5", after, time.UTC, time.Date(2026, 1, 16, 12, 0, 0, 0, time.UTC)},
+		{"sunday as 7", "0 0 * * 7", after, time.UTC, time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", after, time.UTC, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", after, time.UTC, time.Time{}},
+		{"in the schedule's zone", "0 9 * * *", time.Date(2026, 1, 15, 15, 0, 0, 0, time.UTC), newYork, time.Date(2026, 1, 16, 14, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: ParseCron(%q): %v", tt.name, tt.expr, err)
+		}
+		if got := c.Next(tt.after, tt.loc); !got.Equal(tt.want) {
+			t.Errorf("%s: Next(%q) = %v, want %v", tt.name, tt.expr, got, tt.want)
+		}
+	}
+}
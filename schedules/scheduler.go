@@ -0,0 +1,210 @@
+package schedules
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"jotacomputing/go-api/calendar"
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/feeds"
+	"jotacomputing/go-api/oms"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+)
+
+var ErrInvalid = errors.New("invalid schedule")
+
+const (
+	pollInterval = time.Second
+	// a run found later than this after its due time (gateway was down) is
+	// recorded as missed instead of sending a stale order
+	misfireGrace = 5 * time.Minute
+)
+
+// order ids of scheduled orders are the run id with bit 61 set, apart from
+// client ids and algo children (bit 62)
+const runIDBit = uint64(1) << 61
+
+// reason code of runs whose template can't be turned into an order
+const ReasonInvalidTemplate = "INVALID_TEMPLATE"
+
+// Prepare validates a schedule and sets its next run after now. the timezone
+// defaults to the one of the symbol's trading calendar.
+func Prepare(s *db.Schedule, now time.Time) error {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if s.Timezone == "" {
+		s.Timezone = calendar.Location(symbols.Lookup(s.Symbol).Group).String()
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return fmt.Errorf("%w: unknown timezone %q", ErrInvalid, s.Timezone)
+	}
+
+	if (s.Shares_qty == 0) == (s.Notional == 0) {
+		return fmt.Errorf("%w: exactly one of shares_qty and notional must be set", ErrInvalid)
+	}
+	if s.Notional < 0 {
+		return fmt.Errorf("%w: notional must be > 0", ErrInvalid)
+	}
+	if s.Order_type != structs.OrderTypeMarket && s.Order_type != structs.OrderTypeLimit {
+		return fmt.Errorf("%w: order_type must be 0 (market) or 1 (limit)", ErrInvalid)
+	}
+	if s.Time_in_force == structs.TifGTD {
+		return fmt.Errorf("%w: GTD is not supported for scheduled orders", ErrInvalid)
+	}
+	// run the template through the normal order validation, a notional
+	// template is checked with a placeholder quantity
+	qty := s.Shares_qty
+	if qty == 0 {
+		qty = 1
+	}
+	t := template(s, qty, now)
+	if err := t.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	next := cron.Next(now, loc)
+	if next.IsZero() {
+		return fmt.Errorf("%w: cron %q never matches", ErrInvalid, s.Cron)
+	}
+	s.Next_run = next.UnixNano()
+	return nil
+}
+
+func template(s *db.Schedule, qty uint64, now time.Time) structs.TempOrder {
+	spec := symbols.Lookup(s.Symbol)
+	t := structs.TempOrder{
+		Timestamp:     uint64(now.UnixNano()),
+		Shares_qty:    decimal.Value(decimal.Format(qty, spec.QtyScale)),
+		Symbol:        s.Symbol,
+		Side:          s.Side,
+		Order_type:    s.Order_type,
+		Time_in_force: s.Time_in_force,
+	}
+	if s.Price != 0 {
+		t.Price = decimal.Value(decimal.Format(s.Price, spec.PriceScale))
+	}
+	return t
+}
+
+// buildOrder turns the template into the order for one run. a notional
+// template buys or sells as many whole lots as the amount covers at the limit
+// price, or the last trade for market orders.
+func buildOrder(s *db.Schedule, orderID uint64, now time.Time) (structs.Order, *risk.Rejection) {
+	qty := s.Shares_qty
+	if s.Notional > 0 {
+		spec := symbols.Lookup(s.Symbol)
+		price := s.Price
+		if price == 0 {
+			last, ok := feeds.LastPrice(s.Symbol)
+			if !ok {
+				return structs.Order{}, &risk.Rejection{Code: risk.ReasonNoReferencePrice, Message: "no last trade price to size the order"}
+			}
+			price = last
+		}
+		var err error
+		if qty, err = decimal.QtyFor(s.Notional, price, spec.PriceScale, spec.QtyScale); err != nil || qty > math.MaxUint32 {
+			return structs.Order{}, &risk.Rejection{Code: ReasonInvalidTemplate, Message: "notional out of range"}
+		}
+		qty -= qty % spec.LotSize
+		if qty == 0 {
+			return structs.Order{}, &risk.Rejection{Code: ReasonInvalidTemplate, Message: "notional " + s.Notional.String() + " buys less than one lot"}
+		}
+	}
+
+	t := template(s, qty, now)
+	order, err := t.ToOrder(s.User_id)
+	if err != nil {
+		return order, &risk.Rejection{Code: ReasonInvalidTemplate, Message: err.Error()}
+	}
+	// a run id is above the client range, it is set once the template passed
+	order.Order_id = orderID
+	return order, nil
+}
+
+// StartScheduler fires due schedules in the background
+func StartScheduler() {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			due, err := db.DueSchedules(now.UnixNano())
+			if err != nil {
+				log.Printf("schedules: failed to load due schedules: %v", err)
+				continue
+			}
+			for _, s := range due {
+				fire(s, now)
+			}
+		}
+	}()
+}
+
+// fire claims the due run of s and sends its order. the claim moves next_run
+// forward first, if it fails someone else already took this run.
+func fire(s *db.Schedule, now time.Time) {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		log.Printf("schedules: schedule %d has a bad cron: %v", s.ID, err)
+		return
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		log.Printf("schedules: schedule %d has a bad timezone: %v", s.ID, err)
+		return
+	}
+	// runs missed while the gateway was down are skipped, not replayed
+	next := cron.Next(now, loc)
+	nextRun := next.UnixNano()
+	if next.IsZero() {
+		nextRun = math.MaxInt64
+	}
+	claimed, err := db.ClaimScheduleRun(s.ID, s.Next_run, nextRun)
+	if err != nil {
+		log.Printf("schedules: failed to claim schedule %d: %v", s.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	run := &db.ScheduleRun{Schedule_id: s.ID, User_id: s.User_id, Due_at: s.Next_run, Outcome: db.RunPending}
+	if now.Sub(time.Unix(0, s.Next_run)) > misfireGrace {
+		run.Outcome = db.RunMissed
+	}
+	if err := db.InsertScheduleRun(run); err != nil {
+		log.Printf("schedules: failed to record run of schedule %d: %v", s.ID, err)
+		return
+	}
+	if run.Outcome == db.RunMissed {
+		return
+	}
+
+	run.Order_id = runIDBit | run.ID
+	order, rejection := buildOrder(s, run.Order_id, now)
+	if rejection == nil {
+		err = oms.Submit(order)
+		errors.As(err, &rejection)
+	}
+	switch {
+	case rejection != nil:
+		run.Outcome = db.RunRejected
+		run.Reason = rejection.Code + ": " + rejection.Message
+	case err != nil:
+		run.Outcome = db.RunFailed
+		run.Reason = err.Error()
+	default:
+		run.Outcome = db.RunSubmitted
+	}
+	if err := db.FinishScheduleRun(run); err != nil {
+		log.Printf("schedules: failed to record outcome of run %d: %v", run.ID, err)
+	}
+}
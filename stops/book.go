@@ -0,0 +1,316 @@
+package stops
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"jotacomputing/go-api/calendar"
+	"jotacomputing/go-api/db"
+	"jotacomputing/go-api/oms"
+	"jotacomputing/go-api/orders"
+	"jotacomputing/go-api/risk"
+	"jotacomputing/go-api/structs"
+	"jotacomputing/go-api/symbols"
+)
+
+var ErrNotFound = errors.New("stop order not found")
+
+// per symbol trigger book.
+// buys trigger when the last price trades at or above the stop, so they are
+// kept ascending; sells trigger at or below and are kept descending. either
+// way the next stop to fire is always at the front.
+type symbolBook struct {
+	buys  []structs.StopOrder
+	sells []structs.StopOrder
+}
+
+var (
+	mu    sync.Mutex
+	books = make(map[uint32]*symbolBook)
+
+	expiredHandlers []func(structs.StopOrder)
+)
+
+// how often armed stops are checked for their expiry
+const expiryCheck = time.Second
+
+// Load rebuilds the trigger book from the stop_orders table
+func Load() error {
+	armed, err := db.LoadArmedStopOrders()
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, stop := range armed {
+		if stop.Order.Time_in_force == structs.TifDAY && stop.Order.Expire_at == 0 {
+			stop.Order.Expire_at = dayEnd(stop.Order, time.Now())
+		}
+		insert(stop)
+	}
+	log.Printf("stops: loaded %d armed stop orders", len(armed))
+	return nil
+}
+
+// Check runs the pre-trade checks on a stop as it will be released, so obvious
+// breaches fail at placement rather than silently at trigger time. the session
+// is left out, an armed stop waits for the market to open.
+func Check(stop structs.StopOrder) *risk.Rejection {
+	return risk.EvaluateExcept(stop.Released(), calendar.GateName)
+}
+
+// Add persists a stop order and arms it. a DAY stop gets the close of the
+// trading day as its expiry. the order id is claimed here, one the user
+// already used comes back as a *risk.Rejection.
+func Add(stop structs.StopOrder) error {
+	if stop.Order.Time_in_force == structs.TifDAY {
+		stop.Order.Expire_at = dayEnd(stop.Order, time.Now())
+	}
+	if err := db.CreateStopOrder(stop); err != nil {
+		if errors.Is(err, db.ErrDuplicateOrder) {
+			return &risk.Rejection{Code: risk.ReasonDuplicateOrder, Message: "order id already used"}
+		}
+		return err
+	}
+	mu.Lock()
+	insert(stop)
+	mu.Unlock()
+	return nil
+}
+
+// Cancel disarms one of the user's stop orders
+func Cancel(userID, orderID uint64) (structs.StopOrder, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, book := range books {
+		for _, side := range []*[]structs.StopOrder{&book.buys, &book.sells} {
+			for i, stop := range *side {
+				if stop.Order.User_id != userID || stop.Order.Order_id != orderID {
+					continue
+				}
+				if err := db.SetStopOrderStatus(userID, orderID, db.StopCancelled); err != nil {
+					return stop, err
+				}
+				*side = append((*side)[:i], (*side)[i+1:]...)
+				return stop, nil
+			}
+		}
+	}
+	return structs.StopOrder{}, ErrNotFound
+}
+
+func dayEnd(order structs.Order, now time.Time) uint64 {
+	end := calendar.DayEnd(symbols.Lookup(order.Symbol).Group, now)
+	if end.IsZero() {
+		return 0
+	}
+	return uint64(end.UnixNano())
+}
+
+// OnExpire registers fn to be called with every stop that lapsed while armed,
+// must be called before StartExpiry
+func OnExpire(fn func(structs.StopOrder)) {
+	expiredHandlers = append(expiredHandlers, fn)
+}
+
+// StartExpiry disarms GTD and DAY stops once they lapse, in the background
+func StartExpiry() {
+	go func() {
+		ticker := time.NewTicker(expiryCheck)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			for _, stop := range expireDue(uint64(now.UnixNano())) {
+				for _, fn := range expiredHandlers {
+					fn(stop)
+				}
+			}
+		}
+	}()
+}
+
+// expireDue takes every lapsed stop out of the book and returns them
+func expireDue(now uint64) []structs.StopOrder {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var expired []structs.StopOrder
+	for _, book := range books {
+		for _, side := range []*[]structs.StopOrder{&book.buys, &book.sells} {
+			kept := (*side)[:0]
+			for _, stop := range *side {
+				o := stop.Order
+				if o.Expire_at == 0 || o.Expire_at > now {
+					kept = append(kept, stop)
+					continue
+				}
+				if err := db.SetStopOrderStatus(o.User_id, o.Order_id, db.StopExpired); err != nil {
+					log.Printf("stops: failed to expire order %d of user %d: %v", o.Order_id, o.User_id, err)
+					kept = append(kept, stop)
+					continue
+				}
+				expired = append(expired, stop)
+			}
+			*side = kept
+		}
+	}
+	return expired
+}
+
+// Resize changes the quantity of one of the user's armed stops
+func Resize(userID, orderID uint64, qty uint32) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, book := range books {
+		for _, side := range []*[]structs.StopOrder{&book.buys, &book.sells} {
+			for i := range *side {
+				o := &(*side)[i].Order
+				if o.User_id != userID || o.Order_id != orderID {
+					continue
+				}
+				display := o.Display_qty
+				if display > qty {
+					display = 0
+				}
+				if err := db.SetStopOrderQty(userID, orderID, qty, display); err != nil {
+					return err
+				}
+				o.Shares_qty, o.Display_qty = qty, display
+				return nil
+			}
+		}
+	}
+	return ErrNotFound
+}
+
+// CancelMatching disarms every stop selected by f and returns them
+func CancelMatching(f orders.Filter) []structs.StopOrder {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var cancelled []structs.StopOrder
+	for _, book := range books {
+		for _, side := range []*[]structs.StopOrder{&book.buys, &book.sells} {
+			kept := (*side)[:0]
+			for _, stop := range *side {
+				if !f.Matches(stop.Order) {
+					kept = append(kept, stop)
+					continue
+				}
+				if err := db.SetStopOrderStatus(stop.Order.User_id, stop.Order.Order_id, db.StopCancelled); err != nil {
+					log.Printf("stops: failed to cancel order %d of user %d: %v", stop.Order.Order_id, stop.Order.User_id, err)
+					kept = append(kept, stop)
+					continue
+				}
+				cancelled = append(cancelled, stop)
+			}
+			*side = kept
+		}
+	}
+	return cancelled
+}
+
+func insert(stop structs.StopOrder) {
+	book := books[stop.Order.Symbol]
+	if book == nil {
+		book = &symbolBook{}
+		books[stop.Order.Symbol] = book
+	}
+
+	var side *[]structs.StopOrder
+	var i int
+	if stop.Order.Side == 0 {
+		side = &book.buys
+		i = sort.Search(len(*side), func(j int) bool { return (*side)[j].Stop_price > stop.Stop_price })
+	} else {
+		side = &book.sells
+		i = sort.Search(len(*side), func(j int) bool { return (*side)[j].Stop_price < stop.Stop_price })
+	}
+	*side = append(*side, structs.StopOrder{})
+	copy((*side)[i+1:], (*side)[i:])
+	(*side)[i] = stop
+}
+
+// OnTrade fires every stop crossed by the trade, registered with feeds.OnTrade.
+// the crossed stops are taken out of the book and released without holding
+// the lock, the ones that stay armed are put back afterwards.
+func OnTrade(trade structs.Trade) {
+	mu.Lock()
+	book := books[trade.Symbol]
+	if book == nil {
+		mu.Unlock()
+		return
+	}
+	var crossed []structs.StopOrder
+	book.buys, crossed = take(book.buys, crossed, func(stop uint64) bool { return trade.Price >= stop })
+	book.sells, crossed = take(book.sells, crossed, func(stop uint64) bool { return trade.Price <= stop })
+	mu.Unlock()
+	if len(crossed) == 0 {
+		return
+	}
+
+	armed := fire(crossed)
+	mu.Lock()
+	for _, stop := range armed {
+		insert(stop)
+	}
+	mu.Unlock()
+}
+
+// take moves the stops at the front of side to crossed while hit holds,
+// mu must be held
+func take(side, crossed []structs.StopOrder, hit func(stop uint64) bool) ([]structs.StopOrder, []structs.StopOrder) {
+	n := 0
+	for n < len(side) && hit(side[n].Stop_price) {
+		n++
+	}
+	return side[n:], append(crossed, side[:n]...)
+}
+
+// fire releases the crossed stops in order and returns the ones that stay
+// armed. a stop that can't be enqueued stays armed and is retried on the next
+// print along with every stop after it, one refused by the risk checks is
+// dropped (unless a halt or the session holds it, then it waits and the next
+// ones are tried).
+func fire(crossed []structs.StopOrder) []structs.StopOrder {
+	var armed []structs.StopOrder
+	for n, stop := range crossed {
+		o := stop.Order
+		gated, err := release(stop)
+		var rejection *risk.Rejection
+		if gated != nil {
+			// keep it armed, it can fire once trading resumes
+			armed = append(armed, stop)
+		} else if errors.As(err, &rejection) {
+			log.Printf("stops: order %d of user %d rejected on trigger: %v", o.Order_id, o.User_id, rejection)
+			if err := db.RejectTriggeredStopOrder(o.User_id, o.Order_id); err != nil {
+				log.Printf("stops: order %d not marked rejected: %v", o.Order_id, err)
+			}
+		} else if err != nil {
+			log.Printf("stops: failed to release order %d of user %d: %v", o.Order_id, o.User_id, err)
+			if err := db.RearmStopOrder(stop); err != nil && !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("stops: order %d not marked armed again: %v", o.Order_id, err)
+			}
+			return append(armed, crossed[n:]...)
+		}
+	}
+	return armed
+}
+
+// release sends a triggered stop to the engine, the stop is marked triggered
+// before the order is enqueued. halts and sessions are checked first and come
+// back as gated, a stop waiting one out stays armed.
+func release(stop structs.StopOrder) (gated *risk.Rejection, err error) {
+	order := stop.Released()
+	order.Timestamp = uint64(time.Now().UnixNano())
+
+	if r := risk.CheckGates(order); r != nil {
+		return r, nil
+	}
+	return nil, oms.SubmitTriggered(order)
+}
@@ -6,13 +6,113 @@ type Order struct {
 	Price uint64
 	Timestamp uint64
 	User_id uint64
+	Expire_at uint64 // unix nanos, only set for GTD
+	Stp_group uint64 // orders in the same group never trade with each other, 0 without STP
 	// then u32s (4-byte aligned)
 	Shares_qty uint32
+	Display_qty uint32 // iceberg: quantity shown on the book, 0 shows it all
 	// then u8s (1-byte aligned)
 	Symbol uint32
 	Side uint8 // 0=buy 1=sell
-	Order_type uint8 // 0=market order 1=limit order
-	Status uint8 // O=pending 1=filled 2=rejected
+	Order_type uint8 // 0=market order 1=limit order (stop types never reach the engine)
+	Status uint8 // O=pending 1=filled 2=rejected 3=cancelled 4=partially filled 5=replaced 6=replace rejected
+	Time_in_force uint8 // 0=GTC 1=IOC 2=FOK 3=DAY 4=GTD
+	Stp_mode uint8 // 0=none 1=cancel newest 2=cancel oldest 3=cancel both 4=decrement
+	Short_sale uint8 // 1 when a sell goes beyond what the user holds (short selling accounts only)
+}
+
+// values of Order.Status as reported back on the status ring
+const (
+	StatusPending         uint8 = 0
+	StatusFilled          uint8 = 1
+	StatusRejected        uint8 = 2
+	StatusCancelled       uint8 = 3
+	StatusPartiallyFilled uint8 = 4
+	// answers to an OrderReplace, Price/Shares_qty carry the amended values
+	StatusReplaced        uint8 = 5
+	StatusReplaceRejected uint8 = 6
+)
+
+// values of Order.Time_in_force. GTC is the zero value so older clients keep
+// resting limit orders like before.
+const (
+	TifGTC uint8 = 0
+	TifIOC uint8 = 1
+	TifFOK uint8 = 2
+	TifDAY uint8 = 3
+	TifGTD uint8 = 4
+)
+
+// values of Order.Stp_mode, what the engine does when an order would match
+// one resting in the same STP group. none is the zero value.
+const (
+	StpNone         uint8 = 0
+	StpCancelNewest uint8 = 1
+	StpCancelOldest uint8 = 2
+	StpCancelBoth   uint8 = 3
+	StpDecrement    uint8 = 4
+)
+
+// IsTerminal reports whether the engine is done with the order
+func (o *Order) IsTerminal() bool {
+	return o.Status == StatusFilled || o.Status == StatusRejected || o.Status == StatusCancelled
+}
+
+// order types accepted at the API, the engine only knows market and limit.
+// stop and stop-limit orders are held by the gateway until they trigger.
+const (
+	OrderTypeMarket    uint8 = 0
+	OrderTypeLimit     uint8 = 1
+	OrderTypeStop      uint8 = 2
+	OrderTypeStopLimit uint8 = 3
+)
+
+// StopOrder is an order parked in the gateway trigger book. Order carries the
+// stop/stop-limit type until release, when it is turned into market/limit.
+type StopOrder struct {
+	Order      Order
+	Stop_price uint64
+}
+
+// Released is the engine order sent when the stop triggers
+func (s StopOrder) Released() Order {
+	order := s.Order
+	if order.Order_type == OrderTypeStopLimit {
+		order.Order_type = OrderTypeLimit
+	} else {
+		order.Order_type = OrderTypeMarket
+		order.Price = 0
+	}
+	if order.Time_in_force == TifDAY {
+		// set for the trigger book only, the engine takes expiries on GTD
+		order.Expire_at = 0
+	}
+	order.Status = StatusPending
+	return order
+}
+
+// Trade is a last-trade print published by the engine on the trade feed ring
+type Trade struct {
+	Trade_id uint64
+	Price uint64
+	Timestamp uint64
+	Shares_qty uint32
+	Symbol uint32
+}
+
+// Fill is one execution between two orders, published by the engine on the
+// fills ring. Aggressor_side is the side of the order that took liquidity.
+type Fill struct {
+	Trade_id uint64
+	Buy_order_id uint64
+	Sell_order_id uint64
+	Buy_user_id uint64
+	Sell_user_id uint64
+	Price uint64
+	Timestamp uint64
+	Shares_qty uint32
+	Symbol uint32
+	Aggressor_side uint8 // 0=buy 1=sell
 }
 
 type OrderToBeCancelled struct {
@@ -21,9 +121,34 @@ type OrderToBeCancelled struct {
 	Symbol uint32
 }
 
+// OrderReplace amends a resting order in place (cancel/replace in one message).
+// a zero New_price or New_qty leaves that field as it is.
+type OrderReplace struct {
+	Order_id uint64
+	User_id uint64
+	New_price uint64
+	Timestamp uint64
+	New_qty uint32
+	Symbol uint32
+	Keep_priority uint8 // 1 when only the quantity goes down, the order keeps its place
+}
+
 type Query struct {
 	Query_id uint64
 	User_id uint64
 	Query_type uint8 // 0 -> get balance , 1 -> get holdings , 2 -> add user on login 
 
 }
+
+// QueryResponse is the engine's answer on the query response ring. a holdings
+// query gets one response per symbol held and Last is set on the final one,
+// a user holding nothing gets a single response with Symbol 0.
+type QueryResponse struct {
+	Query_id uint64
+	User_id uint64
+	Amount uint64 // balance in cash minor units, or quantity ticks of Symbol held
+	Timestamp uint64 // engine time the answer was taken at, unix nanos
+	Symbol uint32
+	Query_type uint8
+	Last uint8
+}
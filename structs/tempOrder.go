@@ -3,18 +3,32 @@ package structs
 import (
 	"errors"
 	"fmt"
+	"math"
+	"time"
+
+	"jotacomputing/go-api/decimal"
+	"jotacomputing/go-api/symbols"
 )
 
 type TempOrder struct {
-	Order_id  uint64
-	Price     uint64
-	Timestamp uint64
-	// then u32s (4-byte aligned)
-	Shares_qty uint32
-	// then u8s (1-byte aligned)
+	Order_id uint64
+	// price and quantity come in as decimal strings ("123.45") and are
+	// converted to engine ticks with the symbol's scale, see symbols.Spec
+	Price      decimal.Value
+	Timestamp  uint64
+	Shares_qty decimal.Value
 	Symbol     uint32
 	Side       uint8 // 0=buy 1=sell
-	Order_type uint8 // 0=market order 1=limit order
+	Order_type uint8 // 0=market order 1=limit order 2=stop 3=stop-limit
+	// trigger price for stop and stop-limit orders, same scale as Price
+	Stop_price decimal.Value
+	Time_in_force uint8  // 0=GTC 1=IOC 2=FOK 3=DAY 4=GTD
+	Expire_at     uint64 // unix nanos, required for GTD and only allowed there
+	// self-trade prevention, the group defaults to the account's group
+	Stp_mode  uint8 // 0=none 1=cancel newest 2=cancel oldest 3=cancel both 4=decrement
+	Stp_group uint64
+	// iceberg orders show only Display_qty on the book, same scale as Shares_qty
+	Display_qty decimal.Value
 }
 
 type TempOrderToBeCancelled struct {
@@ -22,32 +36,68 @@ type TempOrderToBeCancelled struct {
 	Symbol   uint32
 }
 
+// body of PUT /api/order/:orderId, leave a field out to keep it unchanged
+type TempOrderReplace struct {
+	Price      decimal.Value
+	Shares_qty decimal.Value
+	Timestamp  uint64
+}
+
 type TempQuery struct {
 	Query_id   uint64
 	Query_type uint8 // 0 -> get balance , 1 -> get holdings , 2 -> add user on login 
 }
 
+// client order ids stay below bit 60, the bits above it tag the gateway's own
+// orders: margin liquidations (60), scheduled orders (61), algo children (62)
+// and holdings queries (63)
+const ReservedOrderIDs uint64 = 1 << 60
+
 func (o *TempOrder) Validate() error {
 
-	if o.Price == 0 && o.Order_type == 1 {
+	if o.Order_id >= ReservedOrderIDs {
+		return fmt.Errorf("order_id must be below %d", ReservedOrderIDs)
+	}
+
+	if o.Symbol == 0 {
+		return errors.New("symbol must be specified")
+	}
+	spec := symbols.Lookup(o.Symbol)
+
+	price, err := o.priceTicks(spec)
+	if err != nil {
+		return err
+	}
+	if price == 0 && (o.Order_type == OrderTypeLimit || o.Order_type == OrderTypeStopLimit) {
 		// for a limit order, price is required
 		return errors.New("price must be > 0 for limit orders")
 	}
 
-	if o.Shares_qty == 0 {
-		return errors.New("shares_qty must be > 0")
+	stopPrice, err := o.stopTicks(spec)
+	if err != nil {
+		return err
+	}
+	if o.IsStop() && stopPrice == 0 {
+		return errors.New("stop_price must be > 0 for stop orders")
+	}
+	if !o.IsStop() && stopPrice != 0 {
+		return errors.New("stop_price is only allowed on stop and stop-limit orders")
 	}
 
-	if o.Symbol == 0 {
-		return errors.New("symbol must be specified")
+	qty, err := o.qtyTicks(spec)
+	if err != nil {
+		return err
+	}
+	if qty == 0 {
+		return errors.New("shares_qty must be > 0")
 	}
 
 	if o.Side != 0 && o.Side != 1 {
 		return fmt.Errorf("side must be 0 (buy) or 1 (sell), got %d", o.Side)
 	}
 
-	if o.Order_type != 0 && o.Order_type != 1 {
-		return fmt.Errorf("order_type must be 0 (market) or 1 (limit), got %d", o.Order_type)
+	if o.Order_type > OrderTypeStopLimit {
+		return fmt.Errorf("order_type must be 0 (market), 1 (limit), 2 (stop) or 3 (stop-limit), got %d", o.Order_type)
 	}
 
 	// Optional: timestamp > 0, or within some window, etc.
@@ -55,5 +105,223 @@ func (o *TempOrder) Validate() error {
 		return errors.New("timestamp must be non-zero")
 	}
 
+	if err := o.validateTimeInForce(); err != nil {
+		return err
+	}
+
+	if err := o.validateDisplayQty(spec, qty); err != nil {
+		return err
+	}
+
+	if o.Stp_mode > StpDecrement {
+		return fmt.Errorf("stp_mode must be 0 (none), 1 (cancel newest), 2 (cancel oldest), 3 (cancel both) or 4 (decrement), got %d", o.Stp_mode)
+	}
+	if o.Stp_mode == StpNone && o.Stp_group != 0 {
+		return errors.New("stp_group is only allowed with an stp_mode")
+	}
+
+	return nil
+}
+
+// ToOrder validates the request and builds the engine order for userID.
+// the user id always comes from the token, never from the request body.
+func (o *TempOrder) ToOrder(userID uint64) (Order, error) {
+	var order Order
+	if err := o.Validate(); err != nil {
+		return order, err
+	}
+	spec := symbols.Lookup(o.Symbol)
+
+	// errors already checked by Validate
+	price, _ := o.priceTicks(spec)
+	qty, _ := o.qtyTicks(spec)
+	display, _ := o.displayTicks(spec)
+
+	order.Order_id = o.Order_id
+	order.Price = price
+	order.Timestamp = o.Timestamp
+	order.User_id = userID
+	order.Shares_qty = uint32(qty)
+	order.Display_qty = uint32(display)
+	order.Symbol = o.Symbol
+	order.Side = o.Side
+	order.Order_type = o.Order_type
+	order.Status = 0 // pending
+	order.Time_in_force = o.Time_in_force
+	order.Expire_at = o.Expire_at
+	order.Stp_mode = o.Stp_mode
+	order.Stp_group = o.Stp_group // filled in from the account by oms.Submit when 0
+	return order, nil
+}
+
+func (o *TempOrder) validateTimeInForce() error {
+	if o.Time_in_force > TifGTD {
+		return fmt.Errorf("time_in_force must be 0 (GTC), 1 (IOC), 2 (FOK), 3 (DAY) or 4 (GTD), got %d", o.Time_in_force)
+	}
+
+	// market orders never rest, so only the immediate flavours make sense
+	marketable := o.Order_type == OrderTypeMarket || o.Order_type == OrderTypeStop
+	if marketable && (o.Time_in_force == TifDAY || o.Time_in_force == TifGTD) {
+		return errors.New("market orders only support GTC, IOC or FOK")
+	}
+
+	if o.Time_in_force == TifGTD {
+		if o.Expire_at == 0 {
+			return errors.New("expire_at is required for GTD orders")
+		}
+		if o.Expire_at <= uint64(time.Now().UnixNano()) {
+			return errors.New("expire_at must be in the future")
+		}
+	} else if o.Expire_at != 0 {
+		return errors.New("expire_at is only allowed for GTD orders")
+	}
+	return nil
+}
+
+func (o *TempOrder) validateDisplayQty(spec symbols.Spec, qty uint64) error {
+	display, err := o.displayTicks(spec)
+	if err != nil || display == 0 {
+		return err
+	}
+	if o.Order_type != OrderTypeLimit && o.Order_type != OrderTypeStopLimit {
+		return errors.New("display_qty is only allowed on limit orders")
+	}
+	// nothing rests, so there is nothing to hide
+	if o.Time_in_force == TifIOC || o.Time_in_force == TifFOK {
+		return errors.New("display_qty is not allowed on IOC or FOK orders")
+	}
+	if display > qty {
+		return errors.New("display_qty must not be above shares_qty")
+	}
+	if display < spec.LotSize {
+		return fmt.Errorf("display_qty must be at least the lot size %s", decimal.Format(spec.LotSize, spec.QtyScale))
+	}
 	return nil
 }
+
+// IsStop reports whether the order is held in the gateway until triggered
+func (o *TempOrder) IsStop() bool {
+	return o.Order_type == OrderTypeStop || o.Order_type == OrderTypeStopLimit
+}
+
+// ToStopOrder is ToOrder for stop and stop-limit orders
+func (o *TempOrder) ToStopOrder(userID uint64) (StopOrder, error) {
+	var stop StopOrder
+	order, err := o.ToOrder(userID)
+	if err != nil {
+		return stop, err
+	}
+	if !o.IsStop() {
+		return stop, errors.New("not a stop order")
+	}
+	stop.Order = order
+	stop.Stop_price, _ = o.stopTicks(symbols.Lookup(o.Symbol))
+	return stop, nil
+}
+
+func (o *TempOrder) priceTicks(spec symbols.Spec) (uint64, error) {
+	// market orders may leave the price out
+	if o.Price.IsZero() && (o.Order_type == OrderTypeMarket || o.Order_type == OrderTypeStop) {
+		return 0, nil
+	}
+	price, err := o.Price.Ticks(spec.PriceScale)
+	if err != nil {
+		return 0, fmt.Errorf("price %q: %w (symbol %d allows %d decimals)", string(o.Price), err, o.Symbol, spec.PriceScale)
+	}
+	return price, nil
+}
+
+func (o *TempOrder) stopTicks(spec symbols.Spec) (uint64, error) {
+	if o.Stop_price.IsZero() {
+		return 0, nil
+	}
+	stop, err := o.Stop_price.Ticks(spec.PriceScale)
+	if err != nil {
+		return 0, fmt.Errorf("stop_price %q: %w (symbol %d allows %d decimals)", string(o.Stop_price), err, o.Symbol, spec.PriceScale)
+	}
+	return stop, nil
+}
+
+func (o *TempOrder) qtyTicks(spec symbols.Spec) (uint64, error) {
+	if o.Shares_qty.IsZero() {
+		return 0, nil
+	}
+	qty, err := o.Shares_qty.Ticks(spec.QtyScale)
+	if err != nil {
+		return 0, fmt.Errorf("shares_qty %q: %w (symbol %d allows %d decimals)", string(o.Shares_qty), err, o.Symbol, spec.QtyScale)
+	}
+	// the engine carries quantity as a u32
+	if qty > math.MaxUint32 {
+		return 0, fmt.Errorf("shares_qty %q: %w", string(o.Shares_qty), decimal.ErrRange)
+	}
+	return qty, nil
+}
+
+func (o *TempOrder) displayTicks(spec symbols.Spec) (uint64, error) {
+	if o.Display_qty.IsZero() {
+		return 0, nil
+	}
+	display, err := o.Display_qty.Ticks(spec.QtyScale)
+	if err != nil {
+		return 0, fmt.Errorf("display_qty %q: %w (symbol %d allows %d decimals)", string(o.Display_qty), err, o.Symbol, spec.QtyScale)
+	}
+	if display > math.MaxUint32 {
+		return 0, fmt.Errorf("display_qty %q: %w", string(o.Display_qty), decimal.ErrRange)
+	}
+	return display, nil
+}
+
+// ToReplace validates an amend against the order it targets and builds the
+// engine message. current must be the open order as tracked by the gateway.
+func (r *TempOrderReplace) ToReplace(current Order) (OrderReplace, error) {
+	var replace OrderReplace
+	if r.Price.IsZero() && r.Shares_qty.IsZero() {
+		return replace, errors.New("price or shares_qty must be specified")
+	}
+	if r.Timestamp == 0 {
+		return replace, errors.New("timestamp must be non-zero")
+	}
+	spec := symbols.Lookup(current.Symbol)
+
+	if !r.Price.IsZero() {
+		if current.Order_type != OrderTypeLimit {
+			return replace, errors.New("only limit orders can change price")
+		}
+		price, err := r.Price.Ticks(spec.PriceScale)
+		if err != nil {
+			return replace, fmt.Errorf("price %q: %w (symbol %d allows %d decimals)", string(r.Price), err, current.Symbol, spec.PriceScale)
+		}
+		if price == 0 {
+			return replace, errors.New("price must be > 0 for limit orders")
+		}
+		replace.New_price = price
+	}
+
+	if !r.Shares_qty.IsZero() {
+		qty, err := r.Shares_qty.Ticks(spec.QtyScale)
+		if err != nil {
+			return replace, fmt.Errorf("shares_qty %q: %w (symbol %d allows %d decimals)", string(r.Shares_qty), err, current.Symbol, spec.QtyScale)
+		}
+		if qty == 0 {
+			return replace, errors.New("shares_qty must be > 0, cancel the order instead")
+		}
+		if qty > math.MaxUint32 {
+			return replace, fmt.Errorf("shares_qty %q: %w", string(r.Shares_qty), decimal.ErrRange)
+		}
+		if qty < uint64(current.Display_qty) {
+			return replace, errors.New("shares_qty must not be below the order's display_qty")
+		}
+		replace.New_qty = uint32(qty)
+	}
+
+	priceUnchanged := replace.New_price == 0 || replace.New_price == current.Price
+	if priceUnchanged && replace.New_qty != 0 && replace.New_qty < current.Shares_qty {
+		replace.Keep_priority = 1
+	}
+
+	replace.Order_id = current.Order_id
+	replace.User_id = current.User_id
+	replace.Symbol = current.Symbol
+	replace.Timestamp = r.Timestamp
+	return replace, nil
+}
@@ -0,0 +1,51 @@
+package structs
+
+import "testing"
+
+func TestToReplace(t *testing.T) {
+	// tracked with what is still working: 10 left of a limit at 123.45
+	current := Order{User_id: 1, Order_id: 7, Symbol: 1, Order_type: OrderTypeLimit, Price: 12345, Shares_qty: 10, Display_qty: 4}
+	market := current
+	market.Order_type = OrderTypeMarket
+	market.Price = 0
+
+	tests := []struct {
+		name    string
+		current Order
+		req     TempOrderReplace
+		ok      bool
+		price   uint64
+		qty     uint32
+		keep    uint8
+	}{
+		{"quantity down keeps priority", current, TempOrderReplace{Shares_qty: "6", Timestamp: 1}, true, 0, 6, 1},
+		{"same price, quantity down", current, TempOrderReplace{Price: "123.45", Shares_qty: "6", Timestamp: 1}, true, 12345, 6, 1},
+		{"quantity up", current, TempOrderReplace{Shares_qty: "11", Timestamp: 1}, true, 0, 11, 0},
+		{"quantity unchanged", current, TempOrderReplace{Shares_qty: "10", Timestamp: 1}, true, 0, 10, 0},
+		{"price change", current, TempOrderReplace{Price: "123.40", Timestamp: 1}, true, 12340, 0, 0},
+		{"price change, quantity down", current, TempOrderReplace{Price: "123.50", Shares_qty: "6", Timestamp: 1}, true, 12350, 6, 0},
+		{"market order quantity down", market, TempOrderReplace{Shares_qty: "6", Timestamp: 1}, true, 0, 6, 1},
+		{"market order price", market, TempOrderReplace{Price: "1", Timestamp: 1}, false, 0, 0, 0},
+		{"nothing to change", current, TempOrderReplace{Timestamp: 1}, false, 0, 0, 0},
+		{"no timestamp", current, TempOrderReplace{Shares_qty: "6"}, false, 0, 0, 0},
+		{"zero quantity", current, TempOrderReplace{Shares_qty: "0", Price: "1", Timestamp: 1}, false, 0, 0, 0},
+		{"below the display quantity", current, TempOrderReplace{Shares_qty: "3", Timestamp: 1}, false, 0, 0, 0},
+		{"too many decimals", current, TempOrderReplace{Price: "123.456", Timestamp: 1}, false, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		r, err := tt.req.ToReplace(tt.current)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if r.New_price != tt.price || r.New_qty != tt.qty || r.Keep_priority != tt.keep {
+			t.Errorf("%s: price %d qty %d keep %d, want %d %d %d", tt.name, r.New_price, r.New_qty, r.Keep_priority, tt.price, tt.qty, tt.keep)
+		}
+		if r.Order_id != 7 || r.User_id != 1 || r.Symbol != 1 {
+			t.Errorf("%s: replace %+v not aimed at the order", tt.name, r)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package symbols
+
+import "sync"
+
+// Spec describes how a symbol's prices and quantities map onto engine ticks.
+// a price of "123.45" with PriceScale 2 goes to the engine as 12345.
+type Spec struct {
+	ID         uint32 `json:"id"`
+	Name       string `json:"name"`
+	PriceScale uint8  `json:"price_scale"`
+	QtyScale   uint8  `json:"qty_scale"`
+	Group      string `json:"group"`    // trading calendar the symbol follows
+	LotSize    uint64 `json:"lot_size"` // smallest tradable quantity, in qty ticks
+}
+
+// used for any symbol that has no row in the symbols table
+var Default = Spec{
+	PriceScale: 2,
+	QtyScale:   0,
+	Group:      "default",
+	LotSize:    1,
+}
+
+var (
+	mu    sync.RWMutex
+	specs = make(map[uint32]Spec)
+)
+
+// Register adds or replaces the spec for a symbol
+func Register(spec Spec) {
+	mu.Lock()
+	specs[spec.ID] = spec
+	mu.Unlock()
+}
+
+// Lookup returns the spec for a symbol, falling back to Default
+func Lookup(id uint32) Spec {
+	mu.RLock()
+	spec, ok := specs[id]
+	mu.RUnlock()
+	if !ok {
+		spec = Default
+		spec.ID = id
+	}
+	return spec
+}
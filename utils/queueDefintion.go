@@ -1,6 +1,17 @@
 package utils
 
 const IncomingOrderQueuePath = "/tmp/IncomingOrders"
+// the engine reports order status updates back on this ring (created by InitQueue)
+const OrderStatusQueuePath = IncomingOrderQueuePath + "_status"
 const CancelOrderQueuePath = "/tmp/CancelOrders"
+const ReplaceOrderQueuePath = "/tmp/ReplaceOrders"
 const QueryQueuePath = "/tmp/queries"
-const QueryResQueuePath = "/tmp/QueryResponse"
\ No newline at end of file
+const QueryResQueuePath = "/tmp/QueryResponse"
+const TradeFeedQueuePath = "/tmp/TradeFeed"
+const FillQueuePath = "/tmp/Fills"
+
+// how long PUT /api/order waits for the engine to answer an amend
+const AmendAckTimeoutMs = 2000
+
+// market buys reserve funds at the last trade plus this many basis points
+const MarketBuyCollarBps = 500